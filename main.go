@@ -19,6 +19,7 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
 	"os"
@@ -42,7 +43,11 @@ func main() {
 	done := make(chan os.Signal, 1)
 	signal.Notify(done, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
 
-	s := pkg.NewServer(pkg.NewOptions(false, driversapi.GroupVersion.Group))
+	opts := pkg.NewOptions(false, driversapi.GroupVersion.Group)
+	flag.StringVar(&opts.DataDir, "data-dir", "", "Directory where the objects are kept across restarts")
+	flag.Parse()
+
+	s := pkg.NewServer(opts)
 	srv, restcfg, err := s.Run()
 	if err != nil {
 		klog.Fatalln(err)
@@ -58,11 +63,15 @@ func main() {
 		klog.Fatalln(err)
 	}
 
-	err = resources.InitCluster(restcfg)
-	if err != nil {
-		klog.Fatalln(err)
+	// a cluster restored from the data dir is already initialized
+	restored := s.CurrentResourceVersion() > 0
+	if !restored {
+		err = resources.InitCluster(restcfg)
+		if err != nil {
+			klog.Fatalln(err)
+		}
+		s.Checkpoint()
 	}
-	s.Checkpoint()
 	err = resources.RegisterCRDs(restcfg, []*apiextensions.CustomResourceDefinition{
 		driversapi.AppRelease{}.CustomResourceDefinition(),
 	})
@@ -85,23 +94,25 @@ func main() {
 	*/
 
 	kc := kubernetes.NewForConfigOrDie(restcfg)
-	ns := &core.Namespace{
-		TypeMeta: metav1.TypeMeta{},
-		ObjectMeta: metav1.ObjectMeta{
-			Name: "demo",
-		},
-	}
-	ns, err = kc.CoreV1().Namespaces().Create(context.TODO(), ns, metav1.CreateOptions{})
-	if err != nil {
-		panic("failed to create ns" + ns.Name)
-	}
+	if !restored {
+		ns := &core.Namespace{
+			TypeMeta: metav1.TypeMeta{},
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "demo",
+			},
+		}
+		ns, err = kc.CoreV1().Namespaces().Create(context.TODO(), ns, metav1.CreateOptions{})
+		if err != nil {
+			panic("failed to create ns" + ns.Name)
+		}
 
-	ns.Labels = map[string]string{
-		"tes": "abc",
-	}
-	ns, err = kc.CoreV1().Namespaces().Update(context.TODO(), ns, metav1.UpdateOptions{})
-	if err != nil {
-		panic("failed to update ns" + ns.Name)
+		ns.Labels = map[string]string{
+			"tes": "abc",
+		}
+		ns, err = kc.CoreV1().Namespaces().Update(context.TODO(), ns, metav1.UpdateOptions{})
+		if err != nil {
+			panic("failed to update ns" + ns.Name)
+		}
 	}
 
 	go func() {
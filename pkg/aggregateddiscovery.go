@@ -0,0 +1,181 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	apidiscoveryv2 "k8s.io/api/apidiscovery/v2"
+	apidiscoveryv2beta1 "k8s.io/api/apidiscovery/v2beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/apiserver/pkg/endpoints/handlers/negotiation"
+)
+
+// discoveryRestrictions allows clients to ask for the aggregated discovery document,
+// e.g. with "Accept: application/json;g=apidiscovery.k8s.io;v=v2;as=APIGroupDiscoveryList".
+type discoveryRestrictions struct{}
+
+var _ negotiation.EndpointRestrictions = discoveryRestrictions{}
+
+func (discoveryRestrictions) AllowsMediaTypeTransform(mimeType, mimeSubType string, target *schema.GroupVersionKind) bool {
+	if target == nil {
+		return true
+	}
+	return target.Group == apidiscoveryv2.SchemeGroupVersion.Group &&
+		(target.Version == apidiscoveryv2.SchemeGroupVersion.Version || target.Version == apidiscoveryv2beta1.SchemeGroupVersion.Version) &&
+		target.Kind == "APIGroupDiscoveryList"
+}
+
+func (discoveryRestrictions) AllowsServerVersion(string) bool { return false }
+
+func (discoveryRestrictions) AllowsStreamSchema(string) bool { return false }
+
+// aggregatedDiscovery writes the aggregated discovery document of the group versions matched by match,
+// if the client asked for it. It returns false if the unaggregated document should be served instead.
+func (s *Server) aggregatedDiscovery(w http.ResponseWriter, r *http.Request, match func(gv schema.GroupVersion) bool) bool {
+	mediaType, ok := negotiation.NegotiateMediaTypeOptions(r.Header.Get("Accept"), s.outputSerializer().SupportedMediaTypes(), discoveryRestrictions{})
+	if !ok || mediaType.Convert == nil {
+		return false
+	}
+
+	list := newAPIGroupDiscoveryList(s.apiResources(match))
+	var obj runtime.Object = list
+	if mediaType.Convert.Version == apidiscoveryv2beta1.SchemeGroupVersion.Version {
+		// the v2beta1 types only differ by their apiVersion
+		var out apidiscoveryv2beta1.APIGroupDiscoveryList
+		data, err := json.Marshal(list)
+		if err == nil {
+			err = json.Unmarshal(data, &out)
+		}
+		if err != nil {
+			writeStatus(w, s.encoder(w, r), err)
+			return true
+		}
+		out.APIVersion = apidiscoveryv2beta1.SchemeGroupVersion.String()
+		obj = &out
+	}
+
+	target := mediaType.Convert
+	w.Header().Set("Content-Type", fmt.Sprintf("%s;g=%s;v=%s;as=%s", mediaType.Accepted.MediaType, target.Group, target.Version, target.Kind))
+	_ = mediaType.Accepted.Serializer.Encode(obj, w)
+	return true
+}
+
+// newAPIGroupDiscoveryList converts the resources of each group version into an aggregated discovery document.
+// Groups are sorted by name and versions by preference.
+func newAPIGroupDiscoveryList(resources map[schema.GroupVersion][]metav1.APIResource) *apidiscoveryv2.APIGroupDiscoveryList {
+	versions := map[string][]string{}
+	for gv := range resources {
+		versions[gv.Group] = append(versions[gv.Group], gv.Version)
+	}
+	groups := make([]string, 0, len(versions))
+	for group := range versions {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+
+	list := &apidiscoveryv2.APIGroupDiscoveryList{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: apidiscoveryv2.SchemeGroupVersion.String(),
+			Kind:       "APIGroupDiscoveryList",
+		},
+		Items: make([]apidiscoveryv2.APIGroupDiscovery, 0, len(groups)),
+	}
+	for _, group := range groups {
+		vs := versions[group]
+		// CRD versions need not follow the Kubernetes version format, e.g. "alpha"
+		sort.Slice(vs, func(i, j int) bool {
+			return version.CompareKubeAwareVersionStrings(vs[i], vs[j]) > 0
+		})
+
+		g := apidiscoveryv2.APIGroupDiscovery{
+			ObjectMeta: metav1.ObjectMeta{Name: group},
+			Versions:   make([]apidiscoveryv2.APIVersionDiscovery, 0, len(vs)),
+		}
+		for _, version := range vs {
+			gv := schema.GroupVersion{Group: group, Version: version}
+			g.Versions = append(g.Versions, apidiscoveryv2.APIVersionDiscovery{
+				Version:   version,
+				Resources: newAPIResourceDiscovery(gv, resources[gv]),
+				Freshness: apidiscoveryv2.DiscoveryFreshnessCurrent,
+			})
+		}
+		list.Items = append(list.Items, g)
+	}
+	return list
+}
+
+// newAPIResourceDiscovery nests the subresources of list, e.g. deployments/status, under their resources.
+func newAPIResourceDiscovery(gv schema.GroupVersion, list []metav1.APIResource) []apidiscoveryv2.APIResourceDiscovery {
+	result := make([]apidiscoveryv2.APIResourceDiscovery, 0, len(list))
+	index := map[string]int{}
+	for _, r := range list {
+		if strings.Contains(r.Name, "/") {
+			continue
+		}
+		scope := apidiscoveryv2.ScopeCluster
+		if r.Namespaced {
+			scope = apidiscoveryv2.ScopeNamespace
+		}
+		index[r.Name] = len(result)
+		result = append(result, apidiscoveryv2.APIResourceDiscovery{
+			Resource: r.Name,
+			ResponseKind: &metav1.GroupVersionKind{
+				Group:   gv.Group,
+				Version: gv.Version,
+				Kind:    r.Kind,
+			},
+			Scope:            scope,
+			SingularResource: r.SingularName,
+			Verbs:            r.Verbs,
+			ShortNames:       r.ShortNames,
+			Categories:       r.Categories,
+		})
+	}
+	for _, r := range list {
+		resource, subresource, found := strings.Cut(r.Name, "/")
+		if !found {
+			continue
+		}
+		i, found := index[resource]
+		if !found {
+			continue
+		}
+		// Group and Version are only set if the subresource returns another kind, like scale
+		group, version := gv.Group, gv.Version
+		if r.Version != "" {
+			group, version = r.Group, r.Version
+		}
+		result[i].Subresources = append(result[i].Subresources, apidiscoveryv2.APISubresourceDiscovery{
+			Subresource: subresource,
+			ResponseKind: &metav1.GroupVersionKind{
+				Group:   group,
+				Version: version,
+				Kind:    r.Kind,
+			},
+			Verbs: r.Verbs,
+		})
+	}
+	return result
+}
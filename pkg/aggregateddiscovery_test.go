@@ -0,0 +1,93 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"context"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/discovery"
+)
+
+const widgetCRD = `{
+	"apiVersion": "apiextensions.k8s.io/v1",
+	"kind": "CustomResourceDefinition",
+	"metadata": {"name": "widgets.example.com"},
+	"spec": {
+		"group": "example.com",
+		"scope": "Namespaced",
+		"names": {"plural": "widgets", "singular": "widget", "kind": "Widget", "listKind": "WidgetList"},
+		"versions": [
+			{"name": "alpha", "served": true, "storage": false, "schema": {"openAPIV3Schema": {"type": "object", "x-kubernetes-preserve-unknown-fields": true}}},
+			{"name": "beta", "served": true, "storage": false, "schema": {"openAPIV3Schema": {"type": "object", "x-kubernetes-preserve-unknown-fields": true}}},
+			{"name": "v1", "served": true, "storage": true, "schema": {"openAPIV3Schema": {"type": "object", "x-kubernetes-preserve-unknown-fields": true}}}
+		]
+	}
+}`
+
+func TestAggregatedDiscoveryNonKubeVersions(t *testing.T) {
+	_, cfg, _, dc := newTestCluster(t)
+	createCRD(t, dc, widgetCRD)
+
+	// the discovery client asks for the aggregated discovery document
+	groups, _, err := discovery.NewDiscoveryClientForConfigOrDie(cfg).ServerGroupsAndResources()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, g := range groups {
+		if g.Name != "example.com" {
+			continue
+		}
+		if len(g.Versions) != 3 || g.PreferredVersion.Version != "v1" {
+			t.Errorf("expected versions v1, alpha and beta with v1 preferred, got %+v", g)
+		}
+		return
+	}
+	t.Error("missing group example.com")
+}
+
+func TestLegacyDiscoveryNonKubeVersions(t *testing.T) {
+	_, cfg, _, dc := newTestCluster(t)
+	createCRD(t, dc, widgetCRD)
+
+	client := discovery.NewDiscoveryClientForConfigOrDie(cfg).WithLegacy()
+	groups, err := client.ServerGroups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, g := range groups.Groups {
+		if g.Name == "example.com" {
+			found = true
+			if g.PreferredVersion.Version != "v1" {
+				t.Errorf("expected v1 to be preferred, got %+v", g)
+			}
+		}
+	}
+	if !found {
+		t.Error("missing group example.com in /apis")
+	}
+
+	var group metav1.APIGroup
+	if err := client.RESTClient().Get().AbsPath("/apis/example.com").Do(context.TODO()).Into(&group); err != nil {
+		t.Fatal(err)
+	}
+	if len(group.Versions) != 3 || group.PreferredVersion.Version != "v1" {
+		t.Errorf("expected versions v1, alpha and beta with v1 preferred, got %+v", group)
+	}
+}
@@ -20,13 +20,14 @@ import (
 	"net/http"
 	"sort"
 
-	"kmodules.xyz/apiversion"
-	"kmodules.xyz/resource-metadata/apis/meta/v1alpha1"
+	kmapi "kmodules.xyz/client-go/api/v1"
 
 	"github.com/go-chi/chi/v5"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/version"
 )
 
 func (s *Server) APIGroup(w http.ResponseWriter, r *http.Request) {
@@ -51,19 +52,19 @@ func (s *Server) APIGroup(w http.ResponseWriter, r *http.Request) {
 	*/
 
 	versions := sets.NewString()
-	s.reg.Visit(func(_ string, rd *v1alpha1.ResourceDescriptor) {
-		if rd.Spec.Resource.Name == "" {
-			return
-		}
-
-		if rd.Spec.Resource.Group == group {
-			versions.Insert(rd.Spec.Resource.Version)
+	s.visitResources(func(rid kmapi.ResourceID) {
+		if rid.Group == group {
+			versions.Insert(rid.Version)
 		}
 	})
 
+	if versions.Len() == 0 {
+		writeStatus(w, s.encoder(w, r), apierrors.NewNotFound(schema.GroupResource{}, group))
+		return
+	}
 	list := versions.UnsortedList()
 	sort.Slice(list, func(i, j int) bool {
-		return apiversion.MustCompare(list[i], list[j]) > 0
+		return version.CompareKubeAwareVersionStrings(list[i], list[j]) > 0
 	})
 
 	resp := metav1.APIGroup{
@@ -85,5 +86,10 @@ func (s *Server) APIGroup(w http.ResponseWriter, r *http.Request) {
 	}
 	resp.PreferredVersion = resp.Versions[0]
 
-	_ = s.encoder(w, r).Encode(&resp, w)
+	encoder, err := s.negotiateEncoder(w, r)
+	if err != nil {
+		writeStatus(w, s.encoder(w, r), err)
+		return
+	}
+	_ = encoder.Encode(&resp, w)
 }
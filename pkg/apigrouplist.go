@@ -20,15 +20,19 @@ import (
 	"net/http"
 	"sort"
 
-	"kmodules.xyz/apiversion"
-	"kmodules.xyz/resource-metadata/apis/meta/v1alpha1"
+	kmapi "kmodules.xyz/client-go/api/v1"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/version"
 )
 
 func (s *Server) APIGroupList(w http.ResponseWriter, r *http.Request) {
+	if s.aggregatedDiscovery(w, r, func(gv schema.GroupVersion) bool { return gv.Group != "" }) {
+		return
+	}
+
 	resp := metav1.APIGroupList{
 		TypeMeta: metav1.TypeMeta{
 			APIVersion: "v1",
@@ -111,28 +115,24 @@ func (s *Server) APIGroupList(w http.ResponseWriter, r *http.Request) {
 	*/
 
 	groups := map[string]sets.Set[string]{}
-	s.reg.Visit(func(_ string, rd *v1alpha1.ResourceDescriptor) {
-		if rd.Spec.Resource.Name == "" {
-			return
-		}
-
-		if rd.Spec.Resource.Group == "" {
+	s.visitResources(func(rid kmapi.ResourceID) {
+		if rid.Group == "" {
 			return
 		}
 
-		apiGroup, exists := groups[rd.Spec.Resource.Group]
+		apiGroup, exists := groups[rid.Group]
 		if !exists {
 			apiGroup = sets.New[string]()
 		}
-		apiGroup.Insert(rd.Spec.Resource.Version)
-		groups[rd.Spec.Resource.Group] = apiGroup
+		apiGroup.Insert(rid.Version)
+		groups[rid.Group] = apiGroup
 	})
 
 	resp.Groups = make([]metav1.APIGroup, 0, len(groups))
 	for group, versions := range groups {
 		list := versions.UnsortedList()
 		sort.Slice(list, func(i, j int) bool {
-			return apiversion.MustCompare(list[i], list[j]) > 0
+			return version.CompareKubeAwareVersionStrings(list[i], list[j]) > 0
 		})
 
 		apiGroup := metav1.APIGroup{
@@ -152,5 +152,10 @@ func (s *Server) APIGroupList(w http.ResponseWriter, r *http.Request) {
 		resp.Groups = append(resp.Groups, apiGroup)
 	}
 
-	_ = s.encoder(w, r).Encode(&resp, w)
+	encoder, err := s.negotiateEncoder(w, r)
+	if err != nil {
+		writeStatus(w, s.encoder(w, r), err)
+		return
+	}
+	_ = encoder.Encode(&resp, w)
 }
@@ -21,10 +21,12 @@ import (
 	"sort"
 	"strings"
 
+	"kmodules.xyz/fake-apiserver/pkg/resources"
+
 	kmapi "kmodules.xyz/client-go/api/v1"
-	"kmodules.xyz/resource-metadata/apis/meta/v1alpha1"
 
 	"github.com/go-chi/chi/v5"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 )
@@ -71,36 +73,89 @@ func (s *Server) APIResourceList(w http.ResponseWriter, r *http.Request) {
 	     "storageVersionHash": "dd7pWHUlMKQ="
 	   },
 	*/
-	s.reg.Visit(func(_ string, rd *v1alpha1.ResourceDescriptor) {
-		if rd.Spec.Resource.Name == "" {
+	list, found := s.apiResources(func(in schema.GroupVersion) bool {
+		return in == gv
+	})[gv]
+	if !found {
+		writeStatus(w, s.encoder(w, r), apierrors.NewNotFound(schema.GroupResource{}, gv.String()))
+		return
+	}
+	resp.APIResources = list
+
+	encoder, err := s.negotiateEncoder(w, r)
+	if err != nil {
+		writeStatus(w, s.encoder(w, r), err)
+		return
+	}
+	_ = encoder.Encode(&resp, w)
+}
+
+// apiResources returns the resources and subresources served for the group versions matched by match.
+func (s *Server) apiResources(match func(gv schema.GroupVersion) bool) map[schema.GroupVersion][]metav1.APIResource {
+	crds := s.crds()
+	result := map[schema.GroupVersion][]metav1.APIResource{}
+	s.visitResources(func(rid kmapi.ResourceID) {
+		gv := rid.GroupVersion()
+		if !match(gv) {
 			return
 		}
 
-		if rd.Spec.Resource.GroupVersion() == gv {
-			resp.APIResources = append(resp.APIResources, metav1.APIResource{
-				Name:         rd.Spec.Resource.Name,
-				SingularName: strings.ToLower(rd.Spec.Resource.Kind),
-				Namespaced:   rd.Spec.Resource.Scope == kmapi.NamespaceScoped,
-				Group:        rd.Spec.Resource.Group,
-				Kind:         rd.Spec.Resource.Kind,
-				Verbs: []string{
-					"create",
-					"delete",
-					"deletecollection",
-					"get",
-					"list",
-					"patch",
-					"update",
-				},
-				ShortNames:         nil,
-				Categories:         nil,
-				StorageVersionHash: "",
+		gr := schema.GroupResource{Group: rid.Group, Resource: rid.Name}
+		namespaced := rid.Scope == kmapi.NamespaceScoped
+		resource := metav1.APIResource{
+			Name:         rid.Name,
+			SingularName: strings.ToLower(rid.Kind),
+			Namespaced:   namespaced,
+			Group:        rid.Group,
+			Kind:         rid.Kind,
+			Verbs:        resources.Verbs(gr),
+			ShortNames:   resources.ShortNames(gr),
+			Categories:   resources.Categories(gr),
+		}
+		hasStatus := resources.HasStatusSubresource(gr)
+		hasScale := resources.HasScaleSubresource(gr)
+		if crd, found := crds[gr]; found {
+			if crd.Spec.Names.Singular != "" {
+				resource.SingularName = crd.Spec.Names.Singular
+			}
+			resource.ShortNames = crd.Spec.Names.ShortNames
+			resource.Categories = crd.Spec.Names.Categories
+			hasStatus = crdHasStatus(crd, gv.Version)
+			_, hasScale = crdScale(crd, gv.Version)
+		}
+		result[gv] = append(result[gv], resource)
+
+		if hasStatus {
+			result[gv] = append(result[gv], metav1.APIResource{
+				Name:       rid.Name + "/status",
+				Namespaced: namespaced,
+				Group:      rid.Group,
+				Kind:       rid.Kind,
+				Verbs:      []string{"get", "patch", "update"},
+			})
+		}
+		if hasScale {
+			result[gv] = append(result[gv], metav1.APIResource{
+				Name:       rid.Name + "/scale",
+				Namespaced: namespaced,
+				Group:      scaleGVK.Group,
+				Version:    scaleGVK.Version,
+				Kind:       scaleGVK.Kind,
+				Verbs:      []string{"get", "patch", "update"},
+			})
+		}
+		if gr == (schema.GroupResource{Resource: "namespaces"}) {
+			result[gv] = append(result[gv], metav1.APIResource{
+				Name:  "namespaces/finalize",
+				Kind:  rid.Kind,
+				Verbs: []string{"update"},
 			})
 		}
 	})
-	sort.Slice(resp.APIResources, func(i, j int) bool {
-		return resp.APIResources[i].Name < resp.APIResources[j].Name
-	})
-
-	_ = s.encoder(w, r).Encode(&resp, w)
+	for _, list := range result {
+		sort.Slice(list, func(i, j int) bool {
+			return list[i].Name < list[j].Name
+		})
+	}
+	return result
 }
@@ -0,0 +1,62 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/discovery"
+)
+
+func TestAPIResourceList(t *testing.T) {
+	_, cfg, _, _ := newTestCluster(t)
+
+	for _, client := range []discovery.DiscoveryInterface{
+		discovery.NewDiscoveryClientForConfigOrDie(cfg),
+		discovery.NewDiscoveryClientForConfigOrDie(cfg).WithLegacy(),
+	} {
+		list, err := client.ServerResourcesForGroupVersion("apps/v1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		served := map[string]metav1.APIResource{}
+		for _, r := range list.APIResources {
+			served[r.Name] = r
+		}
+
+		deploy, found := served["deployments"]
+		if !found {
+			t.Fatal("missing resource deployments")
+		}
+		if !sets.New(deploy.Verbs...).HasAll("create", "get", "list", "watch", "patch", "update", "delete", "deletecollection") {
+			t.Errorf("expected all verbs for deployments, got %v", deploy.Verbs)
+		}
+		if !sets.New(deploy.ShortNames...).Has("deploy") || !sets.New(deploy.Categories...).Has("all") {
+			t.Errorf("expected short name deploy and category all, got %v and %v", deploy.ShortNames, deploy.Categories)
+		}
+		for _, sub := range []string{"deployments/status", "deployments/scale"} {
+			if _, found := served[sub]; !found {
+				t.Errorf("missing subresource %s", sub)
+			}
+		}
+		if _, found := served["daemonsets/scale"]; found {
+			t.Error("expected daemonsets/scale not to be listed, as it is not served")
+		}
+	}
+}
@@ -20,9 +20,14 @@ import (
 	"net/http"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
 func (s *Server) APIVersions(w http.ResponseWriter, r *http.Request) {
+	if s.aggregatedDiscovery(w, r, func(gv schema.GroupVersion) bool { return gv.Group == "" }) {
+		return
+	}
+
 	/*
 			`{
 		  "kind": "APIVersions",
@@ -46,5 +51,10 @@ func (s *Server) APIVersions(w http.ResponseWriter, r *http.Request) {
 			"v1",
 		},
 	}
-	_ = s.encoder(w, r).Encode(&resp, w)
+	encoder, err := s.negotiateEncoder(w, r)
+	if err != nil {
+		writeStatus(w, s.encoder(w, r), err)
+		return
+	}
+	_ = encoder.Encode(&resp, w)
 }
@@ -0,0 +1,312 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"github.com/go-chi/chi/v5"
+	"gomodules.xyz/jsonpatch/v2"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var checkpointGR = schema.GroupResource{Resource: "checkpoints"}
+
+// CheckpointInfo describes a named checkpoint.
+type CheckpointInfo struct {
+	Name            string      `json:"name"`
+	ResourceVersion int64       `json:"resourceVersion"`
+	CreatedAt       metav1.Time `json:"createdAt"`
+}
+
+type checkpoint struct {
+	CheckpointInfo
+	snapshot *Snapshot
+}
+
+// Diff is the list of changes between two states of the server, sorted by resource, namespace and name.
+type Diff struct {
+	Created []ObjectDiff `json:"created,omitempty"`
+	Updated []ObjectDiff `json:"updated,omitempty"`
+	Deleted []ObjectDiff `json:"deleted,omitempty"`
+}
+
+// ObjectDiff is the change of an object. Created objects carry the new object, deleted objects
+// the removed one, and updated objects the JSON patch from the old to the new object.
+type ObjectDiff struct {
+	Group     string                     `json:"group,omitempty"`
+	Resource  string                     `json:"resource"`
+	Namespace string                     `json:"namespace,omitempty"`
+	Name      string                     `json:"name"`
+	Object    *unstructured.Unstructured `json:"object,omitempty"`
+	Patch     []jsonpatch.Operation      `json:"patch,omitempty"`
+}
+
+// SaveCheckpoint saves the current objects as the checkpoint name, replacing any checkpoint
+// of the same name. Checkpoints are kept in memory.
+func (s *Server) SaveCheckpoint(name string) CheckpointInfo {
+	snap := s.Snapshot()
+	for _, rs := range snap.Resources {
+		for i, obj := range rs.Items {
+			rs.Items[i] = obj.DeepCopy()
+		}
+	}
+	cp := &checkpoint{
+		CheckpointInfo: CheckpointInfo{
+			Name:            name,
+			ResourceVersion: snap.ResourceVersion,
+			CreatedAt:       metav1.Now(),
+		},
+		snapshot: snap,
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.checkpoints[name] = cp
+	return cp.CheckpointInfo
+}
+
+// Checkpoints returns the named checkpoints, oldest first.
+func (s *Server) Checkpoints() []CheckpointInfo {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	result := make([]CheckpointInfo, 0, len(s.checkpoints))
+	for _, cp := range s.checkpoints {
+		result = append(result, cp.CheckpointInfo)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ResourceVersion < result[j].ResourceVersion ||
+			result[i].ResourceVersion == result[j].ResourceVersion && result[i].Name < result[j].Name
+	})
+	return result
+}
+
+func (s *Server) RemoveCheckpoint(name string) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if _, found := s.checkpoints[name]; !found {
+		return apierrors.NewNotFound(checkpointGR, name)
+	}
+	delete(s.checkpoints, name)
+	return nil
+}
+
+func (s *Server) checkpoint(name string) (*checkpoint, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	cp, found := s.checkpoints[name]
+	if !found {
+		return nil, apierrors.NewNotFound(checkpointGR, name)
+	}
+	return cp, nil
+}
+
+// Rollback restores the objects of the checkpoint name. Like Restore, it terminates the running
+// watches, but the resourceVersion of the server is kept, so that it never goes back.
+func (s *Server) Rollback(name string) error {
+	cp, err := s.checkpoint(name)
+	if err != nil {
+		return err
+	}
+	return s.Restore(cp.snapshot)
+}
+
+// Diff returns the changes from the checkpoint from to the checkpoint to. The current
+// objects are used in place of an empty checkpoint name.
+func (s *Server) Diff(from, to string) (*Diff, error) {
+	snapshot := func(name string) (*Snapshot, error) {
+		if name == "" {
+			return s.Snapshot(), nil
+		}
+		cp, err := s.checkpoint(name)
+		if err != nil {
+			return nil, err
+		}
+		return cp.snapshot, nil
+	}
+	a, err := snapshot(from)
+	if err != nil {
+		return nil, err
+	}
+	b, err := snapshot(to)
+	if err != nil {
+		return nil, err
+	}
+	return diffSnapshots(a, b)
+}
+
+type objectKey struct {
+	schema.GroupResource
+	Namespace string
+	Name      string
+}
+
+func (k objectKey) less(other objectKey) bool {
+	if k.GroupResource != other.GroupResource {
+		return k.GroupResource.String() < other.GroupResource.String()
+	}
+	if k.Namespace != other.Namespace {
+		return k.Namespace < other.Namespace
+	}
+	return k.Name < other.Name
+}
+
+func (k objectKey) diff() ObjectDiff {
+	return ObjectDiff{
+		Group:     k.Group,
+		Resource:  k.Resource,
+		Namespace: k.Namespace,
+		Name:      k.Name,
+	}
+}
+
+func snapshotObjects(snap *Snapshot) map[objectKey]*unstructured.Unstructured {
+	objs := map[objectKey]*unstructured.Unstructured{}
+	for _, rs := range snap.Resources {
+		for _, obj := range rs.Items {
+			objs[objectKey{GroupResource: rs.GroupResource(), Namespace: obj.GetNamespace(), Name: obj.GetName()}] = obj
+		}
+	}
+	return objs
+}
+
+// diffSnapshots returns the changes from a to b. An object that was deleted and created
+// again, and so has a new uid, is reported as deleted and created.
+func diffSnapshots(a, b *Snapshot) (*Diff, error) {
+	before := snapshotObjects(a)
+	after := snapshotObjects(b)
+
+	keys := make([]objectKey, 0, len(before)+len(after))
+	for key := range before {
+		keys = append(keys, key)
+	}
+	for key := range after {
+		if _, found := before[key]; !found {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].less(keys[j])
+	})
+
+	var diff Diff
+	for _, key := range keys {
+		old, existed := before[key]
+		cur, exists := after[key]
+		if existed && exists && old.GetUID() != cur.GetUID() {
+			d := key.diff()
+			d.Object = old
+			diff.Deleted = append(diff.Deleted, d)
+			existed = false
+		}
+		switch {
+		case !existed:
+			d := key.diff()
+			d.Object = cur
+			diff.Created = append(diff.Created, d)
+		case !exists:
+			d := key.diff()
+			d.Object = old
+			diff.Deleted = append(diff.Deleted, d)
+		case old.GetResourceVersion() != cur.GetResourceVersion():
+			patch, err := createPatch(old, cur)
+			if err != nil {
+				return nil, err
+			}
+			if len(patch) == 0 {
+				continue
+			}
+			d := key.diff()
+			d.Patch = patch
+			diff.Updated = append(diff.Updated, d)
+		}
+	}
+	return &diff, nil
+}
+
+func createPatch(old, cur *unstructured.Unstructured) ([]jsonpatch.Operation, error) {
+	a, err := json.Marshal(old.Object)
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(cur.Object)
+	if err != nil {
+		return nil, err
+	}
+	patch, err := jsonpatch.CreatePatch(a, b)
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(jsonpatch.ByPath(patch))
+	return patch, nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", runtime.ContentTypeJSON)
+	w.WriteHeader(code)
+	_, _ = w.Write(data)
+}
+
+func (s *Server) ListCheckpoints(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.Checkpoints())
+}
+
+func (s *Server) CreateCheckpoint(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusCreated, s.SaveCheckpoint(chi.URLParam(r, "name")))
+}
+
+func (s *Server) DeleteCheckpoint(w http.ResponseWriter, r *http.Request) {
+	if err := s.RemoveCheckpoint(chi.URLParam(r, "name")); err != nil {
+		writeStatus(w, s.encoder(w, r), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DiffCheckpoint writes the changes from the checkpoint in the URL to the checkpoint in the
+// "to" query parameter, or to the current objects if it is not set.
+func (s *Server) DiffCheckpoint(w http.ResponseWriter, r *http.Request) {
+	diff, err := s.Diff(chi.URLParam(r, "name"), r.URL.Query().Get("to"))
+	if err != nil {
+		writeStatus(w, s.encoder(w, r), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, diff)
+}
+
+func (s *Server) RollbackCheckpoint(w http.ResponseWriter, r *http.Request) {
+	if err := s.Rollback(chi.URLParam(r, "name")); err != nil {
+		writeStatus(w, s.encoder(w, r), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
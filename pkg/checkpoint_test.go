@@ -0,0 +1,155 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRollback(t *testing.T) {
+	s, cfg, kc, _ := newTestCluster(t)
+	ctx := context.TODO()
+	cms := kc.CoreV1().ConfigMaps(metav1.NamespaceDefault)
+
+	keep := createConfigMap(t, kc, "keep")
+	createConfigMap(t, kc, "gone")
+	s.SaveCheckpoint("base")
+
+	keep.Data = map[string]string{"a": "1"}
+	if _, err := cms.Update(ctx, keep, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	deleteConfigMap(t, kc, "gone", metav1.DeletePropagationBackground)
+	createConfigMap(t, kc, "new")
+
+	diff, err := s.Diff("base", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff.Created) != 1 || len(diff.Updated) != 1 || len(diff.Deleted) != 1 {
+		t.Errorf("expected one created, updated and deleted object, got %+v", diff)
+	}
+
+	rv := s.CurrentResourceVersion()
+	resp, err := http.Post(cfg.Host+"/checkpoints/base/rollback", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected status %d, got %d", http.StatusNoContent, resp.StatusCode)
+	}
+
+	if cm := getConfigMap(t, kc, "keep"); cm == nil || len(cm.Data) != 0 {
+		t.Errorf("expected keep to be rolled back, got %v", cm)
+	}
+	if getConfigMap(t, kc, "gone") == nil {
+		t.Error("expected gone to be restored")
+	}
+	if getConfigMap(t, kc, "new") != nil {
+		t.Error("expected new to be removed")
+	}
+	// the resourceVersion never goes back
+	if s.CurrentResourceVersion() < rv {
+		t.Errorf("expected the resourceVersion to stay at or above %d, got %d", rv, s.CurrentResourceVersion())
+	}
+}
+
+func TestRollbackWithConcurrentWrites(t *testing.T) {
+	s, _, kc, _ := newTestCluster(t)
+	ctx := context.TODO()
+	s.SaveCheckpoint("base")
+
+	var (
+		wg      sync.WaitGroup
+		m       sync.Mutex
+		created = map[string]int64{}
+	)
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 25; i++ {
+				cm := &core.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("cm-%d-%d", w, i)}}
+				cm, err := kc.CoreV1().ConfigMaps(metav1.NamespaceDefault).Create(ctx, cm, metav1.CreateOptions{})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				rv, _ := strconv.ParseInt(cm.ResourceVersion, 10, 64)
+				m.Lock()
+				created[cm.Name] = rv
+				m.Unlock()
+			}
+		}()
+	}
+	for i := 0; i < 5; i++ {
+		if err := s.Rollback("base"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+
+	// every write either happened before the last rollback and was rolled back,
+	// or happened after it and was kept
+	var lastLost, firstKept int64 = 0, -1
+	for name, rv := range created {
+		if getConfigMap(t, kc, name) == nil {
+			lastLost = max(lastLost, rv)
+		} else if firstKept < 0 || rv < firstKept {
+			firstKept = rv
+		}
+	}
+	if firstKept >= 0 && lastLost > firstKept {
+		t.Errorf("the write at resourceVersion %d was lost, although the write at %d was kept", lastLost, firstKept)
+	}
+}
+
+func TestWritesWaitForRestore(t *testing.T) {
+	s, _, kc, _ := newTestCluster(t)
+
+	// hold the lock that Restore takes, as if a restore was in progress
+	s.restoring.Lock()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		createConfigMap(t, kc, "blocked")
+	}()
+	select {
+	case <-done:
+		t.Fatal("expected the write to wait for the restore")
+	case <-time.After(200 * time.Millisecond):
+	}
+	// reads are not blocked
+	if getConfigMap(t, kc, "blocked") != nil {
+		t.Error("expected blocked not to be created yet")
+	}
+	s.restoring.Unlock()
+	<-done
+	if getConfigMap(t, kc, "blocked") == nil {
+		t.Error("expected blocked to be created after the restore")
+	}
+}
@@ -0,0 +1,353 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"kmodules.xyz/fake-apiserver/pkg/resources"
+
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/uuid"
+)
+
+// conversionWebhookTimeout is the timeout of calls to conversion webhooks.
+const conversionWebhookTimeout = 30 * time.Second
+
+// customResourceDefinition returns the CustomResourceDefinition of gr, if gr is a served custom resource.
+func (s *Server) customResourceDefinition(gr schema.GroupResource) (*apiextensionsv1.CustomResourceDefinition, bool) {
+	s.m.Lock()
+	custom := len(s.crdResources[gr]) > 0
+	s.m.Unlock()
+	if !custom {
+		return nil, false
+	}
+	return s.crdFor(gr)
+}
+
+// storageVersion returns the version the objects of gr are persisted at, which is the storage
+// version of its CRD for custom resources and the latest version for built-in resources.
+func (s *Server) storageVersion(gr schema.GroupResource) (schema.GroupVersion, bool) {
+	s.m.Lock()
+	rids := s.servedResources(gr)
+	s.m.Unlock()
+	if len(rids) == 0 {
+		return schema.GroupVersion{}, false
+	}
+
+	crd, custom := s.customResourceDefinition(gr)
+	if !custom {
+		return rids[0].GroupVersion(), true
+	}
+	version, found := resources.StorageVersion(crd)
+	if !found {
+		return schema.GroupVersion{}, false
+	}
+	return schema.GroupVersion{Group: crd.Spec.Group, Version: version}, true
+}
+
+// convert returns objs converted to version gv of resource gr. Custom resources are converted with
+// the conversion strategy of their CustomResourceDefinition, and built-in resources with the conversions
+// of the scheme. Objects already at gv are returned as is. The given objects are never modified.
+func (s *Server) convert(gr schema.GroupResource, objs []*unstructured.Unstructured, gv schema.GroupVersion) ([]*unstructured.Unstructured, error) {
+	apiVersion := gv.String()
+	var pending []int
+	for i, obj := range objs {
+		if obj.GetAPIVersion() != apiVersion {
+			pending = append(pending, i)
+		}
+	}
+	if len(pending) == 0 {
+		return objs, nil
+	}
+
+	in := make([]*unstructured.Unstructured, len(pending))
+	for i, j := range pending {
+		in[i] = objs[j]
+	}
+	out := make([]*unstructured.Unstructured, len(in))
+	crd, custom := s.customResourceDefinition(gr)
+	switch {
+	case custom && crd.Spec.Conversion != nil && crd.Spec.Conversion.Strategy == apiextensionsv1.WebhookConverter:
+		var err error
+		out, err = s.convertWithWebhook(crd.Name, crd.Spec.Conversion.Webhook, in, apiVersion)
+		if err != nil {
+			return nil, apierrors.NewInternalError(fmt.Errorf("conversion webhook for %v failed: %v", in[0].GroupVersionKind(), err))
+		}
+	case custom:
+		// the None strategy only changes the apiVersion
+		for i, obj := range in {
+			out[i] = obj.DeepCopy()
+			out[i].SetAPIVersion(apiVersion)
+		}
+	default:
+		for i, obj := range in {
+			converted, err := s.convertObject(obj, gv)
+			if err != nil {
+				return nil, apierrors.NewInternalError(fmt.Errorf("failed to convert %v to %s: %v", obj.GroupVersionKind(), apiVersion, err))
+			}
+			out[i] = converted
+		}
+	}
+
+	result := slices.Clone(objs)
+	for i, j := range pending {
+		result[j] = out[i]
+	}
+	return result, nil
+}
+
+// convertObject converts obj to gv with the conversions registered in the scheme, through the internal
+// version of its group if the scheme has one. The versions of a built-in kind without conversions are
+// only served if they have the same fields, so only the apiVersion of their objects is changed.
+// obj itself is returned if it is already at gv.
+func (s *Server) convertObject(obj *unstructured.Unstructured, gv schema.GroupVersion) (*unstructured.Unstructured, error) {
+	scheme := s.opts.Scheme
+	gvk := obj.GroupVersionKind()
+	if gvk.GroupVersion() == gv {
+		return obj, nil
+	}
+	rewrite := func() (*unstructured.Unstructured, error) {
+		out := obj.DeepCopy()
+		out.SetAPIVersion(gv.String())
+		return out, nil
+	}
+	if !scheme.Recognizes(gvk) || !scheme.Recognizes(gv.WithKind(gvk.Kind)) {
+		return rewrite()
+	}
+
+	in, err := scheme.New(gvk)
+	if err != nil {
+		return nil, err
+	}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.UnstructuredContent(), in); err != nil {
+		return nil, err
+	}
+	if internal := (schema.GroupVersion{Group: gvk.Group, Version: runtime.APIVersionInternal}); scheme.Recognizes(internal.WithKind(gvk.Kind)) {
+		in, err = scheme.ConvertToVersion(in, internal)
+		if err != nil {
+			return nil, err
+		}
+	}
+	out, err := scheme.ConvertToVersion(in, gv)
+	if err != nil {
+		// there is no conversion between the versions of the kind
+		return rewrite()
+	}
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(out)
+	if err != nil {
+		return nil, err
+	}
+	result := &unstructured.Unstructured{Object: content}
+	result.SetGroupVersionKind(gv.WithKind(gvk.Kind))
+	return result, nil
+}
+
+// convertWithWebhook sends objs in a ConversionReview to the conversion webhook and returns the converted objects.
+// Like the apiextensions-apiserver, the webhook may only change the labels and annotations of the metadata.
+func (s *Server) convertWithWebhook(crdName string, webhook *apiextensionsv1.WebhookConversion, objs []*unstructured.Unstructured, apiVersion string) ([]*unstructured.Unstructured, error) {
+	if webhook == nil || webhook.ClientConfig == nil {
+		return nil, errors.New("missing webhook client config")
+	}
+	var reviewVersion string
+	for _, v := range webhook.ConversionReviewVersions {
+		if v == "v1" || v == "v1beta1" {
+			reviewVersion = v
+			break
+		}
+	}
+	if reviewVersion == "" {
+		return nil, fmt.Errorf("none of the conversionReviewVersions %v are supported, expected v1 or v1beta1", webhook.ConversionReviewVersions)
+	}
+	url, err := webhookURL(webhook.ClientConfig)
+	if err != nil {
+		return nil, err
+	}
+	client, err := s.webhookClients.get(crdName, webhook.ClientConfig.CABundle)
+	if err != nil {
+		return nil, err
+	}
+
+	review := apiextensionsv1.ConversionReview{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: apiextensionsv1.SchemeGroupVersion.Group + "/" + reviewVersion,
+			Kind:       "ConversionReview",
+		},
+		Request: &apiextensionsv1.ConversionRequest{
+			UID:               uuid.NewUUID(),
+			DesiredAPIVersion: apiVersion,
+			Objects:           make([]runtime.RawExtension, len(objs)),
+		},
+	}
+	for i, obj := range objs {
+		raw, err := obj.MarshalJSON()
+		if err != nil {
+			return nil, err
+		}
+		review.Request.Objects[i].Raw = raw
+	}
+	body, err := json.Marshal(&review)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response status %s", resp.Status)
+	}
+	var result apiextensionsv1.ConversionReview
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	if result.Response == nil {
+		return nil, errors.New("no response provided")
+	}
+	if result.Response.UID != review.Request.UID {
+		return nil, fmt.Errorf("expected response uid %q, got %q", review.Request.UID, result.Response.UID)
+	}
+	if result.Response.Result.Status != metav1.StatusSuccess {
+		return nil, errors.New(result.Response.Result.Message)
+	}
+	if len(result.Response.ConvertedObjects) != len(objs) {
+		return nil, fmt.Errorf("expected %d converted objects, got %d", len(objs), len(result.Response.ConvertedObjects))
+	}
+
+	out := make([]*unstructured.Unstructured, len(objs))
+	for i, raw := range result.Response.ConvertedObjects {
+		var converted unstructured.Unstructured
+		if err := converted.UnmarshalJSON(raw.Raw); err != nil {
+			return nil, fmt.Errorf("invalid converted object at index %d: %v", i, err)
+		}
+		if converted.GetAPIVersion() != apiVersion {
+			return nil, fmt.Errorf("invalid apiVersion %q of converted object at index %d, expected %q", converted.GetAPIVersion(), i, apiVersion)
+		}
+		if converted.GetKind() != objs[i].GetKind() {
+			return nil, fmt.Errorf("invalid kind %q of converted object at index %d, expected %q", converted.GetKind(), i, objs[i].GetKind())
+		}
+		labels, annotations := converted.GetLabels(), converted.GetAnnotations()
+		converted.Object["metadata"] = runtime.DeepCopyJSONValue(objs[i].Object["metadata"])
+		converted.SetLabels(labels)
+		converted.SetAnnotations(annotations)
+		out[i] = &converted
+	}
+	return out, nil
+}
+
+// webhookURL returns the URL of a webhook, which is either given or the address of a Service in the cluster.
+func webhookURL(cfg *apiextensionsv1.WebhookClientConfig) (string, error) {
+	if cfg.URL != nil {
+		return *cfg.URL, nil
+	}
+	if cfg.Service == nil {
+		return "", errors.New("neither url nor service is set in the webhook client config")
+	}
+	port := int32(443)
+	if cfg.Service.Port != nil {
+		port = *cfg.Service.Port
+	}
+	var path string
+	if cfg.Service.Path != nil {
+		path = *cfg.Service.Path
+	}
+	host := cfg.Service.Name + "." + cfg.Service.Namespace + ".svc:" + strconv.Itoa(int(port))
+	return "https://" + host + path, nil
+}
+
+// webhookClient returns a client that trusts caBundle, or the system roots if it is empty.
+func webhookClient(caBundle []byte) (*http.Client, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if len(caBundle) > 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caBundle) {
+			return nil, errors.New("invalid caBundle in the webhook client config")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return &http.Client{
+		Timeout: conversionWebhookTimeout,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
+		},
+	}, nil
+}
+
+// webhookClientCache holds the client of the conversion webhook of every CustomResourceDefinition,
+// so that the connections to a webhook are reused instead of leaking a transport per conversion.
+type webhookClientCache struct {
+	m       sync.Mutex
+	clients map[string]*cachedWebhookClient
+}
+
+type cachedWebhookClient struct {
+	caBundle []byte
+	client   *http.Client
+}
+
+// get returns the client of the conversion webhook of the CustomResourceDefinition named crd.
+// The client is created again if the caBundle of the webhook changed.
+func (c *webhookClientCache) get(crd string, caBundle []byte) (*http.Client, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if cached, found := c.clients[crd]; found {
+		if bytes.Equal(cached.caBundle, caBundle) {
+			return cached.client, nil
+		}
+		cached.client.CloseIdleConnections()
+		delete(c.clients, crd)
+	}
+	client, err := webhookClient(caBundle)
+	if err != nil {
+		return nil, err
+	}
+	if c.clients == nil {
+		c.clients = map[string]*cachedWebhookClient{}
+	}
+	c.clients[crd] = &cachedWebhookClient{caBundle: bytes.Clone(caBundle), client: client}
+	return client, nil
+}
+
+// forget closes the connections of the conversion webhook of the CustomResourceDefinition named crd.
+func (c *webhookClientCache) forget(crd string) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if cached, found := c.clients[crd]; found {
+		cached.client.CloseIdleConnections()
+		delete(c.clients, crd)
+	}
+}
@@ -0,0 +1,174 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/utils/ptr"
+)
+
+// newConversionWebhook starts a conversion webhook that only changes the apiVersion of the objects.
+// It returns the webhook and the number of connections opened to it.
+func newConversionWebhook(t *testing.T) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+
+	var conns atomic.Int32
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var review apiextensionsv1.ConversionReview
+		if err := json.NewDecoder(r.Body).Decode(&review); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		review.Response = &apiextensionsv1.ConversionResponse{
+			UID:    review.Request.UID,
+			Result: metav1.Status{Status: metav1.StatusSuccess},
+		}
+		for _, raw := range review.Request.Objects {
+			var obj unstructured.Unstructured
+			if err := obj.UnmarshalJSON(raw.Raw); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			obj.SetAPIVersion(review.Request.DesiredAPIVersion)
+			data, _ := obj.MarshalJSON()
+			review.Response.ConvertedObjects = append(review.Response.ConvertedObjects, runtime.RawExtension{Raw: data})
+		}
+		review.Request = nil
+		_ = json.NewEncoder(w).Encode(&review)
+	}))
+	ts.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	ts.StartTLS()
+	t.Cleanup(ts.Close)
+	return ts, &conns
+}
+
+func TestConversionWebhookReusesConnections(t *testing.T) {
+	_, _, _, dc := newTestCluster(t)
+	ctx := context.TODO()
+	webhook, conns := newConversionWebhook(t)
+	caBundle := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: webhook.Certificate().Raw})
+
+	createCRD(t, dc, fmt.Sprintf(`{
+		"apiVersion": "apiextensions.k8s.io/v1",
+		"kind": "CustomResourceDefinition",
+		"metadata": {"name": "widgets.example.com"},
+		"spec": {
+			"group": "example.com",
+			"scope": "Namespaced",
+			"names": {"plural": "widgets", "singular": "widget", "kind": "Widget", "listKind": "WidgetList"},
+			"versions": [
+				{"name": "v1", "served": true, "storage": true, "schema": {"openAPIV3Schema": {"type": "object", "x-kubernetes-preserve-unknown-fields": true}}},
+				{"name": "v2", "served": true, "storage": false, "schema": {"openAPIV3Schema": {"type": "object", "x-kubernetes-preserve-unknown-fields": true}}}
+			],
+			"conversion": {"strategy": "Webhook", "webhook": {
+				"conversionReviewVersions": ["v1"],
+				"clientConfig": {"url": %q, "caBundle": %q}
+			}}
+		}
+	}`, webhook.URL, base64.StdEncoding.EncodeToString(caBundle)))
+
+	widgets := func(version string) schema.GroupVersionResource {
+		return schema.GroupVersionResource{Group: "example.com", Version: version, Resource: "widgets"}
+	}
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion("example.com/v1")
+	obj.SetKind("Widget")
+	obj.SetName("w1")
+	if _, err := dc.Resource(widgets("v1")).Namespace(metav1.NamespaceDefault).Create(ctx, obj, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		got, err := dc.Resource(widgets("v2")).Namespace(metav1.NamespaceDefault).Get(ctx, "w1", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.GetAPIVersion() != "example.com/v2" {
+			t.Errorf("expected apiVersion example.com/v2, got %s", got.GetAPIVersion())
+		}
+	}
+	if n := conns.Load(); n != 1 {
+		t.Errorf("expected the conversions to share one connection to the webhook, got %d", n)
+	}
+}
+
+func TestBuiltinConversion(t *testing.T) {
+	_, _, kc, dc := newTestCluster(t)
+	ctx := context.TODO()
+
+	hpa := &autoscalingv2.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: metav1.NamespaceDefault},
+		Spec: autoscalingv2.HorizontalPodAutoscalerSpec{
+			ScaleTargetRef: autoscalingv2.CrossVersionObjectReference{APIVersion: "apps/v1", Kind: "Deployment", Name: "web"},
+			MaxReplicas:    3,
+			Metrics: []autoscalingv2.MetricSpec{{
+				Type: autoscalingv2.ResourceMetricSourceType,
+				Resource: &autoscalingv2.ResourceMetricSource{
+					Name:   "cpu",
+					Target: autoscalingv2.MetricTarget{Type: autoscalingv2.UtilizationMetricType, AverageUtilization: ptr.To[int32](50)},
+				},
+			}},
+		},
+	}
+	if _, err := kc.AutoscalingV2().HorizontalPodAutoscalers(metav1.NamespaceDefault).Create(ctx, hpa, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	v1, err := kc.AutoscalingV1().HorizontalPodAutoscalers(metav1.NamespaceDefault).Get(ctx, "web", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v1.Spec.TargetCPUUtilizationPercentage == nil || *v1.Spec.TargetCPUUtilizationPercentage != 50 {
+		t.Errorf("expected the cpu utilization to be converted to autoscaling/v1, got %v", v1.Spec.TargetCPUUtilizationPercentage)
+	}
+
+	// apps/v1beta2 Deployments have the same fields as apps/v1 Deployments
+	if _, err := kc.AppsV1().Deployments(metav1.NamespaceDefault).Create(ctx, testDeployment("web", 1), metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	deployments := dc.Resource(schema.GroupVersionResource{Group: "apps", Version: "v1beta2", Resource: "deployments"}).Namespace(metav1.NamespaceDefault)
+	if got, err := deployments.Get(ctx, "web", metav1.GetOptions{}); err != nil {
+		t.Error(err)
+	} else if got.GetAPIVersion() != "apps/v1beta2" {
+		t.Errorf("expected apiVersion apps/v1beta2, got %s", got.GetAPIVersion())
+	}
+
+	// networking.k8s.io/v1beta1 Ingresses have other fields than v1 Ingresses and no conversions
+	ingresses := dc.Resource(schema.GroupVersionResource{Group: "networking.k8s.io", Version: "v1beta1", Resource: "ingresses"}).Namespace(metav1.NamespaceDefault)
+	if _, err := ingresses.List(ctx, metav1.ListOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("expected NotFound for networking.k8s.io/v1beta1 ingresses, got %v", err)
+	}
+}
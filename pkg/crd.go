@@ -0,0 +1,350 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"fmt"
+	"maps"
+	"strings"
+
+	"kmodules.xyz/fake-apiserver/pkg/resources"
+
+	kmapi "kmodules.xyz/client-go/api/v1"
+
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/klog/v2"
+)
+
+// crdCleanupFinalizer is the finalizer that deletes the custom resources of a deleted CRD.
+const crdCleanupFinalizer = "customresourcecleanup.apiextensions.k8s.io"
+
+var crdGVK = apiextensionsv1.SchemeGroupVersion.WithKind("CustomResourceDefinition")
+
+// crdFor returns the CustomResourceDefinition that defines the custom resource gr.
+func (s *Server) crdFor(gr schema.GroupResource) (*apiextensionsv1.CustomResourceDefinition, bool) {
+	store := s.builtinStore(apiextensionsv1.SchemeGroupVersion.WithResource("customresourcedefinitions"))
+	u, found, err := store.Get(types.NamespacedName{Name: gr.String()})
+	if err != nil || !found {
+		return nil, false
+	}
+
+	var crd apiextensionsv1.CustomResourceDefinition
+	err = runtime.DefaultUnstructuredConverter.FromUnstructured(u.UnstructuredContent(), &crd)
+	if err != nil {
+		return nil, false
+	}
+	return &crd, true
+}
+
+// crdVersion returns the definition of version in crd.
+func crdVersion(crd *apiextensionsv1.CustomResourceDefinition, version string) (*apiextensionsv1.CustomResourceDefinitionVersion, bool) {
+	for i := range crd.Spec.Versions {
+		if crd.Spec.Versions[i].Name == version {
+			return &crd.Spec.Versions[i], true
+		}
+	}
+	return nil, false
+}
+
+// crdHasStatus returns true if version of crd has a status subresource.
+func crdHasStatus(crd *apiextensionsv1.CustomResourceDefinition, version string) bool {
+	v, found := crdVersion(crd, version)
+	return found && v.Subresources != nil && v.Subresources.Status != nil
+}
+
+// crds returns the stored CustomResourceDefinitions by the resource they define.
+func (s *Server) crds() map[schema.GroupResource]*apiextensionsv1.CustomResourceDefinition {
+	store := s.builtinStore(apiextensionsv1.SchemeGroupVersion.WithResource("customresourcedefinitions"))
+	items, _, _ := store.List(0)
+
+	result := make(map[schema.GroupResource]*apiextensionsv1.CustomResourceDefinition, len(items))
+	for _, u := range items {
+		var crd apiextensionsv1.CustomResourceDefinition
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.UnstructuredContent(), &crd); err != nil {
+			continue
+		}
+		result[schema.GroupResource{Group: crd.Spec.Group, Resource: crd.Spec.Names.Plural}] = &crd
+	}
+	return result
+}
+
+// crdGroupResource returns the resource defined by the CustomResourceDefinition u.
+func crdGroupResource(u *unstructured.Unstructured) schema.GroupResource {
+	group, _, _ := unstructured.NestedString(u.Object, "spec", "group")
+	plural, _, _ := unstructured.NestedString(u.Object, "spec", "names", "plural")
+	return schema.GroupResource{Group: group, Resource: plural}
+}
+
+// validateCRD rejects CustomResourceDefinitions that cannot be served. old is the live CRD on update, whose
+// group, plural and scope cannot be changed, like in the apiextensions-apiserver.
+func validateCRD(crd, old *apiextensionsv1.CustomResourceDefinition) error {
+	var errs field.ErrorList
+	specPath := field.NewPath("spec")
+	if crd.Spec.Group == "" {
+		errs = append(errs, field.Required(specPath.Child("group"), ""))
+	}
+	if crd.Spec.Names.Plural == "" {
+		errs = append(errs, field.Required(specPath.Child("names", "plural"), ""))
+	}
+	if crd.Spec.Names.Kind == "" {
+		errs = append(errs, field.Required(specPath.Child("names", "kind"), ""))
+	}
+	if expected := crd.Spec.Names.Plural + "." + crd.Spec.Group; crd.Name != expected {
+		errs = append(errs, field.Invalid(field.NewPath("metadata", "name"), crd.Name, fmt.Sprintf("must be spec.names.plural+\".\"+spec.group (%s)", expected)))
+	}
+	if crd.Spec.Scope != apiextensionsv1.NamespaceScoped && crd.Spec.Scope != apiextensionsv1.ClusterScoped {
+		errs = append(errs, field.NotSupported(specPath.Child("scope"), crd.Spec.Scope, []string{
+			string(apiextensionsv1.ClusterScoped),
+			string(apiextensionsv1.NamespaceScoped),
+		}))
+	}
+
+	storage := 0
+	for i, v := range crd.Spec.Versions {
+		if v.Name == "" {
+			errs = append(errs, field.Required(specPath.Child("versions").Index(i).Child("name"), ""))
+		}
+		if v.Storage {
+			storage++
+		}
+		if v.Schema != nil && v.Schema.OpenAPIV3Schema != nil {
+			if _, err := newCRDSchema(&v); err != nil {
+				errs = append(errs, field.Invalid(specPath.Child("versions").Index(i).Child("schema", "openAPIV3Schema"), "", err.Error()))
+			}
+		}
+		seen := map[string]bool{}
+		for j, f := range v.SelectableFields {
+			fieldPath := specPath.Child("versions").Index(i).Child("selectableFields").Index(j).Child("jsonPath")
+			switch {
+			case f.JSONPath == "":
+				errs = append(errs, field.Required(fieldPath, ""))
+			case !strings.HasPrefix(f.JSONPath, ".") || strings.ContainsAny(f.JSONPath, "[]*"):
+				errs = append(errs, field.Invalid(fieldPath, f.JSONPath, "must be a simple json path starting with a ."))
+			case seen[f.JSONPath]:
+				errs = append(errs, field.Duplicate(fieldPath, f.JSONPath))
+			}
+			seen[f.JSONPath] = true
+		}
+	}
+	switch {
+	case len(crd.Spec.Versions) == 0:
+		errs = append(errs, field.Required(specPath.Child("versions"), "must have exactly one version marked as storage version"))
+	case storage != 1:
+		errs = append(errs, field.Invalid(specPath.Child("versions"), crd.Spec.Versions, "must have exactly one version marked as storage version"))
+	}
+
+	if old != nil {
+		if crd.Spec.Group != old.Spec.Group {
+			errs = append(errs, field.Invalid(specPath.Child("group"), crd.Spec.Group, "field is immutable"))
+		}
+		if crd.Spec.Names.Plural != old.Spec.Names.Plural {
+			errs = append(errs, field.Invalid(specPath.Child("names", "plural"), crd.Spec.Names.Plural, "field is immutable"))
+		}
+		if crd.Spec.Scope != old.Spec.Scope {
+			errs = append(errs, field.Invalid(specPath.Child("scope"), crd.Spec.Scope, "field is immutable"))
+		}
+	}
+
+	if c := crd.Spec.Conversion; c != nil {
+		conversionPath := specPath.Child("conversion")
+		switch c.Strategy {
+		case apiextensionsv1.NoneConverter:
+			if c.Webhook != nil {
+				errs = append(errs, field.Forbidden(conversionPath.Child("webhook"), "should not be set when strategy is not set to Webhook"))
+			}
+		case apiextensionsv1.WebhookConverter:
+			switch {
+			case c.Webhook == nil || c.Webhook.ClientConfig == nil:
+				errs = append(errs, field.Required(conversionPath.Child("webhook", "clientConfig"), "required when strategy is set to Webhook"))
+			case (c.Webhook.ClientConfig.URL == nil) == (c.Webhook.ClientConfig.Service == nil):
+				errs = append(errs, field.Required(conversionPath.Child("webhook", "clientConfig"), "exactly one of url or service is required"))
+			}
+			if c.Webhook != nil && len(c.Webhook.ConversionReviewVersions) == 0 {
+				errs = append(errs, field.Required(conversionPath.Child("webhook", "conversionReviewVersions"), "must include at least one of v1, v1beta1"))
+			}
+		default:
+			errs = append(errs, field.NotSupported(conversionPath.Child("strategy"), c.Strategy, []string{
+				string(apiextensionsv1.NoneConverter),
+				string(apiextensionsv1.WebhookConverter),
+			}))
+		}
+	}
+
+	if len(errs) > 0 {
+		return apierrors.NewInvalid(crdGVK.GroupKind(), crd.Name, errs)
+	}
+	return nil
+}
+
+// prepareCRD validates the CustomResourceDefinition u written by a client and sets its status.
+// old is the live CustomResourceDefinition on update and nil on create.
+// CustomResourceDefinitions of other versions than v1 are converted to v1 and back for this.
+func (s *Server) prepareCRD(u, old *unstructured.Unstructured) error {
+	gv := u.GroupVersionKind().GroupVersion()
+	v1, err := s.convertObject(u, apiextensionsv1.SchemeGroupVersion)
+	if err != nil {
+		return err
+	}
+
+	var crd apiextensionsv1.CustomResourceDefinition
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(v1.UnstructuredContent(), &crd); err != nil {
+		return err
+	}
+	var oldCRD *apiextensionsv1.CustomResourceDefinition
+	if old != nil {
+		oldV1, err := s.convertObject(old, apiextensionsv1.SchemeGroupVersion)
+		if err != nil {
+			return err
+		}
+		oldCRD = new(apiextensionsv1.CustomResourceDefinition)
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(oldV1.UnstructuredContent(), oldCRD); err != nil {
+			return err
+		}
+	}
+	if err := validateCRD(&crd, oldCRD); err != nil {
+		return err
+	}
+	if err := resources.ProcessCRD(v1); err != nil {
+		return err
+	}
+
+	result, err := s.convertObject(v1, gv)
+	if err != nil {
+		return err
+	}
+	u.SetUnstructuredContent(result.UnstructuredContent())
+	return nil
+}
+
+// crdResourceIDs returns the served versions of crd, starting with its storage version.
+func crdResourceIDs(crd *apiextensionsv1.CustomResourceDefinition) []kmapi.ResourceID {
+	scope := kmapi.ClusterScoped
+	if crd.Spec.Scope == apiextensionsv1.NamespaceScoped {
+		scope = kmapi.NamespaceScoped
+	}
+	storage, _ := resources.StorageVersion(crd)
+
+	result := make([]kmapi.ResourceID, 0, len(crd.Spec.Versions))
+	for _, v := range crd.Spec.Versions {
+		if !v.Served {
+			continue
+		}
+		rid := kmapi.ResourceID{
+			Group:   crd.Spec.Group,
+			Version: v.Name,
+			Name:    crd.Spec.Names.Plural,
+			Kind:    crd.Spec.Names.Kind,
+			Scope:   scope,
+		}
+		if v.Name == storage {
+			result = append([]kmapi.ResourceID{rid}, result...)
+		} else {
+			result = append(result, rid)
+		}
+	}
+	return result
+}
+
+// updateCRDResources registers the served versions of the CustomResourceDefinition u,
+// or unregisters them if u was removed. It is called by the store of CRDs on every change.
+// The store of a removed CRD is dropped, as its custom resources are deleted before the CRD,
+// so that a new CRD for the same resource starts with a store of its own kind and scope.
+func (s *Server) updateCRDResources(u *unstructured.Unstructured, removed bool) {
+	var crd apiextensionsv1.CustomResourceDefinition
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.UnstructuredContent(), &crd); err != nil {
+		klog.Errorln("failed to register CustomResourceDefinition", u.GetName(), err)
+		return
+	}
+	gr := schema.GroupResource{Group: crd.Spec.Group, Resource: crd.Spec.Names.Plural}
+
+	s.m.Lock()
+	if !removed {
+		s.crdResources[gr] = crdResourceIDs(&crd)
+		s.m.Unlock()
+		return
+	}
+	// the resource is hidden, even if it is a known resource of the registry
+	s.crdResources[gr] = nil
+	store, found := s.stores[gr]
+	delete(s.stores, gr)
+	s.m.Unlock()
+
+	if found {
+		store.Close()
+	}
+	s.webhookClients.forget(crd.Name)
+	s.crdSchemas.forget(crd.Name)
+}
+
+// visitResources calls f for every served resource version: the versions defined by
+// CustomResourceDefinitions and the versions of the built-in resources that are not redefined by one.
+func (s *Server) visitResources(f func(rid kmapi.ResourceID)) {
+	s.m.Lock()
+	custom := maps.Clone(s.crdResources)
+	s.m.Unlock()
+
+	for gr, rids := range s.builtinResources {
+		if _, found := custom[gr]; found {
+			continue
+		}
+		for _, rid := range rids {
+			f(rid)
+		}
+	}
+	for _, rids := range custom {
+		for _, rid := range rids {
+			f(rid)
+		}
+	}
+}
+
+// servedResources returns the served versions of gr, starting with its storage version.
+// s.m must be held by the caller.
+func (s *Server) servedResources(gr schema.GroupResource) []kmapi.ResourceID {
+	if rids, found := s.crdResources[gr]; found {
+		return rids
+	}
+	return s.builtinResources[gr]
+}
+
+// resourceFor returns the served resource version gvr. s.m must be held by the caller.
+func (s *Server) resourceFor(gvr schema.GroupVersionResource) (kmapi.ResourceID, bool) {
+	for _, rid := range s.servedResources(gvr.GroupResource()) {
+		if rid.Version == gvr.Version {
+			return rid, true
+		}
+	}
+	return kmapi.ResourceID{}, false
+}
+
+// admitCustomResourceCreate rejects custom resources created while their CRD is being deleted.
+func (s *Server) admitCustomResourceCreate(store *APIStorage) error {
+	if store.GVK.GroupKind() == crdGVK.GroupKind() {
+		return nil
+	}
+	crd, found := s.crdFor(store.GVR.GroupResource())
+	if !found || crd.DeletionTimestamp == nil {
+		return nil
+	}
+	return apierrors.NewMethodNotSupported(store.GVR.GroupResource(), "create")
+}
@@ -0,0 +1,74 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestCRDImmutableFields(t *testing.T) {
+	_, _, _, dc := newTestCluster(t)
+	ctx := context.TODO()
+	createCRD(t, dc, widgetCRD)
+	crds := dc.Resource(apiextensionsv1.SchemeGroupVersion.WithResource("customresourcedefinitions"))
+
+	for _, tc := range []struct {
+		field string
+		patch string
+	}{
+		{"spec.scope", `{"spec": {"scope": "Cluster"}}`},
+		{"spec.group", `{"spec": {"group": "example.org"}}`},
+		{"spec.names.plural", `{"spec": {"names": {"plural": "gadgets"}}}`},
+	} {
+		_, err := crds.Patch(ctx, "widgets.example.com", types.MergePatchType, []byte(tc.patch), metav1.PatchOptions{})
+		if !apierrors.IsInvalid(err) {
+			t.Errorf("patch: expected Invalid when changing %s, got %v", tc.field, err)
+			continue
+		}
+		causes := err.(apierrors.APIStatus).Status().Details.Causes
+		if !slices.ContainsFunc(causes, func(c metav1.StatusCause) bool { return c.Field == tc.field }) {
+			t.Errorf("patch: expected a cause for %s, got %v", tc.field, causes)
+		}
+	}
+
+	crd, err := crds.Get(ctx, "widgets.example.com", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed := crd.DeepCopy()
+	if err := unstructured.SetNestedField(changed.Object, "Cluster", "spec", "scope"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := crds.Update(ctx, changed, metav1.UpdateOptions{}); !apierrors.IsInvalid(err) {
+		t.Errorf("update: expected Invalid when changing spec.scope, got %v", err)
+	}
+
+	if err := unstructured.SetNestedStringSlice(crd.Object, []string{"wd"}, "spec", "names", "shortNames"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := crds.Update(ctx, crd, metav1.UpdateOptions{}); err != nil {
+		t.Errorf("update: expected the short names to be mutable, got %v", err)
+	}
+}
@@ -0,0 +1,195 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	apiextensionsinternal "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	structuralschema "k8s.io/apiextensions-apiserver/pkg/apiserver/schema"
+	"k8s.io/apiextensions-apiserver/pkg/apiserver/schema/cel"
+	"k8s.io/apiextensions-apiserver/pkg/apiserver/schema/cel/model"
+	structuraldefaulting "k8s.io/apiextensions-apiserver/pkg/apiserver/schema/defaulting"
+	structurallisttype "k8s.io/apiextensions-apiserver/pkg/apiserver/schema/listtype"
+	schemaobjectmeta "k8s.io/apiextensions-apiserver/pkg/apiserver/schema/objectmeta"
+	structuralpruning "k8s.io/apiextensions-apiserver/pkg/apiserver/schema/pruning"
+	apiservervalidation "k8s.io/apiextensions-apiserver/pkg/apiserver/validation"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	celconfig "k8s.io/apiserver/pkg/apis/cel"
+	"k8s.io/apiserver/pkg/cel/common"
+)
+
+// applySchema prunes, defaults and validates the custom resource obj against the openAPIV3Schema
+// of its CRD version, like the apiextensions-apiserver does on every write. old is the current
+// object of an update, so that transition rules are evaluated and unchanged fields that became
+// invalid are tolerated, and nil on create.
+func (s *Server) applySchema(store *APIStorage, obj, old *unstructured.Unstructured) error {
+	crd, found := s.crdFor(store.GVR.GroupResource())
+	if !found {
+		return nil
+	}
+	schema, err := s.crdSchemas.get(crd, store.GVK.Version)
+	if err != nil || schema == nil {
+		return err
+	}
+
+	structuralpruning.Prune(obj.Object, schema.structural, true)
+	structuraldefaulting.PruneNonNullableNullsWithoutDefaults(obj.Object, schema.structural)
+	structuraldefaulting.Default(obj.Object, schema.structural)
+
+	if errs := schema.validate(obj, old); len(errs) > 0 {
+		return apierrors.NewInvalid(store.GVK.GroupKind(), obj.GetName(), errs)
+	}
+	return nil
+}
+
+// crdSchema is the openAPIV3Schema of a CRD version in the forms used by the apiextensions-apiserver.
+type crdSchema struct {
+	resourceVersion string
+	structural      *structuralschema.Structural
+	validator       apiservervalidation.SchemaValidator
+	// celValidator is nil if the schema has no x-kubernetes-validations rules
+	celValidator *cel.Validator
+}
+
+// newCRDSchema converts the schema of version v of a CRD, which has passed the validation of the CRD.
+func newCRDSchema(v *apiextensionsv1.CustomResourceDefinitionVersion) (*crdSchema, error) {
+	var internal apiextensionsinternal.CustomResourceValidation
+	if err := apiextensionsv1.Convert_v1_CustomResourceValidation_To_apiextensions_CustomResourceValidation(v.Schema, &internal, nil); err != nil {
+		return nil, fmt.Errorf("failed to convert the schema of version %s: %w", v.Name, err)
+	}
+	structural, err := structuralschema.NewStructural(internal.OpenAPIV3Schema)
+	if err != nil {
+		return nil, fmt.Errorf("the schema of version %s is not structural: %w", v.Name, err)
+	}
+	if errs := structuralschema.ValidateStructural(nil, structural); len(errs) > 0 {
+		return nil, fmt.Errorf("the schema of version %s is not structural: %w", v.Name, errs.ToAggregate())
+	}
+	// the defaults of the schema are not deep-copied by NewStructural
+	structural = structural.DeepCopy()
+	if err := structuraldefaulting.PruneDefaults(structural); err != nil {
+		return nil, fmt.Errorf("failed to prune the defaults of version %s: %w", v.Name, err)
+	}
+	validator, _, err := apiservervalidation.NewSchemaValidator(internal.OpenAPIV3Schema)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schema of version %s: %w", v.Name, err)
+	}
+	return &crdSchema{
+		structural:   structural,
+		validator:    validator,
+		celValidator: cel.NewValidator(structural, true, celconfig.PerCallLimit),
+	}, nil
+}
+
+// validate returns the errors of obj against the schema, like the customResourceStrategy of the
+// apiextensions-apiserver. Unchanged fields of old are not validated again.
+func (c *crdSchema) validate(obj, old *unstructured.Unstructured) field.ErrorList {
+	var (
+		errs       field.ErrorList
+		celOptions []cel.Option
+		oldObject  any
+	)
+	if old == nil {
+		errs = append(errs, apiservervalidation.ValidateCustomResource(nil, obj.Object, c.validator)...)
+		errs = append(errs, structurallisttype.ValidateListSetsAndMaps(nil, c.structural, obj.Object)...)
+	} else {
+		oldObject = old.Object
+		correlated := common.NewCorrelatedObject(obj.Object, old.Object, &model.Structural{Structural: c.structural})
+		celOptions = append(celOptions, cel.WithRatcheting(correlated))
+		errs = append(errs, apiservervalidation.ValidateCustomResourceUpdate(nil, obj.Object, old.Object, c.validator, apiservervalidation.WithRatcheting(correlated))...)
+		if oldErrs := structurallisttype.ValidateListSetsAndMaps(nil, c.structural, old.Object); len(oldErrs) == 0 {
+			errs = append(errs, structurallisttype.ValidateListSetsAndMaps(nil, c.structural, obj.Object)...)
+		}
+	}
+	errs = append(errs, schemaobjectmeta.Validate(nil, obj.Object, c.structural, false)...)
+
+	if c.celValidator != nil {
+		if blocked, err := hasBlockingErr(errs); blocked {
+			errs = append(errs, err)
+		} else {
+			celErrs, _ := c.celValidator.Validate(context.TODO(), nil, c.structural, obj.Object, oldObject, celconfig.RuntimeCELCostBudget, celOptions...)
+			errs = append(errs, celErrs...)
+		}
+	}
+	return errs
+}
+
+// hasBlockingErr returns an error if errs keep the x-kubernetes-validations rules from being evaluated.
+func hasBlockingErr(errs field.ErrorList) (bool, *field.Error) {
+	for _, err := range errs {
+		switch err.Type {
+		case field.ErrorTypeNotSupported, field.ErrorTypeRequired, field.ErrorTypeTooLong, field.ErrorTypeTooMany, field.ErrorTypeTypeInvalid:
+			return true, field.Invalid(nil, nil, "some validation rules were not checked because the object was invalid; correct the existing errors to complete validation")
+		}
+	}
+	return false, nil
+}
+
+// crdSchemaCache holds the schemas of the CRD versions, as compiling their x-kubernetes-validations
+// rules is expensive. A schema is converted again whenever its CRD changes.
+type crdSchemaCache struct {
+	m       sync.Mutex
+	schemas map[crdSchemaKey]*crdSchema
+}
+
+type crdSchemaKey struct {
+	crd     string
+	version string
+}
+
+// get returns the schema of version of crd, or nil if the version has no schema.
+func (c *crdSchemaCache) get(crd *apiextensionsv1.CustomResourceDefinition, version string) (*crdSchema, error) {
+	v, found := crdVersion(crd, version)
+	if !found || v.Schema == nil || v.Schema.OpenAPIV3Schema == nil {
+		return nil, nil
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	key := crdSchemaKey{crd: crd.Name, version: version}
+	if cached, found := c.schemas[key]; found && cached.resourceVersion == crd.ResourceVersion {
+		return cached, nil
+	}
+	schema, err := newCRDSchema(v)
+	if err != nil {
+		return nil, apierrors.NewInternalError(err)
+	}
+	schema.resourceVersion = crd.ResourceVersion
+	if c.schemas == nil {
+		c.schemas = map[crdSchemaKey]*crdSchema{}
+	}
+	c.schemas[key] = schema
+	return schema, nil
+}
+
+// forget removes the schemas of the versions of the CustomResourceDefinition named crd.
+func (c *crdSchemaCache) forget(crd string) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	for key := range c.schemas {
+		if key.crd == crd {
+			delete(c.schemas, key)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const validatedWidgetCRD = `{
+	"apiVersion": "apiextensions.k8s.io/v1",
+	"kind": "CustomResourceDefinition",
+	"metadata": {"name": "widgets.example.com"},
+	"spec": {
+		"group": "example.com",
+		"scope": "Namespaced",
+		"names": {"plural": "widgets", "singular": "widget", "kind": "Widget", "listKind": "WidgetList"},
+		"versions": [{
+			"name": "v1", "served": true, "storage": true,
+			"schema": {"openAPIV3Schema": {
+				"type": "object",
+				"properties": {
+					"spec": {
+						"type": "object",
+						"properties": {
+							"size": {"type": "integer", "minimum": 1, "default": 1},
+							"mode": {
+								"type": "string",
+								"enum": ["fast", "slow"],
+								"x-kubernetes-validations": [{"rule": "self == oldSelf", "message": "mode is immutable"}]
+							},
+							"tags": {"type": "array", "items": {"type": "string"}, "x-kubernetes-list-type": "set"}
+						},
+						"x-kubernetes-validations": [{"rule": "self.size <= 10", "message": "size must be at most 10"}]
+					}
+				}
+			}}
+		}]
+	}
+}`
+
+func newWidget(name string, spec map[string]any) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "example.com/v1",
+		"kind":       "Widget",
+		"metadata":   map[string]any{"name": name},
+		"spec":       spec,
+	}}
+}
+
+func TestCustomResourceSchema(t *testing.T) {
+	_, _, _, dc := newTestCluster(t)
+	ctx := context.TODO()
+	createCRD(t, dc, validatedWidgetCRD)
+	widgets := dc.Resource(schema.GroupVersionResource{Group: "example.com", Version: "v1", Resource: "widgets"}).Namespace(metav1.NamespaceDefault)
+
+	// unknown fields are pruned and missing or null fields are defaulted
+	w, err := widgets.Create(ctx, newWidget("w1", map[string]any{"mode": "fast", "size": nil, "color": "red"}), metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size, _, _ := unstructured.NestedInt64(w.Object, "spec", "size"); size != 1 {
+		t.Errorf("expected spec.size to be defaulted to 1, got %d", size)
+	}
+	if _, found, _ := unstructured.NestedFieldNoCopy(w.Object, "spec", "color"); found {
+		t.Error("expected spec.color to be pruned")
+	}
+
+	for name, tc := range map[string]struct {
+		spec    map[string]any
+		message string
+	}{
+		"minimum":  {spec: map[string]any{"size": int64(0)}, message: "should be greater than or equal to 1"},
+		"enum":     {spec: map[string]any{"mode": "medium"}, message: "Unsupported value"},
+		"set":      {spec: map[string]any{"tags": []any{"a", "a"}}, message: "Duplicate value"},
+		"cel rule": {spec: map[string]any{"size": int64(20)}, message: "size must be at most 10"},
+	} {
+		_, err := widgets.Create(ctx, newWidget("invalid", tc.spec), metav1.CreateOptions{})
+		if !apierrors.IsInvalid(err) || !strings.Contains(err.Error(), tc.message) {
+			t.Errorf("%s: expected Invalid with %q, got %v", name, tc.message, err)
+		}
+	}
+
+	// transition rules compare the object with the current one
+	if err := unstructured.SetNestedField(w.Object, "slow", "spec", "mode"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := widgets.Update(ctx, w, metav1.UpdateOptions{}); !apierrors.IsInvalid(err) || !strings.Contains(err.Error(), "mode is immutable") {
+		t.Errorf("expected Invalid with %q, got %v", "mode is immutable", err)
+	}
+	if err := unstructured.SetNestedField(w.Object, "fast", "spec", "mode"); err != nil {
+		t.Fatal(err)
+	}
+	if err := unstructured.SetNestedField(w.Object, int64(5), "spec", "size"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := widgets.Update(ctx, w, metav1.UpdateOptions{}); err != nil {
+		t.Errorf("expected the update to be valid, got %v", err)
+	}
+}
+
+func TestNonStructuralSchema(t *testing.T) {
+	_, _, _, dc := newTestCluster(t)
+	manifest := strings.Replace(validatedWidgetCRD, `"size": {"type": "integer", "minimum": 1, "default": 1}`, `"size": {"minimum": 1}`, 1)
+
+	var obj unstructured.Unstructured
+	if err := obj.UnmarshalJSON([]byte(manifest)); err != nil {
+		t.Fatal(err)
+	}
+	crds := dc.Resource(schema.GroupVersionResource{Group: "apiextensions.k8s.io", Version: "v1", Resource: "customresourcedefinitions"})
+	if _, err := crds.Create(context.TODO(), &obj, metav1.CreateOptions{}); !apierrors.IsInvalid(err) {
+		t.Errorf("expected Invalid for a schema without the type of a property, got %v", err)
+	}
+}
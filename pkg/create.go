@@ -24,24 +24,41 @@ import (
 	"kmodules.xyz/fake-apiserver/pkg/resources"
 
 	"github.com/go-chi/chi/v5"
-	httpw "go.wandrs.dev/http"
 	core "k8s.io/api/core/v1"
-	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 	utilrand "k8s.io/apimachinery/pkg/util/rand"
-	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+const (
+	// maxNameGenerationRetries is the number of names tried for an object with generateName.
+	maxNameGenerationRetries = 8
+	randomLength             = 5
 )
 
 func (s *Server) Create(w http.ResponseWriter, r *http.Request) {
-	store := s.Store(r)
-	codec := s.codec(w, r)
+	store, err := s.Store(r)
+	if err != nil {
+		writeStatus(w, s.encoder(w, r), err)
+		return
+	}
+	codec, err := s.codec(w, r)
+	if err != nil {
+		writeStatus(w, s.encoder(w, r), err)
+		return
+	}
 
 	obj, err := s.CreateImpl(store, codec, r)
+	if err == nil {
+		obj, err = s.transformObject(store, r, obj)
+	}
 	if err != nil {
-		_ = codec.Encode(httpw.ErrorToAPIStatus(err), w)
+		writeStatus(w, codec, err)
 		return
 	}
 
@@ -62,36 +79,11 @@ func (s *Server) CreateImpl(store *APIStorage, codec runtime.Codec, r *http.Requ
 		return nil, err
 	}
 
-	isOfficialType := clientgoscheme.Scheme.IsGroupRegistered(store.GVK.Group)
-
-	var into runtime.Object
-	if !isOfficialType {
-		var u unstructured.Unstructured
-		u.SetGroupVersionKind(store.GVK)
-		into = &u
-	}
-	o2, _, err := codec.Decode(data, &store.GVK, into)
+	obj, err := s.decodeObject(store, codec, data)
 	if err != nil {
 		return nil, err
 	}
 
-	var obj unstructured.Unstructured
-	if isOfficialType {
-		content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(o2)
-		if err != nil {
-			return nil, err
-		}
-
-		obj.SetGroupVersionKind(store.GVK)
-		obj.SetUnstructuredContent(content)
-	} else {
-		obj = *into.(*unstructured.Unstructured)
-	}
-
-	if obj.GetGenerateName() != "" {
-		obj.SetName(fmt.Sprintf("%s-%s", obj.GetGenerateName(), utilrand.String(6)))
-	}
-
 	if store.Namespaced {
 		ns := chi.URLParam(r, "namespace")
 		obj.SetNamespace(ns)
@@ -99,36 +91,79 @@ func (s *Server) CreateImpl(store *APIStorage, codec runtime.Codec, r *http.Requ
 		obj.SetNamespace("")
 	}
 
+	if err := s.admitCreate(store, obj.GetNamespace()); err != nil {
+		return nil, err
+	}
+
+	generateName := obj.GetGenerateName()
+	if generateName != "" {
+		obj.SetName(generateNameFrom(generateName))
+	} else if obj.GetName() == "" {
+		return nil, apierrors.NewInvalid(store.GVK.GroupKind(), "", field.ErrorList{
+			field.Required(field.NewPath("metadata", "name"), "name or generateName is required"),
+		})
+	} else if _, exists := store.resourceStore.Get(types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.GetName()}); exists {
+		return nil, apierrors.NewAlreadyExists(store.GVR.GroupResource(), obj.GetName())
+	}
+
+	if s.hasStatusSubresource(store) {
+		// status can only be set through the status subresource
+		unstructured.RemoveNestedField(obj.Object, "status")
+	}
+
 	if store.GVK == core.SchemeGroupVersion.WithKind("Namespace") {
-		cm := resources.CreateKubeRootCACert()
-		cm.SetNamespace(obj.GetName())
-		s.StoreForGVR(core.SchemeGroupVersion.WithResource("configmaps")).Insert(cm)
-	} else if store.GVK == core.SchemeGroupVersion.WithKind("Secret") {
-		err = resources.ProcessSecret(&obj)
+		err = unstructured.SetNestedField(obj.Object, string(core.NamespaceActive), "status", "phase")
 		if err != nil {
 			return nil, err
 		}
-	} else if store.GVK == apiextensionsv1.SchemeGroupVersion.WithKind("CustomResourceDefinition") {
-		var crd apiextensionsv1.CustomResourceDefinition
-		err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.UnstructuredContent(), &crd)
+		setSpecFinalizers(obj, []string{string(core.FinalizerKubernetes)})
+	} else if store.GVK == core.SchemeGroupVersion.WithKind("Secret") {
+		err = resources.ProcessSecret(obj)
 		if err != nil {
 			return nil, err
 		}
-		gvr := schema.GroupVersionResource{
-			Group:    crd.Spec.Group,
-			Resource: crd.Spec.Names.Plural,
-			Version:  crd.Spec.Versions[0].Name,
-		}
-		if err := s.reg.RegisterGVR(gvr); err != nil {
+	} else if store.GVK.GroupKind() == crdGVK.GroupKind() {
+		if err := s.prepareCRD(obj, nil); err != nil {
 			return nil, err
 		}
-		err = resources.ProcessCRD(&obj)
+	}
+
+	if err := s.applySchema(store, obj, nil); err != nil {
+		return nil, err
+	}
+	prepareForCreate(obj)
+	result, err := s.updateManagedFields(store, nil, obj, fieldManagerName(r, opts.FieldManager), "")
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; ; i++ {
+		err = store.Create(result)
+		// pick another name if a generated name is already taken
+		if apierrors.IsAlreadyExists(err) && generateName != "" && i < maxNameGenerationRetries {
+			result.SetName(generateNameFrom(generateName))
+			continue
+		}
 		if err != nil {
 			return nil, err
 		}
+		break
 	}
 
-	store.Insert(&obj)
+	if store.GVK == core.SchemeGroupVersion.WithKind("Namespace") {
+		cm := resources.CreateKubeRootCACert()
+		cm.SetNamespace(result.GetName())
+		prepareForCreate(cm)
+		_ = s.builtinStore(core.SchemeGroupVersion.WithResource("configmaps")).resourceStore.Update(cm, true)
+	}
 
-	return &obj, nil
+	return result, nil
+}
+
+// generateNameFrom appends a random suffix to generateName like the apiserver's name generator.
+func generateNameFrom(generateName string) string {
+	const maxGeneratedNameLength = validation.DNS1123SubdomainMaxLength - randomLength
+	if len(generateName) > maxGeneratedNameLength {
+		generateName = generateName[:maxGeneratedNameLength]
+	}
+	return fmt.Sprintf("%s%s", generateName, utilrand.String(randomLength))
 }
@@ -0,0 +1,75 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	core "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+func TestCreateAlreadyExists(t *testing.T) {
+	_, _, kc, _ := newTestCluster(t)
+	ctx := context.TODO()
+	cms := kc.CoreV1().ConfigMaps(metav1.NamespaceDefault)
+
+	first := createConfigMap(t, kc, "cm")
+	_, err := cms.Create(ctx, &core.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "cm"},
+		Data:       map[string]string{"a": "1"},
+	}, metav1.CreateOptions{})
+	if !apierrors.IsAlreadyExists(err) {
+		t.Fatalf("expected AlreadyExists, got %v", err)
+	}
+	if code := err.(apierrors.APIStatus).Status().Code; code != http.StatusConflict {
+		t.Errorf("expected status code %d, got %d", http.StatusConflict, code)
+	}
+	if cm := getConfigMap(t, kc, "cm"); cm.UID != first.UID || len(cm.Data) != 0 {
+		t.Errorf("expected the first object to be kept, got %v", cm)
+	}
+}
+
+func TestCreateGenerateName(t *testing.T) {
+	_, _, kc, _ := newTestCluster(t)
+	ctx := context.TODO()
+	cms := kc.CoreV1().ConfigMaps(metav1.NamespaceDefault)
+
+	names := sets.New[string]()
+	for range 20 {
+		cm, err := cms.Create(ctx, &core.ConfigMap{ObjectMeta: metav1.ObjectMeta{GenerateName: "cm-"}}, metav1.CreateOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(cm.Name, "cm-") || len(cm.Name) <= len("cm-") {
+			t.Errorf("expected a name generated from cm-, got %q", cm.Name)
+		}
+		names.Insert(cm.Name)
+	}
+	if names.Len() != 20 {
+		t.Errorf("expected 20 distinct names, got %v", sets.List(names))
+	}
+
+	if _, err := cms.Create(ctx, &core.ConfigMap{}, metav1.CreateOptions{}); !apierrors.IsInvalid(err) {
+		t.Errorf("expected Invalid without name and generateName, got %v", err)
+	}
+}
@@ -0,0 +1,270 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/klog/v2"
+	kjson "sigs.k8s.io/json"
+	"sigs.k8s.io/yaml"
+)
+
+// dataDirStateFile marks a data dir and keeps the resourceVersion of the server.
+const dataDirStateFile = "server.yaml"
+
+// dataDir keeps the objects of the server as YAML files in a directory, one file per object at
+// <resource>.<group>/<namespace>/<name>.yaml, or <resource>.<group>/<name>.yaml for cluster scoped
+// objects. The files are written on every change, so the objects survive a crash of the server.
+// Deleted objects are not kept.
+type dataDir struct {
+	m     sync.Mutex
+	dir   string
+	state dataDirState
+}
+
+type dataDirState struct {
+	ResourceVersion   int64 `json:"resourceVersion"`
+	CheckpointVersion int64 `json:"checkpointVersion,omitempty"`
+}
+
+// openDataDir loads the objects kept in dir and keeps all further changes there.
+func (s *Server) openDataDir(dir string) error {
+	d := &dataDir{dir: dir}
+	snap, err := d.Load()
+	if err != nil {
+		return err
+	}
+	if err := s.Restore(snap); err != nil {
+		return fmt.Errorf("failed to restore data dir %s: %w", dir, err)
+	}
+	if err := d.Reset(s.Snapshot()); err != nil {
+		return err
+	}
+	s.disk = d
+	klog.Infoln("using data dir", dir, "at resourceVersion", snap.ResourceVersion)
+	return nil
+}
+
+// persist writes the change of obj to the data dir. It is called with the lock of the store held,
+// so that the changes of an object are written in order.
+func (s *Server) persist(gr schema.GroupResource, obj *unstructured.Unstructured, removed bool) {
+	if s.disk == nil {
+		return
+	}
+	var err error
+	if removed {
+		err = s.disk.Remove(gr, obj)
+	} else {
+		err = s.disk.Save(gr, obj)
+	}
+	if err == nil {
+		err = s.disk.SaveResourceVersion(int64(atoi(obj.GetResourceVersion())))
+	}
+	if err != nil {
+		klog.Errorln("failed to persist", gr, obj.GetNamespace(), obj.GetName(), err)
+	}
+}
+
+// Load reads the objects in the data dir. An empty or missing dir is loaded as an empty snapshot,
+// but a dir with other files is rejected, so that they are not overwritten.
+func (d *dataDir) Load() (*Snapshot, error) {
+	entries, err := os.ReadDir(d.dir)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && len(entries) == 0) {
+		return &Snapshot{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(d.dir, dataDirStateFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%s is not a data dir: %s not found", d.dir, dataDirStateFile)
+	} else if err != nil {
+		return nil, err
+	}
+	var state dataDirState
+	if err := yaml.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", filepath.Join(d.dir, dataDirStateFile), err)
+	}
+
+	snap := Snapshot{
+		ResourceVersion:   state.ResourceVersion,
+		CheckpointVersion: state.CheckpointVersion,
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		rs := ResourceSnapshot{}
+		gr := schema.ParseGroupResource(entry.Name())
+		rs.Group, rs.Resource = gr.Group, gr.Resource
+		err := filepath.WalkDir(filepath.Join(d.dir, entry.Name()), func(path string, e fs.DirEntry, err error) error {
+			if err != nil || e.IsDir() || filepath.Ext(path) != ".yaml" {
+				return err
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			// integers must stay int64, like in objects decoded from requests
+			js, err := yaml.YAMLToJSON(data)
+			if err != nil {
+				return fmt.Errorf("failed to parse %s: %w", path, err)
+			}
+			var obj unstructured.Unstructured
+			if err := kjson.UnmarshalCaseSensitivePreserveInts(js, &obj.Object); err != nil {
+				return fmt.Errorf("failed to parse %s: %w", path, err)
+			}
+			rs.Items = append(rs.Items, &obj)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(rs.Items) > 0 {
+			sort.Slice(rs.Items, func(i, j int) bool {
+				return keyLess(rs.Items[i], rs.Items[j])
+			})
+			snap.Resources = append(snap.Resources, rs)
+		}
+	}
+	return &snap, nil
+}
+
+// Reset replaces the content of the data dir with snap.
+func (d *dataDir) Reset(snap *Snapshot) error {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	entries, err := os.ReadDir(d.dir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			if err := os.RemoveAll(filepath.Join(d.dir, entry.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	for _, rs := range snap.Resources {
+		for _, obj := range rs.Items {
+			if err := d.save(rs.GroupResource(), obj); err != nil {
+				return err
+			}
+		}
+	}
+	d.state = dataDirState{
+		ResourceVersion:   snap.ResourceVersion,
+		CheckpointVersion: snap.CheckpointVersion,
+	}
+	return d.saveState()
+}
+
+func (d *dataDir) Save(gr schema.GroupResource, obj *unstructured.Unstructured) error {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	return d.save(gr, obj)
+}
+
+func (d *dataDir) save(gr schema.GroupResource, obj *unstructured.Unstructured) error {
+	data, err := yaml.Marshal(obj.Object)
+	if err != nil {
+		return err
+	}
+	return writeFile(d.path(gr, obj), data)
+}
+
+func (d *dataDir) Remove(gr schema.GroupResource, obj *unstructured.Unstructured) error {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	err := os.Remove(d.path(gr, obj))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// SaveResourceVersion records rv as the resourceVersion of the server, unless a later one is recorded.
+// Objects of different resources are written concurrently, so their resourceVersions may arrive out of order.
+func (d *dataDir) SaveResourceVersion(rv int64) error {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	if rv <= d.state.ResourceVersion {
+		return nil
+	}
+	d.state.ResourceVersion = rv
+	return d.saveState()
+}
+
+func (d *dataDir) SaveCheckpointVersion(rv int64) error {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	d.state.CheckpointVersion = rv
+	return d.saveState()
+}
+
+func (d *dataDir) saveState() error {
+	data, err := yaml.Marshal(d.state)
+	if err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(d.dir, dataDirStateFile), data)
+}
+
+func (d *dataDir) path(gr schema.GroupResource, obj *unstructured.Unstructured) string {
+	name := obj.GetName() + ".yaml"
+	if ns := obj.GetNamespace(); ns != "" {
+		return filepath.Join(d.dir, gr.String(), ns, name)
+	}
+	return filepath.Join(d.dir, gr.String(), name)
+}
+
+// writeFile replaces the file at path with data, so that a crash never leaves a partially written file.
+func writeFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "."+strings.TrimSuffix(filepath.Base(path), ".yaml")+"-*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+	}
+	return err
+}
@@ -0,0 +1,71 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"context"
+	"testing"
+
+	"kmodules.xyz/fake-apiserver/pkg/resources"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+func TestDataDirRestart(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.TODO()
+
+	opts := NewOptions(false)
+	opts.DataDir = dir
+	_, cfg := newTestServer(t, opts)
+	if err := resources.InitCluster(cfg); err != nil {
+		t.Fatal(err)
+	}
+	kc := kubernetes.NewForConfigOrDie(cfg)
+	deploy := testDeployment("web", 2)
+	created, err := kc.AppsV1().Deployments(metav1.NamespaceDefault).Create(ctx, deploy, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a new server on the same data dir sees the objects of the first one
+	opts = NewOptions(false)
+	opts.DataDir = dir
+	s2, cfg2 := newTestServer(t, opts)
+	kc2 := kubernetes.NewForConfigOrDie(cfg2)
+	got, err := kc2.AppsV1().Deployments(metav1.NamespaceDefault).Get(ctx, "web", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ResourceVersion != created.ResourceVersion || *got.Spec.Replicas != 2 {
+		t.Errorf("expected the deployment at resourceVersion %s with 2 replicas, got %s with %d",
+			created.ResourceVersion, got.ResourceVersion, *got.Spec.Replicas)
+	}
+
+	// integers are restored as int64, as the scale subresource reads them with the unstructured accessors
+	scale, err := kc2.AppsV1().Deployments(metav1.NamespaceDefault).GetScale(ctx, "web", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scale.Spec.Replicas != 2 {
+		t.Errorf("expected scale of 2 replicas, got %d", scale.Spec.Replicas)
+	}
+	if rv := s2.CurrentResourceVersion(); rv < int64(atoi(created.ResourceVersion)) {
+		t.Errorf("expected the resourceVersion to be restored, got %d", rv)
+	}
+}
@@ -17,10 +17,10 @@ limitations under the License.
 package pkg
 
 import (
+	"io"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
-	httpw "go.wandrs.dev/http"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
@@ -28,33 +28,72 @@ import (
 )
 
 func (s *Server) Delete(w http.ResponseWriter, r *http.Request) {
-	store := s.Store(r)
-	codec := s.codec(w, r)
+	store, err := s.Store(r)
+	if err != nil {
+		writeStatus(w, s.encoder(w, r), err)
+		return
+	}
+	codec, err := s.codec(w, r)
+	if err != nil {
+		writeStatus(w, s.encoder(w, r), err)
+		return
+	}
 
-	obj, err := s.DeleteImpl(store, r)
+	obj, deleted, err := s.deleteImpl(store, r)
 	if err != nil {
-		_ = codec.Encode(httpw.ErrorToAPIStatus(err), w)
+		writeStatus(w, codec, err)
 		return
 	}
+	if !deleted {
+		// the object is marked for deletion and waits for its finalizers
+		w.WriteHeader(http.StatusAccepted)
+	}
 	_ = codec.Encode(obj, w)
 }
 
 func (s *Server) DeleteImpl(store *APIStorage, r *http.Request) (*unstructured.Unstructured, error) {
+	obj, _, err := s.deleteImpl(store, r)
+	return obj, err
+}
+
+// deleteImpl returns the deleted object and whether it was removed or only marked for deletion.
+func (s *Server) deleteImpl(store *APIStorage, r *http.Request) (*unstructured.Unstructured, bool, error) {
 	var opts metav1.DeleteOptions
 	err := s.opts.ParameterCodec.DecodeParameters(r.URL.Query(), metav1.SchemeGroupVersion, &opts)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 
 	defer r.Body.Close() // nolint:errcheck
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(data) > 0 {
+		decoder, err := s.decoder(r)
+		if err != nil {
+			return nil, false, err
+		}
+		if _, _, err := decoder.Decode(data, nil, &opts); err != nil {
+			return nil, false, apierrors.NewBadRequest(err.Error())
+		}
+	}
 
 	key := types.NamespacedName{
 		Namespace: chi.URLParam(r, "namespace"),
 		Name:      chi.URLParam(r, "name"),
 	}
-	obj, exists := store.Remove(key)
-	if !exists {
-		return nil, apierrors.NewNotFound(store.GVR.GroupResource(), key.String())
+	if err := s.admitDelete(store, key.Name); err != nil {
+		return nil, false, err
+	}
+	finalizers, err := deletionFinalizers(store.GVK.GroupKind(), opts)
+	if err != nil {
+		return nil, false, err
+	}
+	obj, deleted, err := store.Delete(key, opts.Preconditions, finalizers)
+	if err != nil {
+		return nil, false, err
 	}
-	return obj, nil
+	s.CollectGarbage()
+	return obj, deleted, nil
 }
@@ -0,0 +1,133 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"context"
+	"testing"
+
+	core "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+func TestDeletePreconditions(t *testing.T) {
+	_, _, kc, _ := newTestCluster(t)
+	ctx := context.TODO()
+	cms := kc.CoreV1().ConfigMaps(metav1.NamespaceDefault)
+
+	cm := createConfigMap(t, kc, "cm")
+	otherUID := types.UID("00000000-0000-0000-0000-000000000000")
+	staleRV := cm.ResourceVersion
+	cm.Data = map[string]string{"a": "1"}
+	cm, err := cms.Update(ctx, cm, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, preconditions := range map[string]metav1.Preconditions{
+		"uid":             {UID: &otherUID},
+		"resourceVersion": {ResourceVersion: &staleRV},
+	} {
+		err := cms.Delete(ctx, "cm", metav1.DeleteOptions{Preconditions: &preconditions})
+		if !apierrors.IsConflict(err) {
+			t.Errorf("expected Conflict for a mismatched %s precondition, got %v", name, err)
+		}
+	}
+	if getConfigMap(t, kc, "cm") == nil {
+		t.Fatal("expected the object to be kept when the preconditions fail")
+	}
+
+	err = cms.Delete(ctx, "cm", metav1.DeleteOptions{Preconditions: &metav1.Preconditions{UID: &cm.UID, ResourceVersion: &cm.ResourceVersion}})
+	if err != nil {
+		t.Fatalf("expected matching preconditions to succeed, got %v", err)
+	}
+	if getConfigMap(t, kc, "cm") != nil {
+		t.Error("expected the object to be removed")
+	}
+}
+
+func TestDeleteWithFinalizers(t *testing.T) {
+	_, _, kc, _ := newTestCluster(t)
+	ctx := context.TODO()
+	cms := kc.CoreV1().ConfigMaps(metav1.NamespaceDefault)
+
+	for _, tc := range []struct {
+		name   string
+		remove func(cm *core.ConfigMap) error
+	}{
+		{"update", func(cm *core.ConfigMap) error {
+			cm.Finalizers = nil
+			_, err := cms.Update(ctx, cm, metav1.UpdateOptions{})
+			return err
+		}},
+		{"patch", func(cm *core.ConfigMap) error {
+			_, err := cms.Patch(ctx, cm.Name, types.MergePatchType, []byte(`{"metadata": {"finalizers": null}}`), metav1.PatchOptions{})
+			return err
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cm, err := cms.Create(ctx, &core.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: tc.name, Finalizers: []string{"example.com/cleanup"}},
+			}, metav1.CreateOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			w, err := cms.Watch(ctx, metav1.ListOptions{ResourceVersion: cm.ResourceVersion})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer w.Stop()
+
+			if err := cms.Delete(ctx, tc.name, metav1.DeleteOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			expectEvent(t, w, watch.Modified, tc.name)
+			cm = getConfigMap(t, kc, tc.name)
+			if cm == nil {
+				t.Fatal("expected an object with finalizers to be kept")
+			}
+			if cm.DeletionTimestamp == nil || cm.DeletionGracePeriodSeconds == nil || *cm.DeletionGracePeriodSeconds != 0 {
+				t.Errorf("expected deletionTimestamp and deletionGracePeriodSeconds to be set, got %v and %v", cm.DeletionTimestamp, cm.DeletionGracePeriodSeconds)
+			}
+
+			// deleting again does not change the object
+			if err := cms.Delete(ctx, tc.name, metav1.DeleteOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			if again := getConfigMap(t, kc, tc.name); again == nil || !again.DeletionTimestamp.Equal(cm.DeletionTimestamp) {
+				t.Errorf("expected the deletionTimestamp to be kept, got %v", again)
+			}
+
+			added := cm.DeepCopy()
+			added.Finalizers = append(added.Finalizers, "example.com/other")
+			if _, err := cms.Update(ctx, added, metav1.UpdateOptions{}); !apierrors.IsForbidden(err) {
+				t.Errorf("expected Forbidden when adding a finalizer to an object being deleted, got %v", err)
+			}
+
+			if err := tc.remove(cm); err != nil {
+				t.Fatal(err)
+			}
+			expectEvent(t, w, watch.Deleted, tc.name)
+			if getConfigMap(t, kc, tc.name) != nil {
+				t.Error("expected the object to be removed once its finalizers are removed")
+			}
+		})
+	}
+}
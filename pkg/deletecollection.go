@@ -17,24 +17,31 @@ limitations under the License.
 package pkg
 
 import (
+	"io"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
-	httpw "go.wandrs.dev/http"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
-	"k8s.io/apimachinery/pkg/fields"
-	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 )
 
 func (s *Server) DeleteCollection(w http.ResponseWriter, r *http.Request) {
-	store := s.Store(r)
-	codec := s.codec(w, r)
+	store, err := s.Store(r)
+	if err != nil {
+		writeStatus(w, s.encoder(w, r), err)
+		return
+	}
+	codec, err := s.codec(w, r)
+	if err != nil {
+		writeStatus(w, s.encoder(w, r), err)
+		return
+	}
 
 	obj, err := s.DeleteCollectionImpl(store, r)
 	if err != nil {
-		_ = codec.Encode(httpw.ErrorToAPIStatus(err), w)
+		writeStatus(w, codec, err)
 		return
 	}
 	_ = codec.Encode(obj, w)
@@ -47,57 +54,67 @@ func (s *Server) DeleteCollectionImpl(store *APIStorage, r *http.Request) (runti
 		return nil, err
 	}
 
-	// List type
-	items := store.Items()
-
-	ns := chi.URLParam(r, "namespace")
-	if ns != "" {
-		filtered := items[:0]
-		for _, item := range items {
-			if item.GetNamespace() == ns {
-				filtered = append(filtered, item)
-			}
-		}
-		items = filtered
+	match, err := s.newMatcher(store, chi.URLParam(r, "namespace"), "", opts)
+	if err != nil {
+		return nil, err
 	}
 
-	if opts.LabelSelector != "" {
-		sel, err := labels.Parse(opts.LabelSelector)
+	var deleteOpts metav1.DeleteOptions
+	err = s.opts.ParameterCodec.DecodeParameters(r.URL.Query(), metav1.SchemeGroupVersion, &deleteOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close() // nolint:errcheck
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > 0 {
+		decoder, err := s.decoder(r)
 		if err != nil {
 			return nil, err
 		}
-		filtered := items[:0]
-		for _, item := range items {
-			if sel.Matches(labels.Set(item.GetLabels())) {
-				filtered = append(filtered, item)
-			}
+		if _, _, err := decoder.Decode(data, nil, &deleteOpts); err != nil {
+			return nil, apierrors.NewBadRequest(err.Error())
 		}
-		items = filtered
+	}
+	finalizers, err := deletionFinalizers(store.GVK.GroupKind(), deleteOpts)
+	if err != nil {
+		return nil, err
 	}
 
-	if opts.FieldSelector != "" {
-		sel, err := fields.ParseSelector(opts.FieldSelector)
-		if err != nil {
-			return nil, err
-		}
-		filtered := items[:0]
-		for _, item := range items {
-			if sel.Matches(ObjectToFieldLabels(&item)) {
-				filtered = append(filtered, item)
-			}
-		}
-		items = filtered
+	// List type
+	items, err := store.Items()
+	if err != nil {
+		return nil, err
 	}
 
+	filtered := items[:0]
 	for _, item := range items {
-		store.RemoveObj(&item)
+		if match(&item) {
+			filtered = append(filtered, item)
+		}
 	}
+	items = filtered
 
-	list := unstructured.UnstructuredList{
-		Items: items,
+	for i, item := range items {
+		key := types.NamespacedName{
+			Namespace: item.GetNamespace(),
+			Name:      item.GetName(),
+		}
+		if err := s.admitDelete(store, key.Name); err != nil {
+			return nil, err
+		}
+		obj, _, err := store.Delete(key, deleteOpts.Preconditions, finalizers)
+		if apierrors.IsNotFound(err) {
+			// deleted concurrently
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		items[i] = *obj
 	}
-	list.SetAPIVersion("v1")
-	list.SetKind("List")
+	s.CollectGarbage()
 
-	return &list, err
+	return newList(store, items, s.CurrentResourceVersion()), nil
 }
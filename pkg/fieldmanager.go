@@ -0,0 +1,328 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"strings"
+
+	"kmodules.xyz/fake-apiserver/pkg/resources"
+
+	openapi_v2 "github.com/google/gnostic-models/openapiv2"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	structuralschema "k8s.io/apiextensions-apiserver/pkg/apiserver/schema"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/managedfields"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/client-go/applyconfigurations"
+	"k8s.io/kube-openapi/pkg/util/proto"
+	kjson "sigs.k8s.io/json"
+	"sigs.k8s.io/structured-merge-diff/v6/fieldpath"
+	"sigs.k8s.io/structured-merge-diff/v6/typed"
+	"sigs.k8s.io/yaml"
+)
+
+type fieldManagerKey struct {
+	gvk             schema.GroupVersionKind
+	subresource     string
+	statusResetting bool
+}
+
+// fieldManagerEntry is a field manager with the resourceVersion of the CRD it was built for, if any.
+type fieldManagerEntry struct {
+	fm                 *managedfields.FieldManager
+	crdResourceVersion string
+}
+
+// FieldManager returns the field manager that tracks managedFields for objects of store.
+// The field manager of custom resources is built again whenever their CRD changes.
+func (s *Server) FieldManager(store *APIStorage, subresource string) (*managedfields.FieldManager, error) {
+	key := fieldManagerKey{
+		gvk:             store.GVK,
+		subresource:     subresource,
+		statusResetting: s.hasStatusSubresource(store),
+	}
+	crd, _ := s.crdFor(store.GVR.GroupResource())
+	var crdResourceVersion string
+	if crd != nil {
+		crdResourceVersion = crd.ResourceVersion
+	}
+
+	s.m.Lock()
+	entry, found := s.fieldManagers[key]
+	s.m.Unlock()
+	if found && entry.crdResourceVersion == crdResourceVersion {
+		return entry.fm, nil
+	}
+
+	// fields reset by the status strategy are not owned by the manager of a request
+	var resetFields map[fieldpath.APIVersion]fieldpath.Filter
+	if key.statusResetting {
+		var filter fieldpath.Filter
+		if subresource == "status" {
+			filter = fieldpath.NewIncludeMatcherFilter(fieldpath.MakePrefixMatcherOrDie("status"))
+		} else {
+			filter = fieldpath.NewExcludeSetFilter(fieldpath.NewSet(fieldpath.MakePathOrDie("status")))
+		}
+		resetFields = map[fieldpath.APIVersion]fieldpath.Filter{
+			fieldpath.APIVersion(store.GVK.GroupVersion().String()): filter,
+		}
+	}
+
+	tc, err := s.typeConverterFor(store, crd)
+	if err != nil {
+		return nil, err
+	}
+
+	// objects are stored as unstructured, so the CRD field manager is used for all types
+	fm, err := managedfields.NewDefaultCRDFieldManager(
+		tc,
+		unstructuredConverter{},
+		unstructuredDefaulter{},
+		unstructuredCreator{},
+		store.GVK,
+		store.GVK.GroupVersion(),
+		subresource,
+		resetFields,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	s.m.Lock()
+	s.fieldManagers[key] = fieldManagerEntry{fm: fm, crdResourceVersion: crdResourceVersion}
+	s.m.Unlock()
+	return fm, nil
+}
+
+// typeConverterFor returns the TypeConverter of the objects of store. crd is the CustomResourceDefinition
+// of store, or nil for built-in resources.
+func (s *Server) typeConverterFor(store *APIStorage, crd *apiextensionsv1.CustomResourceDefinition) (managedfields.TypeConverter, error) {
+	if crd != nil {
+		schema, err := s.crdSchemas.get(crd, store.GVK.Version)
+		if err != nil {
+			return nil, err
+		}
+		if schema != nil {
+			return s.crdTypeConverter(crd, store.GVK.Version, schema.structural)
+		}
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.typeConverter == nil {
+		tc, err := builtinTypeConverter(s.opts.Scheme)
+		if err != nil {
+			return nil, err
+		}
+		s.typeConverter = tc
+	}
+	return s.typeConverter, nil
+}
+
+// crdTypeConverter returns a TypeConverter for the custom resources of version of crd. Like the
+// apiextensions-apiserver, it is built from the structural schema of the version, so that server-side
+// apply merges lists by their x-kubernetes-list-type and maps by their x-kubernetes-map-type.
+func (s *Server) crdTypeConverter(crd *apiextensionsv1.CustomResourceDefinition, version string, structural *structuralschema.Structural) (managedfields.TypeConverter, error) {
+	v, found := crdVersion(crd, version)
+	if !found {
+		return nil, apierrors.NewInternalError(fmt.Errorf("unknown version %s of %s", version, crd.Name))
+	}
+	defs, err := crdDefinitions(crd, v, structural)
+	if err != nil {
+		return nil, err
+	}
+	builtin, err := s.builtinOpenAPIV3Definitions()
+	if err != nil {
+		return nil, err
+	}
+
+	models := maps.Clone(builtin)
+	for name, def := range defs {
+		if models[name], err = toOpenAPIV3Schema(def); err != nil {
+			return nil, err
+		}
+	}
+	name := crdDefinitionName(crd.Spec.Group, version, crd.Spec.Names.Kind)
+	return managedfields.NewTypeConverter(referencedDefinitions(models, []string{name}), crd.Spec.PreserveUnknownFields)
+}
+
+// updateManagedFields records the fields changed by manager from liveObj to newObj in the managedFields of newObj.
+// liveObj is nil for newly created objects.
+func (s *Server) updateManagedFields(store *APIStorage, liveObj, newObj *unstructured.Unstructured, manager, subresource string) (*unstructured.Unstructured, error) {
+	fm, err := s.FieldManager(store, subresource)
+	if err != nil {
+		return nil, err
+	}
+	if liveObj == nil {
+		liveObj = &unstructured.Unstructured{}
+		liveObj.SetGroupVersionKind(store.GVK)
+	}
+	obj, err := fm.Update(liveObj, newObj, manager)
+	if err != nil {
+		return nil, apierrors.NewInternalError(fmt.Errorf("failed to update managedFields: %w", err))
+	}
+	return obj.(*unstructured.Unstructured), nil
+}
+
+// applyPatch merges a server-side apply patch into currentObject.
+func (s *Server) applyPatch(store *APIStorage, currentObject *unstructured.Unstructured, patchBytes []byte, opts metav1.PatchOptions, subresource string) (*unstructured.Unstructured, error) {
+	if opts.FieldManager == "" {
+		return nil, apierrors.NewInvalid(schema.GroupKind{Group: metav1.GroupName, Kind: "PatchOptions"}, "", field.ErrorList{
+			field.Required(field.NewPath("fieldManager"), "is required for apply patch"),
+		})
+	}
+
+	js, err := yaml.YAMLToJSON(patchBytes)
+	if err != nil {
+		return nil, apierrors.NewBadRequest(err.Error())
+	}
+	patchObj := &unstructured.Unstructured{Object: map[string]any{}}
+	if err := kjson.UnmarshalCaseSensitivePreserveInts(js, &patchObj.Object); err != nil {
+		return nil, apierrors.NewBadRequest(err.Error())
+	}
+
+	fm, err := s.FieldManager(store, subresource)
+	if err != nil {
+		return nil, err
+	}
+	force := opts.Force != nil && *opts.Force
+	obj, err := fm.Apply(currentObject, patchObj, opts.FieldManager, force)
+	if err != nil {
+		return nil, err
+	}
+	return obj.(*unstructured.Unstructured), nil
+}
+
+// fieldManagerName returns the name of the manager of a write request.
+// Like the apiserver, it defaults to the user agent of the client.
+func fieldManagerName(r *http.Request, name string) string {
+	if name != "" {
+		return name
+	}
+	ua := r.UserAgent()
+	if i := strings.Index(ua, "/"); i >= 0 {
+		ua = ua[:i]
+	}
+	if len(ua) > 128 {
+		ua = ua[:128]
+	}
+	return ua
+}
+
+// builtinTypeConverter returns a TypeConverter that uses the structured merge schema shipped with client-go for
+// its kinds and the schema generated from the Go types for the other built-in kinds, e.g. CustomResourceDefinitions.
+// The schema of every other type, e.g. custom resources of CRD versions without a schema, is deduced.
+func builtinTypeConverter(scheme *runtime.Scheme) (managedfields.TypeConverter, error) {
+	swagger, err := resources.BuiltinOpenAPISwagger(scheme)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(swagger)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := openapi_v2.ParseDocument(data)
+	if err != nil {
+		return nil, err
+	}
+	models, err := proto.NewOpenAPIData(doc)
+	if err != nil {
+		return nil, err
+	}
+	parser, err := managedfields.NewGVKParser(models, false)
+	if err != nil {
+		return nil, err
+	}
+	return &schemaTypeConverter{
+		clientgo: applyconfigurations.NewTypeConverter(scheme),
+		parser:   parser,
+		deduced:  managedfields.NewDeducedTypeConverter(),
+	}, nil
+}
+
+type schemaTypeConverter struct {
+	clientgo managedfields.TypeConverter
+	parser   *managedfields.GvkParser
+	deduced  managedfields.TypeConverter
+}
+
+var _ managedfields.TypeConverter = &schemaTypeConverter{}
+
+func (c *schemaTypeConverter) ObjectToTyped(obj runtime.Object, opts ...typed.ValidationOptions) (*typed.TypedValue, error) {
+	gvk := obj.GetObjectKind().GroupVersionKind()
+	if applyconfigurations.ForKind(gvk) != nil {
+		return c.clientgo.ObjectToTyped(obj, opts...)
+	}
+	if t := c.parser.Type(gvk); t != nil {
+		switch o := obj.(type) {
+		case *unstructured.Unstructured:
+			return t.FromUnstructured(o.UnstructuredContent(), opts...)
+		default:
+			return t.FromStructured(obj, opts...)
+		}
+	}
+	return c.deduced.ObjectToTyped(obj, opts...)
+}
+
+func (c *schemaTypeConverter) TypedToObject(value *typed.TypedValue) (runtime.Object, error) {
+	return c.deduced.TypedToObject(value)
+}
+
+// unstructuredConverter converts unstructured objects between versions by rewriting the apiVersion.
+type unstructuredConverter struct{}
+
+var _ runtime.ObjectConvertor = unstructuredConverter{}
+
+func (unstructuredConverter) Convert(in, out, context any) error {
+	return fmt.Errorf("unstructuredConverter does not support Convert")
+}
+
+func (unstructuredConverter) ConvertToVersion(in runtime.Object, target runtime.GroupVersioner) (runtime.Object, error) {
+	kind := in.GetObjectKind().GroupVersionKind()
+	gvk, ok := target.KindForGroupVersionKinds([]schema.GroupVersionKind{kind})
+	if !ok {
+		return nil, fmt.Errorf("%v is unstructured and is not suitable for converting to %q", kind, target)
+	}
+	out := in.DeepCopyObject()
+	out.GetObjectKind().SetGroupVersionKind(gvk)
+	return out, nil
+}
+
+func (unstructuredConverter) ConvertFieldLabel(gvk schema.GroupVersionKind, label, value string) (string, string, error) {
+	return label, value, nil
+}
+
+type unstructuredDefaulter struct{}
+
+func (unstructuredDefaulter) Default(in runtime.Object) {}
+
+type unstructuredCreator struct{}
+
+func (unstructuredCreator) New(kind schema.GroupVersionKind) (runtime.Object, error) {
+	var obj unstructured.Unstructured
+	obj.SetGroupVersionKind(kind)
+	return &obj, nil
+}
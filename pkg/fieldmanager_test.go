@@ -0,0 +1,216 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	core "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const testPod = `{
+	"apiVersion": "v1",
+	"kind": "Pod",
+	"metadata": {"name": "web", "namespace": "default"},
+	"spec": {
+		"containers": [{"name": "web", "image": %q}],
+		"schedulingGates": [{"name": %q}]
+	}
+}`
+
+func TestApplyMergesListsOfNewerFields(t *testing.T) {
+	_, _, kc, _ := newTestCluster(t)
+	ctx := context.TODO()
+
+	apply := func(manager, image, gate string) (*core.Pod, error) {
+		return kc.CoreV1().Pods(metav1.NamespaceDefault).Patch(ctx, "web", types.ApplyPatchType,
+			[]byte(fmt.Sprintf(testPod, image, gate)), metav1.PatchOptions{FieldManager: manager})
+	}
+
+	if _, err := apply("alice", "nginx", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	pod, err := apply("bob", "nginx", "bob")
+	if err != nil {
+		t.Fatalf("applying a different schedulingGates entry: %v", err)
+	}
+	var gates []string
+	for _, g := range pod.Spec.SchedulingGates {
+		gates = append(gates, g.Name)
+	}
+	if len(gates) != 2 {
+		t.Errorf("expected the schedulingGates of both managers, got %v", gates)
+	}
+}
+
+func TestApplyConflict(t *testing.T) {
+	_, _, kc, _ := newTestCluster(t)
+	ctx := context.TODO()
+
+	apply := func(manager, image string, force bool) error {
+		_, err := kc.CoreV1().Pods(metav1.NamespaceDefault).Patch(ctx, "web", types.ApplyPatchType,
+			[]byte(fmt.Sprintf(testPod, image, manager)), metav1.PatchOptions{FieldManager: manager, Force: &force})
+		return err
+	}
+
+	if err := apply("alice", "nginx:1", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := apply("bob", "nginx:2", false); !apierrors.IsConflict(err) {
+		t.Fatalf("expected a conflict on the image owned by another manager, got %v", err)
+	}
+	if err := apply("bob", "nginx:2", true); err != nil {
+		t.Fatalf("forced apply: %v", err)
+	}
+	pod, err := kc.CoreV1().Pods(metav1.NamespaceDefault).Get(ctx, "web", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if image := pod.Spec.Containers[0].Image; image != "nginx:2" {
+		t.Errorf("expected the forced image nginx:2, got %s", image)
+	}
+}
+
+func TestApplyCustomResourceDefinition(t *testing.T) {
+	_, _, _, dc := newTestCluster(t)
+	ctx := context.TODO()
+	crds := dc.Resource(apiextensionsv1.SchemeGroupVersion.WithResource("customresourcedefinitions"))
+
+	crd := `{
+	"apiVersion": "apiextensions.k8s.io/v1",
+	"kind": "CustomResourceDefinition",
+	"metadata": {"name": "widgets.example.com"},
+	"spec": {
+		"group": "example.com",
+		"scope": "Namespaced",
+		"names": {"plural": "widgets", "singular": "widget", "kind": "Widget", "listKind": "WidgetList"},
+		"versions": [{
+			"name": "v1",
+			"served": true,
+			"storage": true,
+			"schema": {"openAPIV3Schema": {"type": "object", "x-kubernetes-preserve-unknown-fields": true}}
+		}]
+	}
+}`
+	if _, err := crds.Patch(ctx, "widgets.example.com", types.ApplyPatchType, []byte(crd), metav1.PatchOptions{FieldManager: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	categories := `{
+	"apiVersion": "apiextensions.k8s.io/v1",
+	"kind": "CustomResourceDefinition",
+	"metadata": {"name": "widgets.example.com"},
+	"spec": {"names": {"plural": "widgets", "kind": "Widget", "categories": ["all"]}}
+}`
+	obj, err := crds.Patch(ctx, "widgets.example.com", types.ApplyPatchType, []byte(categories), metav1.PatchOptions{FieldManager: "bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names, _, _ := unstructured.NestedStringSlice(obj.Object, "spec", "names", "categories"); len(names) != 1 {
+		t.Errorf("expected the categories applied by bob, got %v", names)
+	}
+	if len(obj.GetManagedFields()) != 2 {
+		t.Errorf("expected the fields of two managers, got %v", obj.GetManagedFields())
+	}
+}
+
+const listWidgetCRD = `{
+	"apiVersion": "apiextensions.k8s.io/v1",
+	"kind": "CustomResourceDefinition",
+	"metadata": {"name": "widgets.example.com"},
+	"spec": {
+		"group": "example.com",
+		"scope": "Namespaced",
+		"names": {"plural": "widgets", "singular": "widget", "kind": "Widget", "listKind": "WidgetList"},
+		"versions": [{
+			"name": "v1", "served": true, "storage": true,
+			"schema": {"openAPIV3Schema": {
+				"type": "object",
+				"properties": {
+					"spec": {
+						"type": "object",
+						"properties": {
+							"ports": {
+								"type": "array",
+								"items": {
+									"type": "object",
+									"required": ["name"],
+									"properties": {"name": {"type": "string"}, "port": {"type": "integer"}}
+								},
+								"x-kubernetes-list-type": "map",
+								"x-kubernetes-list-map-keys": ["name"]
+							},
+							"tags": {"type": "array", "items": {"type": "string"}, "x-kubernetes-list-type": "set"}
+						}
+					}
+				}
+			}}
+		}]
+	}
+}`
+
+func TestApplyCustomResourceLists(t *testing.T) {
+	_, _, _, dc := newTestCluster(t)
+	ctx := context.TODO()
+	createCRD(t, dc, listWidgetCRD)
+	widgets := dc.Resource(schema.GroupVersionResource{Group: "example.com", Version: "v1", Resource: "widgets"}).Namespace(metav1.NamespaceDefault)
+
+	apply := func(manager string, port int64, force bool) (*unstructured.Unstructured, error) {
+		patch := fmt.Sprintf(`{"apiVersion": "example.com/v1", "kind": "Widget", "metadata": {"name": "w1"},
+			"spec": {"ports": [{"name": %q, "port": %d}], "tags": [%q]}}`, manager, port, manager)
+		return widgets.Patch(ctx, "w1", types.ApplyPatchType, []byte(patch), metav1.PatchOptions{FieldManager: manager, Force: &force})
+	}
+
+	if _, err := apply("alice", 80, false); err != nil {
+		t.Fatal(err)
+	}
+	obj, err := apply("bob", 81, false)
+	if err != nil {
+		t.Fatalf("applying a different entry of map and set lists: %v", err)
+	}
+	ports, _, _ := unstructured.NestedSlice(obj.Object, "spec", "ports")
+	tags, _, _ := unstructured.NestedStringSlice(obj.Object, "spec", "tags")
+	if len(ports) != 2 || len(tags) != 2 {
+		t.Errorf("expected the ports and tags of both managers, got %v and %v", ports, tags)
+	}
+
+	// the entries of a map list are owned by their managers
+	patch := `{"apiVersion": "example.com/v1", "kind": "Widget", "metadata": {"name": "w1"},
+		"spec": {"ports": [{"name": "alice", "port": 8080}]}}`
+	if _, err := widgets.Patch(ctx, "w1", types.ApplyPatchType, []byte(patch), metav1.PatchOptions{FieldManager: "bob"}); !apierrors.IsConflict(err) {
+		t.Errorf("expected a conflict on the port owned by another manager, got %v", err)
+	}
+
+	// alice drops her entries without touching the ones of bob
+	patch = `{"apiVersion": "example.com/v1", "kind": "Widget", "metadata": {"name": "w1"}, "spec": {}}`
+	obj, err = widgets.Patch(ctx, "w1", types.ApplyPatchType, []byte(patch), metav1.PatchOptions{FieldManager: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ports, _, _ = unstructured.NestedSlice(obj.Object, "spec", "ports")
+	tags, _, _ = unstructured.NestedStringSlice(obj.Object, "spec", "tags")
+	if len(ports) != 1 || ports[0].(map[string]any)["name"] != "bob" || len(tags) != 1 || tags[0] != "bob" {
+		t.Errorf("expected only the ports and tags of bob, got %v and %v", ports, tags)
+	}
+}
@@ -0,0 +1,329 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"slices"
+
+	core "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/klog/v2"
+)
+
+type gcNode struct {
+	store *resourceStore
+	obj   *unstructured.Unstructured
+}
+
+func (n gcNode) key() types.NamespacedName {
+	return types.NamespacedName{
+		Namespace: n.obj.GetNamespace(),
+		Name:      n.obj.GetName(),
+	}
+}
+
+// deletionFinalizers returns the finalizers added to an object of kind gk that is deleted with opts.
+// CustomResourceDefinitions also get the finalizer that deletes their custom resources.
+func deletionFinalizers(gk schema.GroupKind, opts metav1.DeleteOptions) ([]string, error) {
+	finalizers, err := propagationFinalizers(gk, opts)
+	if err != nil {
+		return nil, err
+	}
+	if gk == crdGVK.GroupKind() {
+		finalizers = append(finalizers, crdCleanupFinalizer)
+	}
+	return finalizers, nil
+}
+
+// propagationFinalizers returns the finalizers that implement the propagation policy of opts.
+func propagationFinalizers(gk schema.GroupKind, opts metav1.DeleteOptions) ([]string, error) {
+	if opts.OrphanDependents != nil && opts.PropagationPolicy != nil {
+		return nil, apierrors.NewInvalid(gk, "", field.ErrorList{
+			field.Invalid(field.NewPath("propagationPolicy"), *opts.PropagationPolicy, "orphanDependents and deletionPropagation cannot be both set"),
+		})
+	}
+	if opts.OrphanDependents != nil {
+		if *opts.OrphanDependents {
+			return []string{metav1.FinalizerOrphanDependents}, nil
+		}
+		return nil, nil
+	}
+	if opts.PropagationPolicy == nil {
+		return nil, nil
+	}
+	switch *opts.PropagationPolicy {
+	case metav1.DeletePropagationBackground:
+		return nil, nil
+	case metav1.DeletePropagationForeground:
+		return []string{metav1.FinalizerDeleteDependents}, nil
+	case metav1.DeletePropagationOrphan:
+		return []string{metav1.FinalizerOrphanDependents}, nil
+	default:
+		return nil, apierrors.NewInvalid(gk, "", field.ErrorList{
+			field.NotSupported(field.NewPath("propagationPolicy"), *opts.PropagationPolicy, []metav1.DeletionPropagation{
+				metav1.DeletePropagationForeground,
+				metav1.DeletePropagationBackground,
+				metav1.DeletePropagationOrphan,
+			}),
+		})
+	}
+}
+
+// CollectGarbage deletes the dependents of removed owners and finalizes owners deleted with the
+// Foreground or Orphan propagation policy, like the garbage collector of kube-controller-manager.
+// An owner reference is only considered dangling if its uid belongs to a removed object, so that
+// dependents of owners that were never stored in this server are kept. The uids of removed objects
+// are forgotten once no object refers to them, so a dependent created afterwards with an owner
+// reference to such a uid is treated like one of an owner that was never stored.
+// It also deletes the content of terminating namespaces, like the namespace controller,
+// and the custom resources of deleted CRDs.
+//
+// It runs synchronously after every delete and every update that clears a finalizer. Each pass lists
+// all objects of the server, so a delete costs O(objects) times the number of passes. That is cheap for
+// the few hundred objects of a test cluster, but the server is not meant to hold large clusters.
+func (s *Server) CollectGarbage() {
+	for s.collectGarbage() {
+	}
+}
+
+// collectGarbage performs one pass over all objects and returns true if anything changed.
+func (s *Server) collectGarbage() bool {
+	stores := s.allStores()
+
+	var nodes []gcNode
+	live := map[types.UID]gcNode{}
+	// owned indexes the objects by the uids of their owners
+	owned := map[types.UID][]gcNode{}
+	removedNamespaces := sets.New[string]()
+	for _, store := range stores {
+		objs, _, err := store.List(0)
+		if err != nil {
+			klog.Errorln("garbage collector failed to list", store.GVR.GroupResource(), err)
+			continue
+		}
+		for _, obj := range objs {
+			n := gcNode{store: store, obj: obj}
+			nodes = append(nodes, n)
+			live[obj.GetUID()] = n
+			for _, uid := range sets.List(ownerUIDs(obj)) {
+				owned[uid] = append(owned[uid], n)
+			}
+		}
+		if store.GVK == nsGVK {
+			for _, obj := range store.Deleted() {
+				removedNamespaces.Insert(obj.GetName())
+			}
+		}
+	}
+	removed := s.removedOwners(owned, live)
+
+	namespaces := map[string]gcNode{}
+	contents := map[string]int{}
+	for _, n := range nodes {
+		if n.store.GVK == nsGVK {
+			namespaces[n.obj.GetName()] = n
+		} else if n.store.Namespaced {
+			contents[n.obj.GetNamespace()]++
+		}
+	}
+
+	changed := false
+	update := func(n gcNode, fn func(obj *unstructured.Unstructured)) {
+		if err := n.store.UpdateFunc(n.key(), n.obj.GetUID(), fn); err != nil {
+			klog.V(4).Infoln("garbage collector failed to update", n.key(), err)
+		}
+		changed = true
+	}
+	remove := func(n gcNode, finalizers []string) {
+		uid := n.obj.GetUID()
+		if _, _, err := n.store.Delete(n.key(), &metav1.Preconditions{UID: &uid}, finalizers); err != nil {
+			klog.V(4).Infoln("garbage collector failed to delete", n.key(), err)
+		}
+		changed = true
+	}
+
+	for _, n := range nodes {
+		if n.obj.GetDeletionTimestamp() == nil {
+			continue
+		}
+		uid := n.obj.GetUID()
+		finalizers := n.obj.GetFinalizers()
+
+		if slices.Contains(finalizers, metav1.FinalizerOrphanDependents) {
+			for _, d := range owned[uid] {
+				update(d, func(obj *unstructured.Unstructured) {
+					removeOwnerReferences(obj, func(ref metav1.OwnerReference) bool {
+						return ref.UID == uid
+					})
+				})
+			}
+			update(n, func(obj *unstructured.Unstructured) {
+				removeFinalizer(obj, metav1.FinalizerOrphanDependents)
+			})
+			return changed
+		}
+
+		if slices.Contains(finalizers, metav1.FinalizerDeleteDependents) {
+			blocked := slices.ContainsFunc(owned[uid], func(d gcNode) bool {
+				return slices.ContainsFunc(d.obj.GetOwnerReferences(), func(ref metav1.OwnerReference) bool {
+					return ref.UID == uid && ref.BlockOwnerDeletion != nil && *ref.BlockOwnerDeletion
+				})
+			})
+			if !blocked {
+				update(n, func(obj *unstructured.Unstructured) {
+					removeFinalizer(obj, metav1.FinalizerDeleteDependents)
+				})
+				return changed
+			}
+		}
+	}
+
+	for _, n := range nodes {
+		refs := n.obj.GetOwnerReferences()
+		if len(refs) == 0 || n.obj.GetDeletionTimestamp() != nil {
+			continue
+		}
+
+		var solid, dangling, waiting []types.UID
+		for _, ref := range refs {
+			owner, found := live[ref.UID]
+			switch {
+			case removed.Has(ref.UID):
+				dangling = append(dangling, ref.UID)
+			case found && owner.obj.GetDeletionTimestamp() != nil &&
+				slices.Contains(owner.obj.GetFinalizers(), metav1.FinalizerDeleteDependents):
+				waiting = append(waiting, ref.UID)
+			default:
+				// owners that were never stored in this server are treated as present
+				solid = append(solid, ref.UID)
+			}
+		}
+
+		switch {
+		case len(solid) > 0:
+			if len(dangling)+len(waiting) > 0 {
+				// the dependent is kept by its remaining owners
+				update(n, func(obj *unstructured.Unstructured) {
+					removeOwnerReferences(obj, func(ref metav1.OwnerReference) bool {
+						return slices.Contains(dangling, ref.UID) || slices.Contains(waiting, ref.UID)
+					})
+				})
+			}
+		case len(waiting) > 0:
+			remove(n, []string{metav1.FinalizerDeleteDependents})
+		default:
+			remove(n, nil)
+		}
+	}
+
+	// like the namespace controller, delete the content of terminating namespaces
+	// and finalize them once they are empty
+	for _, n := range nodes {
+		if !n.store.Namespaced || n.obj.GetDeletionTimestamp() != nil {
+			continue
+		}
+		ns, found := namespaces[n.obj.GetNamespace()]
+		if (found && ns.obj.GetDeletionTimestamp() != nil) || (!found && removedNamespaces.Has(n.obj.GetNamespace())) {
+			remove(n, nil)
+		}
+	}
+	// like the CRD finalizer of the apiextensions-apiserver, delete the custom resources
+	// of deleted CRDs and finalize them once none are left
+	for _, n := range nodes {
+		if n.store.GVK != crdGVK || n.obj.GetDeletionTimestamp() == nil ||
+			!slices.Contains(n.obj.GetFinalizers(), crdCleanupFinalizer) {
+			continue
+		}
+		gr := crdGroupResource(n.obj)
+		instances := 0
+		for _, d := range nodes {
+			if d.store.GVR.GroupResource() != gr {
+				continue
+			}
+			instances++
+			if d.obj.GetDeletionTimestamp() == nil {
+				remove(d, nil)
+			}
+		}
+		if instances == 0 {
+			update(n, func(obj *unstructured.Unstructured) {
+				removeFinalizer(obj, crdCleanupFinalizer)
+			})
+		}
+	}
+	for name, ns := range namespaces {
+		if ns.obj.GetDeletionTimestamp() != nil && contents[name] == 0 &&
+			slices.Contains(specFinalizers(ns.obj), string(core.FinalizerKubernetes)) {
+			update(ns, func(obj *unstructured.Unstructured) {
+				removeSpecFinalizer(obj, string(core.FinalizerKubernetes))
+			})
+		}
+	}
+	return changed
+}
+
+// removedOwners returns the uids of removed objects that are still referenced as owners, and
+// forgets the uids of removed objects that no object refers to anymore.
+func (s *Server) removedOwners(owned map[types.UID][]gcNode, live map[types.UID]gcNode) sets.Set[types.UID] {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	removed := sets.New[types.UID]()
+	for uid := range owned {
+		if _, found := live[uid]; !found && s.removedUIDs.Has(uid) {
+			removed.Insert(uid)
+		}
+	}
+	for uid := range s.removedUIDs {
+		if _, found := owned[uid]; !found {
+			s.removedUIDs.Delete(uid)
+		}
+	}
+	return removed
+}
+
+func ownerUIDs(obj *unstructured.Unstructured) sets.Set[types.UID] {
+	uids := sets.New[types.UID]()
+	for _, ref := range obj.GetOwnerReferences() {
+		uids.Insert(ref.UID)
+	}
+	return uids
+}
+
+func removeOwnerReferences(obj *unstructured.Unstructured, match func(ref metav1.OwnerReference) bool) {
+	refs := slices.DeleteFunc(obj.GetOwnerReferences(), match)
+	if len(refs) == 0 {
+		refs = nil
+	}
+	obj.SetOwnerReferences(refs)
+}
+
+func removeFinalizer(obj *unstructured.Unstructured, finalizer string) {
+	finalizers := slices.DeleteFunc(obj.GetFinalizers(), func(f string) bool {
+		return f == finalizer
+	})
+	if len(finalizers) == 0 {
+		finalizers = nil
+	}
+	obj.SetFinalizers(finalizers)
+}
@@ -0,0 +1,155 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"context"
+	"testing"
+
+	core "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/utils/ptr"
+)
+
+func createConfigMap(t *testing.T, kc kubernetes.Interface, name string, owners ...*core.ConfigMap) *core.ConfigMap {
+	t.Helper()
+
+	cm := &core.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: metav1.NamespaceDefault}}
+	for _, owner := range owners {
+		cm.OwnerReferences = append(cm.OwnerReferences, metav1.OwnerReference{
+			APIVersion:         "v1",
+			Kind:               "ConfigMap",
+			Name:               owner.Name,
+			UID:                owner.UID,
+			BlockOwnerDeletion: ptr.To(true),
+		})
+	}
+	cm, err := kc.CoreV1().ConfigMaps(metav1.NamespaceDefault).Create(context.TODO(), cm, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cm
+}
+
+func deleteConfigMap(t *testing.T, kc kubernetes.Interface, name string, policy metav1.DeletionPropagation) {
+	t.Helper()
+
+	err := kc.CoreV1().ConfigMaps(metav1.NamespaceDefault).Delete(context.TODO(), name, metav1.DeleteOptions{PropagationPolicy: &policy})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func getConfigMap(t *testing.T, kc kubernetes.Interface, name string) *core.ConfigMap {
+	t.Helper()
+
+	cm, err := kc.CoreV1().ConfigMaps(metav1.NamespaceDefault).Get(context.TODO(), name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	return cm
+}
+
+func TestGarbageCollectionPropagation(t *testing.T) {
+	_, _, kc, _ := newTestCluster(t)
+
+	owner := createConfigMap(t, kc, "background")
+	createConfigMap(t, kc, "background-dependent", owner)
+	deleteConfigMap(t, kc, owner.Name, metav1.DeletePropagationBackground)
+	if getConfigMap(t, kc, "background-dependent") != nil {
+		t.Error("expected the dependent of a background deletion to be removed")
+	}
+
+	owner = createConfigMap(t, kc, "orphan")
+	createConfigMap(t, kc, "orphan-dependent", owner)
+	deleteConfigMap(t, kc, owner.Name, metav1.DeletePropagationOrphan)
+	if getConfigMap(t, kc, owner.Name) != nil {
+		t.Error("expected the owner of an orphan deletion to be removed")
+	}
+	if d := getConfigMap(t, kc, "orphan-dependent"); d == nil || len(d.OwnerReferences) != 0 {
+		t.Errorf("expected the dependent of an orphan deletion to be kept without owner references, got %v", d)
+	}
+
+	owner = createConfigMap(t, kc, "foreground")
+	other := createConfigMap(t, kc, "other")
+	createConfigMap(t, kc, "foreground-dependent", owner)
+	createConfigMap(t, kc, "shared-dependent", owner, other)
+	deleteConfigMap(t, kc, owner.Name, metav1.DeletePropagationForeground)
+	if getConfigMap(t, kc, owner.Name) != nil || getConfigMap(t, kc, "foreground-dependent") != nil {
+		t.Error("expected the owner and dependent of a foreground deletion to be removed")
+	}
+	if d := getConfigMap(t, kc, "shared-dependent"); d == nil || len(d.OwnerReferences) != 1 || d.OwnerReferences[0].UID != other.UID {
+		t.Errorf("expected the dependent with another owner to be kept by it, got %v", d)
+	}
+}
+
+func TestGarbageCollectionRecreatedOwner(t *testing.T) {
+	s, _, kc, _ := newTestCluster(t)
+
+	// the dependent refers to the uid of the first owner, which is gone
+	// although an owner of the same name exists again
+	first := createConfigMap(t, kc, "owner")
+	createConfigMap(t, kc, "dependent", first)
+	deleteConfigMap(t, kc, first.Name, metav1.DeletePropagationOrphan)
+	if d := getConfigMap(t, kc, "dependent"); d == nil || len(d.OwnerReferences) != 0 {
+		t.Fatalf("expected the orphaned dependent to be kept, got %v", d)
+	}
+	createConfigMap(t, kc, "owner")
+	d := getConfigMap(t, kc, "dependent")
+	d.OwnerReferences = []metav1.OwnerReference{{APIVersion: "v1", Kind: "ConfigMap", Name: first.Name, UID: first.UID}}
+	if _, err := kc.CoreV1().ConfigMaps(metav1.NamespaceDefault).Update(context.TODO(), d, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// any delete runs the garbage collector
+	createConfigMap(t, kc, "trigger")
+	deleteConfigMap(t, kc, "trigger", metav1.DeletePropagationBackground)
+	if getConfigMap(t, kc, "dependent") == nil {
+		t.Error("expected the dependent of a forgotten owner uid to be kept")
+	}
+
+	second := createConfigMap(t, kc, "second-dependent", getConfigMap(t, kc, "owner"))
+	deleteConfigMap(t, kc, "owner", metav1.DeletePropagationBackground)
+	createConfigMap(t, kc, "owner")
+	if getConfigMap(t, kc, second.Name) != nil {
+		t.Error("expected the dependent of a removed owner to be removed")
+	}
+	if getConfigMap(t, kc, "owner") == nil {
+		t.Error("expected the owner of the same name to be kept")
+	}
+
+	// owners that were never stored in this server are treated as present
+	createConfigMap(t, kc, "unknown-owner-dependent", &core.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "unknown", UID: types.UID("unknown")}})
+	createConfigMap(t, kc, "trigger")
+	deleteConfigMap(t, kc, "trigger", metav1.DeletePropagationBackground)
+	if getConfigMap(t, kc, "unknown-owner-dependent") == nil {
+		t.Error("expected the dependent of an unknown owner to be kept")
+	}
+
+	// the uids of removed objects are forgotten once nothing refers to them
+	s.m.Lock()
+	removed := s.removedUIDs.Len()
+	s.m.Unlock()
+	if removed != 0 {
+		t.Errorf("expected no uids of removed objects to be kept, got %d", removed)
+	}
+}
@@ -20,19 +20,34 @@ import (
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
-	httpw "go.wandrs.dev/http"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 )
 
 func (s *Server) Get(w http.ResponseWriter, r *http.Request) {
-	store := s.Store(r)
-	codec := s.codec(w, r)
+	if isWatch(r) {
+		s.Watch(w, r)
+		return
+	}
+
+	store, err := s.Store(r)
+	if err != nil {
+		writeStatus(w, s.encoder(w, r), err)
+		return
+	}
+	codec, err := s.codec(w, r)
+	if err != nil {
+		writeStatus(w, s.encoder(w, r), err)
+		return
+	}
 
 	obj, err := s.GetImpl(store, r)
+	if err == nil {
+		obj, err = s.transformObject(store, r, obj)
+	}
 	if err != nil {
-		_ = codec.Encode(httpw.ErrorToAPIStatus(err), w)
+		writeStatus(w, codec, err)
 		return
 	}
 	_ = codec.Encode(obj, w)
@@ -44,7 +59,10 @@ func (s *Server) GetImpl(store *APIStorage, r *http.Request) (runtime.Object, er
 		Name:      chi.URLParam(r, "name"),
 	}
 
-	obj, exists := store.Get(key)
+	obj, exists, err := store.Get(key)
+	if err != nil {
+		return nil, err
+	}
 	if !exists {
 		return nil, apierrors.NewNotFound(store.GVR.GroupResource(), key.String())
 	}
@@ -17,24 +17,43 @@ limitations under the License.
 package pkg
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/go-chi/chi/v5"
-	httpw "go.wandrs.dev/http"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
-	"k8s.io/apimachinery/pkg/fields"
-	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 )
 
 func (s *Server) List(w http.ResponseWriter, r *http.Request) {
-	store := s.Store(r)
-	codec := s.codec(w, r)
+	if isWatch(r) {
+		s.Watch(w, r)
+		return
+	}
+
+	store, err := s.Store(r)
+	if err != nil {
+		writeStatus(w, s.encoder(w, r), err)
+		return
+	}
+	codec, err := s.codec(w, r)
+	if err != nil {
+		writeStatus(w, s.encoder(w, r), err)
+		return
+	}
 
 	obj, err := s.ListImpl(store, r)
+	if err == nil {
+		obj, err = s.transformObject(store, r, obj)
+	}
 	if err != nil {
-		_ = codec.Encode(httpw.ErrorToAPIStatus(err), w)
+		writeStatus(w, codec, err)
 		return
 	}
 	_ = codec.Encode(obj, w)
@@ -47,60 +66,119 @@ func (s *Server) ListImpl(store *APIStorage, r *http.Request) (runtime.Object, e
 		return nil, err
 	}
 
-	// List type
-	items := store.Items()
+	match, err := s.newMatcher(store, chi.URLParam(r, "namespace"), "", opts)
+	if err != nil {
+		return nil, err
+	}
 
-	ns := chi.URLParam(r, "namespace")
-	if ns != "" {
-		filtered := items[:0]
-		for _, item := range items {
-			if item.GetNamespace() == ns {
-				filtered = append(filtered, item)
-			}
+	var rv int64
+	var last *unstructured.Unstructured
+	if opts.Continue != "" {
+		if opts.ResourceVersion != "" && opts.ResourceVersion != "0" {
+			return nil, apierrors.NewBadRequest("specifying resource version is not allowed when using continue")
+		}
+		rv, last, err = decodeContinue(opts.Continue)
+		if err != nil {
+			return nil, apierrors.NewBadRequest(fmt.Sprintf("invalid continue token: %v", err))
+		}
+	} else if opts.ResourceVersion != "" && opts.ResourceVersionMatch == metav1.ResourceVersionMatchExact {
+		rv, err = strconv.ParseInt(opts.ResourceVersion, 10, 64)
+		if err != nil {
+			return nil, apierrors.NewBadRequest(fmt.Sprintf("invalid resource version %q", opts.ResourceVersion))
 		}
-		items = filtered
 	}
 
-	if opts.LabelSelector != "" {
-		sel, err := labels.Parse(opts.LabelSelector)
-		if err != nil {
-			return nil, err
+	objs, listRV, err := store.List(rv)
+	if apierrors.IsResourceExpired(err) && opts.Continue != "" {
+		return nil, apierrors.NewResourceExpired(continueExpiredMsg)
+	} else if err != nil {
+		return nil, err
+	}
+
+	items := make([]unstructured.Unstructured, 0, len(objs))
+	var remaining int64
+	for _, obj := range objs {
+		if last != nil && !keyLess(last, obj) {
+			continue
 		}
-		filtered := items[:0]
-		for _, item := range items {
-			if sel.Matches(labels.Set(item.GetLabels())) {
-				filtered = append(filtered, item)
-			}
+		if !match(obj) {
+			continue
 		}
-		items = filtered
+		if opts.Limit > 0 && int64(len(items)) == opts.Limit {
+			remaining++
+			continue
+		}
+		items = append(items, *obj)
 	}
 
-	if opts.FieldSelector != "" {
-		sel, err := fields.ParseSelector(opts.FieldSelector)
+	list := newList(store, items, listRV)
+	if remaining > 0 {
+		token, err := encodeContinue(listRV, &items[len(items)-1])
 		if err != nil {
 			return nil, err
 		}
-		filtered := items[:0]
-		for _, item := range items {
-			if sel.Matches(ObjectToFieldLabels(&item)) {
-				filtered = append(filtered, item)
-			}
-		}
-		items = filtered
+		list.SetContinue(token)
+		list.SetRemainingItemCount(&remaining)
 	}
 
+	return list, nil
+}
+
+// newList returns a list of the kind served by store, e.g. a DeploymentList, at resourceVersion rv.
+func newList(store *APIStorage, items []unstructured.Unstructured, rv int64) *unstructured.UnstructuredList {
 	list := unstructured.UnstructuredList{
 		Items: items,
 	}
-	list.SetAPIVersion("v1")
-	list.SetKind("List")
+	list.SetGroupVersionKind(store.GVK.GroupVersion().WithKind(store.GVK.Kind + "List"))
+	list.SetResourceVersion(strconv.FormatInt(rv, 10))
+	return &list
+}
+
+const continueExpiredMsg = "The provided continue parameter is too old to display a consistent list result. " +
+	"You can start a new list without the continue parameter."
 
-	return &list, err
+// continueToken is the opaque continue token of a paginated list. It pins the resourceVersion of
+// the first page, so that all pages show the same snapshot.
+type continueToken struct {
+	APIVersion      string `json:"v"`
+	ResourceVersion int64  `json:"rv"`
+	// LastKey is the namespace/name of the last object returned
+	LastKey string `json:"last"`
 }
 
-func ObjectToFieldLabels(obj metav1.Object) fields.Fields {
-	return fields.Set{
-		"metadata.name":      obj.GetName(),
-		"metadata.namespace": obj.GetNamespace(),
+func encodeContinue(rv int64, last *unstructured.Unstructured) (string, error) {
+	data, err := json.Marshal(continueToken{
+		APIVersion:      metav1.SchemeGroupVersion.String(),
+		ResourceVersion: rv,
+		LastKey:         last.GetNamespace() + "/" + last.GetName(),
+	})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeContinue(token string) (int64, *unstructured.Unstructured, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return 0, nil, err
+	}
+	var c continueToken
+	if err := json.Unmarshal(data, &c); err != nil {
+		return 0, nil, err
+	}
+	if c.APIVersion != metav1.SchemeGroupVersion.String() {
+		return 0, nil, fmt.Errorf("unsupported version %q", c.APIVersion)
+	}
+	if c.ResourceVersion <= 0 {
+		return 0, nil, fmt.Errorf("invalid resource version %d", c.ResourceVersion)
+	}
+	ns, name, found := strings.Cut(c.LastKey, "/")
+	if !found || name == "" {
+		return 0, nil, fmt.Errorf("invalid key %q", c.LastKey)
 	}
+	var last unstructured.Unstructured
+	last.SetNamespace(ns)
+	last.SetName(name)
+	return c.ResourceVersion, &last, nil
 }
@@ -0,0 +1,323 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	core "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+func TestListPagination(t *testing.T) {
+	s, _, kc, _ := newTestCluster(t)
+	ctx := context.TODO()
+	cms := kc.CoreV1().ConfigMaps(metav1.NamespaceDefault)
+
+	for _, name := range []string{"c", "a", "e", "b", "d"} {
+		_, err := cms.Create(ctx, &core.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{"app": "test"}},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	names := func(list *core.ConfigMapList) []string {
+		var out []string
+		for _, cm := range list.Items {
+			out = append(out, cm.Name)
+		}
+		return out
+	}
+
+	opts := metav1.ListOptions{LabelSelector: "app=test", Limit: 2}
+	first, err := cms.List(ctx, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(names(first), []string{"a", "b"}) {
+		t.Errorf("expected the first page to be sorted by name, got %v", names(first))
+	}
+	if first.Continue == "" || first.RemainingItemCount == nil || *first.RemainingItemCount != 3 {
+		t.Errorf("expected a continue token and 3 remaining items, got %q and %v", first.Continue, first.RemainingItemCount)
+	}
+
+	// the next pages show the snapshot of the first page
+	if _, err := cms.Create(ctx, &core.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "bb", Labels: map[string]string{"app": "test"}},
+	}, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cms.Patch(ctx, "c", types.MergePatchType, []byte(`{"data": {"k": "v"}}`), metav1.PatchOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	opts.Continue = first.Continue
+	second, err := cms.List(ctx, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(names(second), []string{"c", "d"}) || second.ResourceVersion != first.ResourceVersion {
+		t.Errorf("expected the second page of the first snapshot, got %v at %s", names(second), second.ResourceVersion)
+	}
+	if len(second.Items) > 0 && len(second.Items[0].Data) != 0 {
+		t.Errorf("expected the object as of the first page, got %v", second.Items[0].Data)
+	}
+	opts.Continue = second.Continue
+	third, err := cms.List(ctx, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(names(third), []string{"e"}) || third.Continue != "" || third.RemainingItemCount != nil {
+		t.Errorf("expected the last page without continue token, got %v, %q and %v", names(third), third.Continue, third.RemainingItemCount)
+	}
+
+	for _, opts := range []metav1.ListOptions{
+		{Continue: first.Continue, ResourceVersion: first.ResourceVersion},
+		{Continue: "not-a-token"},
+	} {
+		if _, err := cms.List(ctx, opts); !apierrors.IsBadRequest(err) {
+			t.Errorf("expected BadRequest for %+v, got %v", opts, err)
+		}
+	}
+
+	// the snapshot of a continue token is lost on restore
+	s.SaveCheckpoint("base")
+	if err := s.Rollback("base"); err != nil {
+		t.Fatal(err)
+	}
+	_, err = cms.List(ctx, metav1.ListOptions{LabelSelector: "app=test", Limit: 2, Continue: first.Continue})
+	if !apierrors.IsResourceExpired(err) {
+		t.Fatalf("expected Expired for an expired continue token, got %v", err)
+	}
+	if code := err.(apierrors.APIStatus).Status().Code; code != http.StatusGone {
+		t.Errorf("expected status code %d, got %d", http.StatusGone, code)
+	}
+}
+
+func TestListKindAndResourceVersion(t *testing.T) {
+	s, _, kc, dc := newTestCluster(t)
+	ctx := context.TODO()
+	createCRD(t, dc, widgetCRD)
+	createConfigMap(t, kc, "a")
+	if _, err := kc.AppsV1().Deployments(metav1.NamespaceDefault).Create(ctx, testDeployment("web", 1), metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	widgetGVR := schema.GroupVersionResource{Group: "example.com", Version: "v1", Resource: "widgets"}
+	if _, err := dc.Resource(widgetGVR).Namespace(metav1.NamespaceDefault).Create(ctx, newWidget("w1", nil), metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	current := strconv.FormatInt(s.CurrentResourceVersion(), 10)
+
+	for _, tc := range []struct {
+		gvr        schema.GroupVersionResource
+		apiVersion string
+		kind       string
+	}{
+		{schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}, "v1", "ConfigMapList"},
+		{schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}, "apps/v1", "DeploymentList"},
+		{widgetGVR, "example.com/v1", "WidgetList"},
+	} {
+		list, err := dc.Resource(tc.gvr).Namespace(metav1.NamespaceDefault).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if list.GetAPIVersion() != tc.apiVersion || list.GetKind() != tc.kind {
+			t.Errorf("%s: expected %s %s, got %s %s", tc.gvr.Resource, tc.apiVersion, tc.kind, list.GetAPIVersion(), list.GetKind())
+		}
+		if list.GetResourceVersion() != current {
+			t.Errorf("%s: expected the current resourceVersion %s, got %q", tc.gvr.Resource, current, list.GetResourceVersion())
+		}
+	}
+
+	// a watch started at the resourceVersion of a list gets the changes after it
+	cms := kc.CoreV1().ConfigMaps(metav1.NamespaceDefault)
+	list, err := cms.List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := cms.Watch(ctx, metav1.ListOptions{ResourceVersion: list.ResourceVersion})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+	createConfigMap(t, kc, "b")
+	expectEvent(t, w, watch.Added, "b")
+
+	data, err := kc.CoreV1().RESTClient().Delete().
+		Namespace(metav1.NamespaceDefault).
+		Resource("configmaps").
+		Param("fieldSelector", "metadata.name=a").
+		DoRaw(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var deleted unstructured.UnstructuredList
+	if err := deleted.UnmarshalJSON(data); err != nil {
+		t.Fatal(err)
+	}
+	if deleted.GetAPIVersion() != "v1" || deleted.GetKind() != "ConfigMapList" || len(deleted.Items) != 1 || deleted.Items[0].GetName() != "a" {
+		t.Errorf("expected a ConfigMapList of the deleted objects, got %s %s with %d items", deleted.GetAPIVersion(), deleted.GetKind(), len(deleted.Items))
+	}
+	if rv := strconv.FormatInt(s.CurrentResourceVersion(), 10); deleted.GetResourceVersion() != rv {
+		t.Errorf("expected the current resourceVersion %s, got %q", rv, deleted.GetResourceVersion())
+	}
+}
+
+const selectableWidgetCRD = `{
+	"apiVersion": "apiextensions.k8s.io/v1",
+	"kind": "CustomResourceDefinition",
+	"metadata": {"name": "widgets.example.com"},
+	"spec": {
+		"group": "example.com",
+		"scope": "Namespaced",
+		"names": {"plural": "widgets", "singular": "widget", "kind": "Widget", "listKind": "WidgetList"},
+		"versions": [
+			{
+				"name": "v1", "served": true, "storage": true,
+				"schema": {"openAPIV3Schema": {"type": "object", "x-kubernetes-preserve-unknown-fields": true}},
+				"selectableFields": [{"jsonPath": ".spec.color"}]
+			}
+		]
+	}
+}`
+
+func TestFieldSelectors(t *testing.T) {
+	_, _, kc, dc := newTestCluster(t)
+	ctx := context.TODO()
+	pods := kc.CoreV1().Pods(metav1.NamespaceDefault)
+
+	for name, node := range map[string]string{"p1": "n1", "p2": "n2"} {
+		_, err := pods.Create(ctx, &core.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: core.PodSpec{
+				NodeName:   node,
+				Containers: []core.Container{{Name: "c", Image: "busybox"}},
+			},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for secretType, name := range map[core.SecretType]string{core.SecretTypeOpaque: "opaque", "example.com/custom": "custom"} {
+		_, err := kc.CoreV1().Secrets(metav1.NamespaceDefault).Create(ctx, &core.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Type:       secretType,
+		}, metav1.CreateOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := kc.CoreV1().Events(metav1.NamespaceDefault).Create(ctx, &core.Event{
+		ObjectMeta:     metav1.ObjectMeta{Name: "p1.1"},
+		InvolvedObject: core.ObjectReference{Kind: "Pod", Namespace: metav1.NamespaceDefault, Name: "p1"},
+		Reason:         "Scheduled",
+		Type:           core.EventTypeNormal,
+	}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	createCRD(t, dc, selectableWidgetCRD)
+	widgets := dc.Resource(schema.GroupVersionResource{Group: "example.com", Version: "v1", Resource: "widgets"}).Namespace(metav1.NamespaceDefault)
+	for name, color := range map[string]string{"red": "red", "blue": "blue"} {
+		if _, err := widgets.Create(ctx, newWidget(name, map[string]any{"color": color}), metav1.CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tc := range []struct {
+		gvr      schema.GroupVersionResource
+		selector string
+		expected []string
+	}{
+		{schema.GroupVersionResource{Version: "v1", Resource: "pods"}, "spec.nodeName=n1", []string{"p1"}},
+		{schema.GroupVersionResource{Version: "v1", Resource: "pods"}, "spec.nodeName!=n1", []string{"p2"}},
+		{schema.GroupVersionResource{Version: "v1", Resource: "pods"}, "metadata.name=p2,spec.nodeName=n1", nil},
+		{schema.GroupVersionResource{Version: "v1", Resource: "secrets"}, "type=example.com/custom", []string{"custom"}},
+		{schema.GroupVersionResource{Version: "v1", Resource: "events"}, "involvedObject.name=p1,reason=Scheduled", []string{"p1.1"}},
+		{schema.GroupVersionResource{Group: "example.com", Version: "v1", Resource: "widgets"}, "spec.color=red", []string{"red"}},
+	} {
+		list, err := dc.Resource(tc.gvr).Namespace(metav1.NamespaceDefault).List(ctx, metav1.ListOptions{FieldSelector: tc.selector})
+		if err != nil {
+			t.Errorf("%s %s: %v", tc.gvr.Resource, tc.selector, err)
+			continue
+		}
+		var names []string
+		for _, obj := range list.Items {
+			names = append(names, obj.GetName())
+		}
+		if !slices.Equal(names, tc.expected) {
+			t.Errorf("%s %s: expected %v, got %v", tc.gvr.Resource, tc.selector, tc.expected, names)
+		}
+	}
+
+	// unsupported field labels are rejected by list, watch and deletecollection
+	for _, tc := range []struct {
+		gvr      schema.GroupVersionResource
+		selector string
+	}{
+		{schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}, "data.key=value"},
+		{schema.GroupVersionResource{Version: "v1", Resource: "pods"}, "spec.hostname=web"},
+		{schema.GroupVersionResource{Group: "example.com", Version: "v1", Resource: "widgets"}, "spec.size=1"},
+	} {
+		client := dc.Resource(tc.gvr).Namespace(metav1.NamespaceDefault)
+		opts := metav1.ListOptions{FieldSelector: tc.selector}
+		if _, err := client.List(ctx, opts); !apierrors.IsBadRequest(err) {
+			t.Errorf("list %s %s: expected BadRequest, got %v", tc.gvr.Resource, tc.selector, err)
+		}
+		if _, err := client.Watch(ctx, opts); !apierrors.IsBadRequest(err) {
+			t.Errorf("watch %s %s: expected BadRequest, got %v", tc.gvr.Resource, tc.selector, err)
+		}
+		if err := client.DeleteCollection(ctx, metav1.DeleteOptions{}, opts); !apierrors.IsBadRequest(err) {
+			t.Errorf("deletecollection %s %s: expected BadRequest, got %v", tc.gvr.Resource, tc.selector, err)
+		}
+	}
+
+	w, err := pods.Watch(ctx, metav1.ListOptions{FieldSelector: "spec.nodeName=n2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+	select {
+	case e := <-w.ResultChan():
+		if pod, ok := e.Object.(*core.Pod); e.Type != watch.Added || !ok || pod.Name != "p2" {
+			t.Errorf("expected an ADDED event for p2, got %s %v", e.Type, e.Object)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected an event, got none")
+	}
+
+	if err := pods.DeleteCollection(ctx, metav1.DeleteOptions{}, metav1.ListOptions{FieldSelector: "spec.nodeName=n1"}); err != nil {
+		t.Fatal(err)
+	}
+	list, err := pods.List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Items) != 1 || list.Items[0].Name != "p2" {
+		t.Errorf("expected only the pods selected by the field selector to be deleted, got %v", list.Items)
+	}
+}
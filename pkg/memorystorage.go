@@ -0,0 +1,398 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+
+	core "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+const (
+	// maxWatchEvents is the number of events kept per store to serve watches from an older resourceVersion.
+	maxWatchEvents = 10000
+	// watchChanSize is the number of pending events after which a slow watcher is terminated.
+	watchChanSize = 1000
+)
+
+// memoryStorage keeps the objects of a resource in memory, with the history of their changes.
+// Objects are kept at the version they were written at, which is the storage version
+// of the resource at that time.
+type memoryStorage struct {
+	m sync.RWMutex
+
+	config  StorageConfig
+	current map[types.NamespacedName]*unstructured.Unstructured
+	deleted map[types.NamespacedName]*unstructured.Unstructured
+
+	events           []StoreEvent
+	compactedVersion int64
+	watchers         map[*memoryWatcher]struct{}
+}
+
+var _ Storage = &memoryStorage{}
+
+// NewMemoryStorage returns a Storage that keeps the objects in memory. It is the default Storage of the server.
+func NewMemoryStorage(config StorageConfig) Storage {
+	return &memoryStorage{
+		config:   config,
+		current:  make(map[types.NamespacedName]*unstructured.Unstructured),
+		deleted:  make(map[types.NamespacedName]*unstructured.Unstructured),
+		watchers: make(map[*memoryWatcher]struct{}),
+	}
+}
+
+func (s *memoryStorage) gr() schema.GroupResource {
+	return s.config.GVR.GroupResource()
+}
+
+func (s *memoryStorage) Get(key types.NamespacedName) (*unstructured.Unstructured, bool) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	obj, found := s.current[key]
+	return obj, found
+}
+
+func (s *memoryStorage) List(rv int64) ([]*unstructured.Unstructured, int64, error) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	current := s.config.Versioner.CurrentResourceVersion()
+	if rv == 0 {
+		rv = current
+	} else if rv > current {
+		return nil, 0, apierrors.NewTimeoutError(fmt.Sprintf("Too large resource version: %d, current: %d", rv, current), 1)
+	} else if rv < s.compactedVersion {
+		return nil, 0, apierrors.NewResourceExpired(fmt.Sprintf("too old resource version: %d (%d)", rv, s.compactedVersion))
+	}
+
+	objs := make(map[types.NamespacedName]*unstructured.Unstructured, len(s.current))
+	for key, obj := range s.current {
+		objs[key] = obj
+	}
+	// undo the changes after rv
+	for i := len(s.events) - 1; i >= 0 && s.events[i].ResourceVersion > rv; i-- {
+		e := s.events[i]
+		key := types.NamespacedName{
+			Namespace: e.Object.GetNamespace(),
+			Name:      e.Object.GetName(),
+		}
+		if e.Prev == nil {
+			delete(objs, key)
+		} else {
+			objs[key] = e.Prev
+		}
+	}
+
+	result := make([]*unstructured.Unstructured, 0, len(objs))
+	for _, obj := range objs {
+		result = append(result, obj)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return keyLess(result[i], result[j])
+	})
+	return result, rv, nil
+}
+
+func (s *memoryStorage) Deleted() []*unstructured.Unstructured {
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	result := make([]*unstructured.Unstructured, 0, len(s.deleted))
+	for _, obj := range s.deleted {
+		result = append(result, obj)
+	}
+	return result
+}
+
+func (s *memoryStorage) Create(obj *unstructured.Unstructured) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	key := types.NamespacedName{
+		Namespace: obj.GetNamespace(),
+		Name:      obj.GetName(),
+	}
+	if _, exists := s.current[key]; exists {
+		return apierrors.NewAlreadyExists(s.gr(), key.Name)
+	}
+	s.insert(obj)
+	return nil
+}
+
+func (s *memoryStorage) Update(obj *unstructured.Unstructured, allowCreate bool) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	key := types.NamespacedName{
+		Namespace: obj.GetNamespace(),
+		Name:      obj.GetName(),
+	}
+	cur, exists := s.current[key]
+	if !exists && (!allowCreate || obj.GetResourceVersion() != "") {
+		return apierrors.NewNotFound(s.gr(), key.Name)
+	}
+	if rv := obj.GetResourceVersion(); rv != "" && exists && cur.GetResourceVersion() != rv {
+		return apierrors.NewConflict(s.gr(), key.Name, errors.New(OptimisticLockErrorMsg))
+	}
+	s.put(key, obj)
+	return nil
+}
+
+func (s *memoryStorage) UpdateFunc(key types.NamespacedName, uid types.UID, fn func(obj *unstructured.Unstructured)) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	obj, exists := s.current[key]
+	if !exists || obj.GetUID() != uid {
+		return apierrors.NewNotFound(s.gr(), key.String())
+	}
+	obj = obj.DeepCopy()
+	fn(obj)
+	s.put(key, obj)
+	return nil
+}
+
+func (s *memoryStorage) Delete(key types.NamespacedName, preconditions *metav1.Preconditions, finalizers []string) (*unstructured.Unstructured, bool, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	obj, exists := s.current[key]
+	if !exists {
+		return nil, false, apierrors.NewNotFound(s.gr(), key.String())
+	}
+	if err := checkPreconditions(s.gr(), obj, preconditions); err != nil {
+		return nil, false, err
+	}
+	if !hasFinalizers(s.config.GVK, obj) && len(finalizers) == 0 {
+		obj, _ = s.remove(key)
+		return obj, true, nil
+	}
+
+	existing := obj.GetFinalizers()
+	changed := false
+	for _, f := range finalizers {
+		if !slices.Contains(existing, f) {
+			existing = append(existing, f)
+			changed = true
+		}
+	}
+	if !changed && obj.GetDeletionTimestamp() != nil {
+		// already marked for deletion
+		return obj, false, nil
+	}
+
+	obj = obj.DeepCopy()
+	obj.SetFinalizers(existing)
+	if obj.GetDeletionTimestamp() == nil {
+		now := metav1.Now()
+		obj.SetDeletionTimestamp(&now)
+		// objects in this server are not deleted gracefully
+		var gracePeriodSeconds int64
+		obj.SetDeletionGracePeriodSeconds(&gracePeriodSeconds)
+		if s.config.GVK == nsGVK {
+			_ = unstructured.SetNestedField(obj.Object, string(core.NamespaceTerminating), "status", "phase")
+		}
+	}
+	s.insert(obj)
+	return obj, false, nil
+}
+
+func (s *memoryStorage) Load(objs []*unstructured.Unstructured, rv int64) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	for _, obj := range objs {
+		key := types.NamespacedName{
+			Namespace: obj.GetNamespace(),
+			Name:      obj.GetName(),
+		}
+		s.current[key] = obj
+	}
+	s.compactedVersion = rv
+}
+
+// put stores obj, unless it is marked for deletion and has no finalizers left, in which case it is removed.
+func (s *memoryStorage) put(key types.NamespacedName, obj *unstructured.Unstructured) {
+	if obj.GetDeletionTimestamp() != nil && !hasFinalizers(s.config.GVK, obj) {
+		if _, exists := s.current[key]; exists {
+			s.removeAs(key, obj)
+			return
+		}
+	}
+	s.insert(obj)
+}
+
+func (s *memoryStorage) insert(obj *unstructured.Unstructured) {
+	rv := s.config.Versioner.NextResourceVersion()
+	obj.SetResourceVersion(fmt.Sprintf("%d", rv))
+
+	key := types.NamespacedName{
+		Namespace: obj.GetNamespace(),
+		Name:      obj.GetName(),
+	}
+	prev, exists := s.current[key]
+	s.current[key] = obj
+	delete(s.deleted, key)
+	if s.config.OnChange != nil {
+		s.config.OnChange(obj, false)
+	}
+
+	if exists {
+		s.notify(watch.Modified, obj, prev, rv)
+	} else {
+		s.notify(watch.Added, obj, nil, rv)
+	}
+}
+
+func (s *memoryStorage) remove(key types.NamespacedName) (*unstructured.Unstructured, bool) {
+	obj, exists := s.current[key]
+	if !exists {
+		return nil, false
+	}
+	// copy so that earlier watch events keep their resourceVersion
+	return s.removeAs(key, obj.DeepCopy()), true
+}
+
+// removeAs removes the object with key, recording obj as its final state.
+func (s *memoryStorage) removeAs(key types.NamespacedName, obj *unstructured.Unstructured) *unstructured.Unstructured {
+	prev := s.current[key]
+	delete(s.current, key)
+
+	rv := s.config.Versioner.NextResourceVersion()
+	obj.SetResourceVersion(fmt.Sprintf("%d", rv))
+	s.deleted[key] = obj
+	if s.config.OnChange != nil {
+		s.config.OnChange(obj, true)
+	}
+	s.notify(watch.Deleted, obj, prev, rv)
+	return obj
+}
+
+// notify must be called with the store lock held.
+func (s *memoryStorage) notify(t watch.EventType, obj, prev *unstructured.Unstructured, rv int64) {
+	e := StoreEvent{
+		Type:            t,
+		Object:          obj,
+		Prev:            prev,
+		ResourceVersion: rv,
+	}
+	s.events = append(s.events, e)
+	if n := len(s.events) - maxWatchEvents; n > 0 {
+		s.compactedVersion = s.events[n-1].ResourceVersion
+		s.events = append([]StoreEvent(nil), s.events[n:]...)
+	}
+
+	for w := range s.watchers {
+		select {
+		case w.ch <- e:
+		default:
+			// terminate slow watchers, so that the client relists
+			delete(s.watchers, w)
+			close(w.ch)
+		}
+	}
+}
+
+func (s *memoryStorage) Watch(rv int64, sendInitialEvents bool) (Watcher, []StoreEvent, int64, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	var events []StoreEvent
+	if sendInitialEvents {
+		events = make([]StoreEvent, 0, len(s.current))
+		for _, obj := range s.current {
+			events = append(events, StoreEvent{
+				Type:            watch.Added,
+				Object:          obj,
+				ResourceVersion: int64(atoi(obj.GetResourceVersion())),
+			})
+		}
+		sort.Slice(events, func(i, j int) bool {
+			return events[i].ResourceVersion < events[j].ResourceVersion
+		})
+	} else if rv > 0 {
+		if rv < s.compactedVersion {
+			return nil, nil, 0, apierrors.NewResourceExpired(fmt.Sprintf("too old resource version: %d (%d)", rv, s.compactedVersion))
+		}
+		for _, e := range s.events {
+			if e.ResourceVersion > rv {
+				events = append(events, e)
+			}
+		}
+	}
+
+	w := &memoryWatcher{
+		s:  s,
+		ch: make(chan StoreEvent, watchChanSize),
+	}
+	s.watchers[w] = struct{}{}
+	return w, events, s.config.Versioner.CurrentResourceVersion(), nil
+}
+
+func (s *memoryStorage) Close() {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	for w := range s.watchers {
+		delete(s.watchers, w)
+		close(w.ch)
+	}
+}
+
+type memoryWatcher struct {
+	s  *memoryStorage
+	ch chan StoreEvent
+}
+
+func (w *memoryWatcher) ResultChan() <-chan StoreEvent {
+	return w.ch
+}
+
+func (w *memoryWatcher) Bookmark() {
+	w.s.m.Lock()
+	defer w.s.m.Unlock()
+
+	if _, found := w.s.watchers[w]; !found {
+		return
+	}
+	select {
+	case w.ch <- StoreEvent{Type: watch.Bookmark, ResourceVersion: w.s.config.Versioner.CurrentResourceVersion()}:
+	default:
+	}
+}
+
+func (w *memoryWatcher) Stop() {
+	w.s.m.Lock()
+	defer w.s.m.Unlock()
+
+	if _, found := w.s.watchers[w]; found {
+		delete(w.s.watchers, w)
+		close(w.ch)
+	}
+}
@@ -0,0 +1,300 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"kmodules.xyz/fake-apiserver/pkg/resources"
+
+	core "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+)
+
+type testVersioner struct {
+	rv int64
+}
+
+func (v *testVersioner) NextResourceVersion() int64 {
+	v.rv++
+	return v.rv
+}
+
+func (v *testVersioner) CurrentResourceVersion() int64 {
+	return v.rv
+}
+
+func newTestStorage() Storage {
+	return newTestStorageAt(0)
+}
+
+func newTestStorageAt(rv int64) Storage {
+	return NewMemoryStorage(StorageConfig{
+		GVR:        core.SchemeGroupVersion.WithResource("configmaps"),
+		GVK:        core.SchemeGroupVersion.WithKind("ConfigMap"),
+		Namespaced: true,
+		Versioner:  &testVersioner{rv: rv},
+	})
+}
+
+func newStoredConfigMap(name string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion("v1")
+	obj.SetKind("ConfigMap")
+	obj.SetNamespace(metav1.NamespaceDefault)
+	obj.SetName(name)
+	return obj
+}
+
+func storedNames(objs []*unstructured.Unstructured) []string {
+	names := make([]string, 0, len(objs))
+	for _, obj := range objs {
+		names = append(names, obj.GetName())
+	}
+	return names
+}
+
+func TestMemoryStorageWrites(t *testing.T) {
+	s := newTestStorage()
+	key := types.NamespacedName{Namespace: metav1.NamespaceDefault, Name: "a"}
+
+	if err := s.Create(newStoredConfigMap("b")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Create(newStoredConfigMap("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Create(newStoredConfigMap("a")); !apierrors.IsAlreadyExists(err) {
+		t.Errorf("expected AlreadyExists, got %v", err)
+	}
+	a, found := s.Get(key)
+	if !found || a.GetResourceVersion() != "2" {
+		t.Fatalf("expected a at resourceVersion 2, got %v", a)
+	}
+
+	stale := a.DeepCopy()
+	updated := a.DeepCopy()
+	updated.SetLabels(map[string]string{"k": "v"})
+	if err := s.Update(updated, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Update(stale, false); !apierrors.IsConflict(err) {
+		t.Errorf("expected Conflict for a stale resourceVersion, got %v", err)
+	}
+	unconditional := stale.DeepCopy()
+	unconditional.SetResourceVersion("")
+	if err := s.Update(unconditional, false); err != nil {
+		t.Errorf("expected an update without resourceVersion to succeed, got %v", err)
+	}
+
+	if err := s.Update(newStoredConfigMap("c"), false); !apierrors.IsNotFound(err) {
+		t.Errorf("expected NotFound without allowCreate, got %v", err)
+	}
+	withRV := newStoredConfigMap("c")
+	withRV.SetResourceVersion("1")
+	if err := s.Update(withRV, true); !apierrors.IsNotFound(err) {
+		t.Errorf("expected NotFound for a missing object with a resourceVersion, got %v", err)
+	}
+	if err := s.Update(newStoredConfigMap("c"), true); err != nil {
+		t.Errorf("expected allowCreate to create a missing object, got %v", err)
+	}
+
+	a, _ = s.Get(key)
+	if err := s.UpdateFunc(key, "other-uid", func(obj *unstructured.Unstructured) {}); !apierrors.IsNotFound(err) {
+		t.Errorf("expected NotFound for another uid, got %v", err)
+	}
+	if err := s.UpdateFunc(key, a.GetUID(), func(obj *unstructured.Unstructured) {
+		obj.SetAnnotations(map[string]string{"k": "v"})
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.Get(key); got.GetAnnotations()["k"] != "v" || a.GetAnnotations() != nil {
+		t.Errorf("expected UpdateFunc to change a copy of the object, got %v", got)
+	}
+
+	// objects with finalizers are only marked for deletion
+	otherRV := "1"
+	if _, _, err := s.Delete(key, &metav1.Preconditions{ResourceVersion: &otherRV}, nil); !apierrors.IsConflict(err) {
+		t.Errorf("expected Conflict for a mismatched precondition, got %v", err)
+	}
+	obj, removed, err := s.Delete(key, nil, []string{metav1.FinalizerOrphanDependents})
+	if err != nil || removed || obj.GetDeletionTimestamp() == nil {
+		t.Fatalf("expected the object to be marked for deletion, got %v, %v and %v", obj, removed, err)
+	}
+	obj = obj.DeepCopy()
+	obj.SetFinalizers(nil)
+	if err := s.Update(obj, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := s.Get(key); found {
+		t.Error("expected the object to be removed once its finalizers are cleared")
+	}
+	if !slices.Contains(storedNames(s.Deleted()), "a") {
+		t.Errorf("expected a to be deleted, got %v", storedNames(s.Deleted()))
+	}
+	if _, removed, err := s.Delete(types.NamespacedName{Namespace: metav1.NamespaceDefault, Name: "b"}, nil, nil); err != nil || !removed {
+		t.Errorf("expected b to be removed right away, got %v and %v", removed, err)
+	}
+	if _, _, err := s.Delete(key, nil, nil); !apierrors.IsNotFound(err) {
+		t.Errorf("expected NotFound for a removed object, got %v", err)
+	}
+}
+
+func TestMemoryStorageHistory(t *testing.T) {
+	s := newTestStorage()
+	for _, name := range []string{"b", "a"} {
+		if err := s.Create(newStoredConfigMap(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a, _ := s.Get(types.NamespacedName{Namespace: metav1.NamespaceDefault, Name: "a"})
+	updated := a.DeepCopy()
+	updated.SetLabels(map[string]string{"k": "v"})
+	if err := s.Update(updated, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.Delete(types.NamespacedName{Namespace: metav1.NamespaceDefault, Name: "b"}, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for rv, expected := range map[int64][]string{0: {"a"}, 1: {"b"}, 2: {"a", "b"}, 3: {"a", "b"}} {
+		objs, listRV, err := s.List(rv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(storedNames(objs), expected) {
+			t.Errorf("list at %d: expected %v, got %v", rv, expected, storedNames(objs))
+		}
+		if rv == 0 && listRV != 4 || rv != 0 && listRV != rv {
+			t.Errorf("list at %d: got resourceVersion %d", rv, listRV)
+		}
+		if rv == 2 && objs[0].GetLabels() != nil {
+			t.Errorf("list at %d: expected a as of resourceVersion 2, got %v", rv, objs[0])
+		}
+	}
+	if _, _, err := s.List(5); !apierrors.IsTimeout(err) {
+		t.Errorf("expected Timeout for a future resourceVersion, got %v", err)
+	}
+
+	w, events, rv, err := s.Watch(2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rv != 4 || len(events) != 2 || events[0].Type != watch.Modified || events[1].Type != watch.Deleted {
+		t.Errorf("expected the MODIFIED and DELETED events after resourceVersion 2, got %v at %d", events, rv)
+	}
+	if err := s.Create(newStoredConfigMap("c")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case e := <-w.ResultChan():
+		if e.Type != watch.Added || e.Object.GetName() != "c" || e.ResourceVersion != 5 {
+			t.Errorf("expected an ADDED event for c at 5, got %s %v at %d", e.Type, e.Object, e.ResourceVersion)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected an event, got none")
+	}
+	w.Bookmark()
+	if e := <-w.ResultChan(); e.Type != watch.Bookmark || e.ResourceVersion != 5 {
+		t.Errorf("expected a BOOKMARK at 5, got %s at %d", e.Type, e.ResourceVersion)
+	}
+	w.Stop()
+	if _, open := <-w.ResultChan(); open {
+		t.Error("expected the channel of a stopped watcher to be closed")
+	}
+
+	_, events, _, err = s.Watch(0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].Object.GetName() != "a" || events[1].Object.GetName() != "c" {
+		t.Errorf("expected initial ADDED events for a and c, got %v", events)
+	}
+
+	// the history before a loaded snapshot is not known
+	loaded := newTestStorageAt(4)
+	loaded.Load([]*unstructured.Unstructured{a}, 4)
+	if _, _, err := loaded.List(3); !apierrors.IsResourceExpired(err) {
+		t.Errorf("expected Expired for a list before the snapshot, got %v", err)
+	}
+	if _, _, _, err := loaded.Watch(3, false); !apierrors.IsResourceExpired(err) {
+		t.Errorf("expected Expired for a watch before the snapshot, got %v", err)
+	}
+}
+
+// recordingStorage records the objects created through it.
+type recordingStorage struct {
+	Storage
+
+	m       sync.Mutex
+	created []string
+}
+
+func (s *recordingStorage) Create(obj *unstructured.Unstructured) error {
+	s.m.Lock()
+	s.created = append(s.created, obj.GetNamespace()+"/"+obj.GetName())
+	s.m.Unlock()
+	return s.Storage.Create(obj)
+}
+
+func TestCustomStorage(t *testing.T) {
+	var m sync.Mutex
+	stores := map[string]*recordingStorage{}
+	opts := NewOptions(false)
+	opts.NewStorage = func(config StorageConfig) Storage {
+		s := &recordingStorage{Storage: NewMemoryStorage(config)}
+		m.Lock()
+		stores[config.GVR.Resource] = s
+		m.Unlock()
+		return s
+	}
+	_, cfg := newTestServer(t, opts)
+	if err := resources.InitCluster(cfg); err != nil {
+		t.Fatal(err)
+	}
+	kc := kubernetes.NewForConfigOrDie(cfg)
+	createConfigMap(t, kc, "a")
+
+	m.Lock()
+	store := stores["configmaps"]
+	m.Unlock()
+	if store == nil {
+		t.Fatal("expected the config maps to be kept in the custom storage")
+	}
+	store.m.Lock()
+	created := slices.Clone(store.created)
+	store.m.Unlock()
+	if !slices.Contains(created, "default/a") {
+		t.Errorf("expected default/a to be created in the custom storage, got %v", created)
+	}
+	cm, err := kc.CoreV1().ConfigMaps(metav1.NamespaceDefault).Get(context.TODO(), "a", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored, found := store.Get(types.NamespacedName{Namespace: metav1.NamespaceDefault, Name: "a"}); !found || stored.GetResourceVersion() != cm.ResourceVersion {
+		t.Errorf("expected the custom storage to serve a, got %v", stored)
+	}
+}
@@ -0,0 +1,82 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"fmt"
+
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// prepareForCreate sets the metadata fields managed by the server for a new object.
+func prepareForCreate(obj *unstructured.Unstructured) {
+	obj.SetUID(uuid.NewUUID())
+	obj.SetCreationTimestamp(metav1.Now())
+	obj.SetGeneration(1)
+	obj.SetDeletionTimestamp(nil)
+	obj.SetDeletionGracePeriodSeconds(nil)
+}
+
+// prepareForUpdate copies the metadata fields managed by the server from liveObj to obj and
+// increments the generation if the object changed beyond its metadata and, with a status
+// subresource, its status.
+func prepareForUpdate(gr schema.GroupResource, obj, liveObj *unstructured.Unstructured, hasStatus bool) error {
+	if uid := obj.GetUID(); uid != "" && uid != liveObj.GetUID() {
+		return apierrors.NewInvalid(obj.GroupVersionKind().GroupKind(), obj.GetName(), field.ErrorList{
+			field.Invalid(field.NewPath("metadata", "uid"), uid, "field is immutable"),
+		})
+	}
+	obj.SetUID(liveObj.GetUID())
+	obj.SetCreationTimestamp(liveObj.GetCreationTimestamp())
+
+	// deletionTimestamp can only be set by a delete request
+	obj.SetDeletionTimestamp(liveObj.GetDeletionTimestamp())
+	obj.SetDeletionGracePeriodSeconds(liveObj.GetDeletionGracePeriodSeconds())
+	if liveObj.GetDeletionTimestamp() != nil {
+		oldFinalizers := sets.New(liveObj.GetFinalizers()...)
+		if added := sets.New(obj.GetFinalizers()...).Difference(oldFinalizers); added.Len() > 0 {
+			return apierrors.NewForbidden(gr, obj.GetName(),
+				fmt.Errorf("no new finalizers can be added if the object is being deleted, found new finalizers %q", sets.List(added)))
+		}
+	}
+
+	generation := liveObj.GetGeneration()
+	if !apiequality.Semantic.DeepEqual(generationFields(obj, hasStatus), generationFields(liveObj, hasStatus)) {
+		generation++
+	}
+	obj.SetGeneration(generation)
+	return nil
+}
+
+// generationFields returns the top level fields of obj whose changes increment the generation.
+func generationFields(obj *unstructured.Unstructured, hasStatus bool) map[string]any {
+	fields := make(map[string]any, len(obj.Object))
+	for k, v := range obj.Object {
+		if k == "metadata" || (hasStatus && k == "status") {
+			continue
+		}
+		fields[k] = v
+	}
+	return fields
+}
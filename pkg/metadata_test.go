@@ -0,0 +1,104 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	apps "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+)
+
+func TestServerManagedMetadata(t *testing.T) {
+	_, _, kc, _ := newTestCluster(t)
+	ctx := context.TODO()
+	deployments := kc.AppsV1().Deployments(metav1.NamespaceDefault)
+
+	// uid and creationTimestamp set by a client are ignored on create
+	d := testDeployment("web", 1)
+	d.UID = "client-uid"
+	d.CreationTimestamp = metav1.NewTime(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC))
+	d, err := deployments.Create(ctx, d, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.UID == "" || d.UID == "client-uid" {
+		t.Errorf("expected a uid assigned by the server, got %q", d.UID)
+	}
+	if d.CreationTimestamp.Year() == 2000 || d.CreationTimestamp.IsZero() {
+		t.Errorf("expected a creationTimestamp set by the server, got %v", d.CreationTimestamp)
+	}
+	if d.Generation != 1 {
+		t.Errorf("expected generation 1 on create, got %d", d.Generation)
+	}
+	uid, created := d.UID, d.CreationTimestamp
+
+	update := func(d *apps.Deployment, change func(d *apps.Deployment)) *apps.Deployment {
+		t.Helper()
+		change(d)
+		d, err := deployments.Update(ctx, d, metav1.UpdateOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d.UID != uid || !d.CreationTimestamp.Equal(&created) {
+			t.Errorf("expected uid and creationTimestamp to be kept, got %q and %v", d.UID, d.CreationTimestamp)
+		}
+		return d
+	}
+
+	d = update(d, func(d *apps.Deployment) {
+		d.Labels = map[string]string{"tier": "frontend"}
+	})
+	if d.Generation != 1 {
+		t.Errorf("expected a metadata change to keep the generation, got %d", d.Generation)
+	}
+
+	d = update(d, func(d *apps.Deployment) {
+		d.Spec.Replicas = ptr.To[int32](2)
+	})
+	if d.Generation != 2 {
+		t.Errorf("expected a spec change to increment the generation, got %d", d.Generation)
+	}
+
+	// uid and creationTimestamp may be omitted on update
+	d = update(d, func(d *apps.Deployment) {
+		d.UID = ""
+		d.CreationTimestamp = metav1.Time{}
+	})
+	if d.Generation != 2 {
+		t.Errorf("expected an update without changes to keep the generation, got %d", d.Generation)
+	}
+
+	d.Status.Replicas = 2
+	d, err = deployments.UpdateStatus(ctx, d, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Generation != 2 || d.Status.Replicas != 2 {
+		t.Errorf("expected a status change to keep the generation, got %d", d.Generation)
+	}
+
+	d.UID = types.UID("other-uid")
+	if _, err := deployments.Update(ctx, d, metav1.UpdateOptions{}); !apierrors.IsInvalid(err) {
+		t.Errorf("expected Invalid when changing the uid, got %v", err)
+	}
+}
@@ -0,0 +1,146 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+
+	"github.com/go-chi/chi/v5"
+	core "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// immortalNamespaces can not be deleted, like in the NamespaceLifecycle admission plugin.
+var immortalNamespaces = sets.New[string](metav1.NamespaceDefault, metav1.NamespaceSystem, metav1.NamespacePublic)
+
+func (s *Server) namespaceStore() *APIStorage {
+	return s.builtinStore(core.SchemeGroupVersion.WithResource("namespaces"))
+}
+
+// admitCreate rejects objects created in a missing or terminating namespace,
+// like the NamespaceLifecycle admission plugin, and custom resources of a terminating CRD.
+func (s *Server) admitCreate(store *APIStorage, ns string) error {
+	if err := s.admitCustomResourceCreate(store); err != nil {
+		return err
+	}
+	if !store.Namespaced {
+		return nil
+	}
+	obj, found, err := s.namespaceStore().Get(types.NamespacedName{Name: ns})
+	if err != nil {
+		return err
+	}
+	if !found {
+		return apierrors.NewNotFound(core.Resource("namespaces"), ns)
+	}
+	if obj.GetDeletionTimestamp() != nil {
+		err := apierrors.NewForbidden(store.GVR.GroupResource(), "",
+			fmt.Errorf("unable to create new content in namespace %s because it is being terminated", ns))
+		err.ErrStatus.Details.Causes = append(err.ErrStatus.Details.Causes, metav1.StatusCause{
+			Type:    core.NamespaceTerminatingCause,
+			Message: fmt.Sprintf("namespace %s is being terminated", ns),
+			Field:   "metadata.namespace",
+		})
+		return err
+	}
+	return nil
+}
+
+// admitDelete rejects the deletion of immortal namespaces.
+func (s *Server) admitDelete(store *APIStorage, name string) error {
+	if store.GVK == nsGVK && immortalNamespaces.Has(name) {
+		return apierrors.NewForbidden(store.GVR.GroupResource(), name, fmt.Errorf("this namespace may not be deleted"))
+	}
+	return nil
+}
+
+func (s *Server) FinalizeNamespace(w http.ResponseWriter, r *http.Request) {
+	store := s.namespaceStore()
+	codec, err := s.codec(w, r)
+	if err != nil {
+		writeStatus(w, s.encoder(w, r), err)
+		return
+	}
+
+	obj, err := s.FinalizeNamespaceImpl(store, codec, r)
+	if err != nil {
+		writeStatus(w, codec, err)
+		return
+	}
+	_ = codec.Encode(obj, w)
+}
+
+// FinalizeNamespaceImpl updates the spec.finalizers of a namespace. A terminating namespace is
+// removed once its finalizers are empty.
+func (s *Server) FinalizeNamespaceImpl(store *APIStorage, codec runtime.Codec, r *http.Request) (runtime.Object, error) {
+	defer r.Body.Close() // nolint:errcheck
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	obj, err := s.decodeObject(store, codec, data)
+	if err != nil {
+		return nil, err
+	}
+
+	key := types.NamespacedName{Name: chi.URLParam(r, "namespace")}
+	liveObj, exists, err := store.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, apierrors.NewNotFound(store.GVR.GroupResource(), key.Name)
+	}
+	result := liveObj.DeepCopy()
+	setSpecFinalizers(result, specFinalizers(obj))
+	result.SetResourceVersion(obj.GetResourceVersion())
+
+	if err := store.Update(result, false); err != nil {
+		return nil, err
+	}
+	s.CollectGarbage()
+	return result, nil
+}
+
+func specFinalizers(obj *unstructured.Unstructured) []string {
+	finalizers, _, _ := unstructured.NestedStringSlice(obj.Object, "spec", "finalizers")
+	return finalizers
+}
+
+func setSpecFinalizers(obj *unstructured.Unstructured, finalizers []string) {
+	if len(finalizers) == 0 {
+		unstructured.RemoveNestedField(obj.Object, "spec", "finalizers")
+		return
+	}
+	_ = unstructured.SetNestedStringSlice(obj.Object, finalizers, "spec", "finalizers")
+}
+
+// removeSpecFinalizer removes finalizer from the spec.finalizers of namespace obj.
+func removeSpecFinalizer(obj *unstructured.Unstructured, finalizer string) {
+	setSpecFinalizers(obj, slices.DeleteFunc(specFinalizers(obj), func(f string) bool {
+		return f == finalizer
+	}))
+}
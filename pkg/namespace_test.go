@@ -0,0 +1,147 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	core "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestNamespaceLifecycle(t *testing.T) {
+	s, _, kc, _ := newTestCluster(t)
+	ctx := context.TODO()
+	namespaces := kc.CoreV1().Namespaces()
+	cms := kc.CoreV1().ConfigMaps("team")
+
+	ns, err := namespaces.Create(ctx, &core.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team"}}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ns.Status.Phase != core.NamespaceActive || !slices.Equal(ns.Spec.Finalizers, []core.FinalizerName{core.FinalizerKubernetes}) {
+		t.Errorf("expected an active namespace with the kubernetes finalizer, got %v and %v", ns.Status.Phase, ns.Spec.Finalizers)
+	}
+	if _, err := cms.Get(ctx, "kube-root-ca.crt", metav1.GetOptions{}); err != nil {
+		t.Errorf("expected the kube-root-ca.crt config map in a new namespace, got %v", err)
+	}
+
+	if _, err := kc.CoreV1().ConfigMaps("missing").Create(ctx, &core.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "a"}}, metav1.CreateOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("expected NotFound when creating in a missing namespace, got %v", err)
+	}
+
+	if _, err := cms.Create(ctx, &core.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "a"}}, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	held, err := cms.Create(ctx, &core.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "held", Finalizers: []string{"example.com/hold"}}}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := namespaces.Delete(ctx, "team", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	ns, err = namespaces.Get(ctx, "team", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected the namespace to be kept while it has content, got %v", err)
+	}
+	if ns.Status.Phase != core.NamespaceTerminating || ns.DeletionTimestamp == nil {
+		t.Errorf("expected a terminating namespace, got %v", ns.Status.Phase)
+	}
+	if _, err := cms.Get(ctx, "a", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("expected the content of the namespace to be removed, got %v", err)
+	}
+	if held, err = cms.Get(ctx, "held", metav1.GetOptions{}); err != nil || held.DeletionTimestamp == nil {
+		t.Errorf("expected the content with finalizers to be marked for deletion, got %v", err)
+	}
+
+	_, err = cms.Create(ctx, &core.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "b"}}, metav1.CreateOptions{})
+	if !apierrors.IsForbidden(err) || !apierrors.HasStatusCause(err, core.NamespaceTerminatingCause) {
+		t.Errorf("expected Forbidden when creating in a terminating namespace, got %v", err)
+	}
+
+	_, deleted := s.Export()
+	for _, name := range []string{"a", "kube-root-ca.crt"} {
+		if !slices.ContainsFunc(deleted, func(obj unstructured.Unstructured) bool {
+			return obj.GetNamespace() == "team" && obj.GetName() == name
+		}) {
+			t.Errorf("expected the removed content %s to be exported as deleted", name)
+		}
+	}
+
+	held.Finalizers = nil
+	if _, err := cms.Update(ctx, held, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := namespaces.Get(ctx, "team", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("expected the namespace to be removed once it is empty, got %v", err)
+	}
+
+	if err := namespaces.Delete(ctx, metav1.NamespaceDefault, metav1.DeleteOptions{}); !apierrors.IsForbidden(err) {
+		t.Errorf("expected Forbidden when deleting the default namespace, got %v", err)
+	}
+}
+
+func TestFinalizeNamespace(t *testing.T) {
+	_, _, kc, _ := newTestCluster(t)
+	ctx := context.TODO()
+	namespaces := kc.CoreV1().Namespaces()
+
+	ns, err := namespaces.Create(ctx, &core.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team"}}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// spec.finalizers can only be changed through the finalize subresource
+	ns.Spec.Finalizers = nil
+	if ns, err = namespaces.Update(ctx, ns, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(ns.Spec.Finalizers, []core.FinalizerName{core.FinalizerKubernetes}) {
+		t.Errorf("expected an update to keep spec.finalizers, got %v", ns.Spec.Finalizers)
+	}
+	ns.Spec.Finalizers = append(ns.Spec.Finalizers, "example.com/ns")
+	if ns, err = namespaces.Finalize(ctx, ns, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := namespaces.Delete(ctx, "team", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	ns, err = namespaces.Get(ctx, "team", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected the namespace to be kept by its finalizer, got %v", err)
+	}
+	if !slices.Equal(ns.Spec.Finalizers, []core.FinalizerName{"example.com/ns"}) {
+		t.Errorf("expected the kubernetes finalizer of the empty namespace to be removed, got %v", ns.Spec.Finalizers)
+	}
+
+	ns.Spec.Finalizers = nil
+	if _, err := namespaces.Finalize(ctx, ns, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := namespaces.Get(ctx, "team", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("expected the namespace to be removed once finalized, got %v", err)
+	}
+	if _, err := namespaces.Finalize(ctx, &core.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "missing"}}, metav1.UpdateOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("expected NotFound when finalizing a missing namespace, got %v", err)
+	}
+}
@@ -0,0 +1,478 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"kmodules.xyz/fake-apiserver/pkg/resources"
+
+	kmapi "kmodules.xyz/client-go/api/v1"
+
+	"github.com/go-chi/chi/v5"
+	openapi_v2 "github.com/google/gnostic-models/openapiv2"
+	"google.golang.org/protobuf/proto"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	structuralschema "k8s.io/apiextensions-apiserver/pkg/apiserver/schema"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/kube-openapi/pkg/handler3"
+	"k8s.io/kube-openapi/pkg/spec3"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+)
+
+const (
+	definitionsPrefix = "#/definitions/"
+	componentsPrefix  = "#/components/schemas/"
+
+	objectMetaDefinition = "io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta"
+	listMetaDefinition   = "io.k8s.apimachinery.pkg.apis.meta.v1.ListMeta"
+
+	gvkExtension = "x-kubernetes-group-version-kind"
+
+	mimeTypeOpenAPIV2Proto = "application/com.github.proto-openapi.spec.v2.v1.0+protobuf"
+)
+
+// openAPICache holds the OpenAPI documents of the server. They are rebuilt whenever the set of
+// CustomResourceDefinitions changes.
+type openAPICache struct {
+	m    sync.Mutex
+	key  string
+	docs *openAPIDocs
+	// builtin is the OpenAPI v2 document of the built-in types.
+	builtin *spec.Swagger
+	// builtinV3 are the built-in definitions with their references rewritten for OpenAPI v3.
+	builtinV3 map[string]*spec.Schema
+}
+
+type openAPIDocs struct {
+	v2JSON  []byte
+	v2Proto []byte
+	v3      *handler3.OpenAPIService
+	v3Paths sets.Set[string]
+}
+
+func (s *Server) OpenAPIV2(w http.ResponseWriter, r *http.Request) {
+	docs, err := s.openAPIDocs()
+	if err != nil {
+		writeStatus(w, s.encoder(w, r), apierrors.NewInternalError(err))
+		return
+	}
+	accept := r.Header.Get("Accept")
+	if strings.Contains(accept, "com.github.proto-openapi.spec.v2") {
+		w.Header().Set("Content-Type", mimeTypeOpenAPIV2Proto)
+		_, _ = w.Write(docs.v2Proto)
+		return
+	}
+	w.Header().Set("Content-Type", runtime.ContentTypeJSON)
+	_, _ = w.Write(docs.v2JSON)
+}
+
+func (s *Server) OpenAPIV3Discovery(w http.ResponseWriter, r *http.Request) {
+	docs, err := s.openAPIDocs()
+	if err != nil {
+		writeStatus(w, s.encoder(w, r), apierrors.NewInternalError(err))
+		return
+	}
+	docs.v3.HandleDiscovery(w, r)
+}
+
+func (s *Server) OpenAPIV3GroupVersion(w http.ResponseWriter, r *http.Request) {
+	docs, err := s.openAPIDocs()
+	if err != nil {
+		writeStatus(w, s.encoder(w, r), apierrors.NewInternalError(err))
+		return
+	}
+	path := chi.URLParam(r, "*")
+	if !docs.v3Paths.Has(path) {
+		writeStatus(w, s.encoder(w, r), apierrors.NewNotFound(schema.GroupResource{}, "/openapi/v3/"+path))
+		return
+	}
+	docs.v3.HandleGroupVersion(w, r)
+}
+
+// openAPIDocs returns the OpenAPI documents for the built-in types and the current CustomResourceDefinitions.
+func (s *Server) openAPIDocs() (*openAPIDocs, error) {
+	crdStore := s.builtinStore(apiextensionsv1.SchemeGroupVersion.WithResource("customresourcedefinitions"))
+	items, _, err := crdStore.List(0)
+	if err != nil {
+		return nil, err
+	}
+	var key strings.Builder
+	crds := make([]*apiextensionsv1.CustomResourceDefinition, 0, len(items))
+	for _, item := range items {
+		key.WriteString(item.GetName() + "@" + item.GetResourceVersion() + ";")
+		var crd apiextensionsv1.CustomResourceDefinition
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(item.UnstructuredContent(), &crd); err != nil {
+			return nil, err
+		}
+		crds = append(crds, &crd)
+	}
+
+	c := &s.openapi
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.docs != nil && c.key == key.String() {
+		return c.docs, nil
+	}
+	docs, err := s.buildOpenAPIDocs(crds)
+	if err != nil {
+		return nil, err
+	}
+	c.key = key.String()
+	c.docs = docs
+	return docs, nil
+}
+
+// builtinOpenAPIV3Definitions returns the definitions of the built-in types for OpenAPI v3.
+// They must not be changed.
+func (s *Server) builtinOpenAPIV3Definitions() (map[string]*spec.Schema, error) {
+	s.openapi.m.Lock()
+	defer s.openapi.m.Unlock()
+
+	if err := s.buildBuiltinOpenAPI(); err != nil {
+		return nil, err
+	}
+	return s.openapi.builtinV3, nil
+}
+
+// buildBuiltinOpenAPI must be called with the openapi cache lock held.
+func (s *Server) buildBuiltinOpenAPI() error {
+	if s.openapi.builtin == nil {
+		builtin, err := resources.BuiltinOpenAPISwagger(s.opts.Scheme)
+		if err != nil {
+			return err
+		}
+		s.openapi.builtin = builtin
+	}
+	if s.openapi.builtinV3 == nil {
+		defs := make(map[string]*spec.Schema, len(s.openapi.builtin.Definitions))
+		for name, def := range s.openapi.builtin.Definitions {
+			v3, err := toOpenAPIV3Schema(def)
+			if err != nil {
+				return err
+			}
+			defs[name] = v3
+		}
+		s.openapi.builtinV3 = defs
+	}
+	return nil
+}
+
+// buildOpenAPIDocs must be called with the openapi cache lock held.
+func (s *Server) buildOpenAPIDocs(crds []*apiextensionsv1.CustomResourceDefinition) (*openAPIDocs, error) {
+	if err := s.buildBuiltinOpenAPI(); err != nil {
+		return nil, err
+	}
+	builtin := s.openapi.builtin
+
+	v2Defs := make(spec.Definitions, len(builtin.Definitions))
+	for name, def := range builtin.Definitions {
+		v2Defs[name] = def
+	}
+	v3Defs := make(map[string]*spec.Schema, len(s.openapi.builtinV3))
+	for name, def := range s.openapi.builtinV3 {
+		v3Defs[name] = def
+	}
+	for _, crd := range crds {
+		for _, v := range crd.Spec.Versions {
+			if !v.Served {
+				continue
+			}
+			defs, err := crdDefinitions(crd, &v, nil)
+			if err != nil {
+				return nil, err
+			}
+			for name, def := range defs {
+				v3, err := toOpenAPIV3Schema(def)
+				if err != nil {
+					return nil, err
+				}
+				v3Defs[name] = v3
+				v2Defs[name] = toOpenAPIV2Schema(def)
+			}
+		}
+	}
+
+	swagger := *builtin
+	swagger.Definitions = v2Defs
+	v2JSON, err := json.Marshal(&swagger)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := openapi_v2.ParseDocument(v2JSON)
+	if err != nil {
+		return nil, err
+	}
+	v2Proto, err := proto.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	// every served group version gets a document with the definitions of its kinds
+	// and everything they refer to
+	served := sets.New[schema.GroupVersion]()
+	s.visitResources(func(rid kmapi.ResourceID) {
+		served.Insert(rid.GroupVersion())
+	})
+	roots := map[schema.GroupVersion][]string{}
+	for name, def := range v3Defs {
+		for _, gvk := range definitionGVKs(def) {
+			if served.Has(gvk.GroupVersion()) {
+				roots[gvk.GroupVersion()] = append(roots[gvk.GroupVersion()], name)
+			}
+		}
+	}
+
+	v3 := handler3.NewOpenAPIService()
+	v3Paths := sets.New[string]()
+	for gv, names := range roots {
+		path := "apis/" + gv.String()
+		if gv.Group == "" {
+			path = "api/" + gv.Version
+		}
+		v3.UpdateGroupVersion(path, &spec3.OpenAPI{
+			Version: "3.0.0",
+			Info: &spec.Info{
+				InfoProps: spec.InfoProps{
+					Title:   "Kubernetes",
+					Version: builtin.Info.Version,
+				},
+			},
+			Components: &spec3.Components{
+				Schemas: referencedDefinitions(v3Defs, names),
+			},
+		})
+		v3Paths.Insert(path)
+	}
+
+	return &openAPIDocs{
+		v2JSON:  v2JSON,
+		v2Proto: v2Proto,
+		v3:      v3,
+		v3Paths: v3Paths,
+	}, nil
+}
+
+// crdDefinitions returns the OpenAPI v2 definitions of the kind and list kind of a CRD version,
+// named like the apiextensions-apiserver does. The kind is defined by structural if it is set,
+// and by the openAPIV3Schema of the version otherwise.
+func crdDefinitions(crd *apiextensionsv1.CustomResourceDefinition, v *apiextensionsv1.CustomResourceDefinitionVersion, structural *structuralschema.Structural) (map[string]spec.Schema, error) {
+	var def spec.Schema
+	if structural != nil {
+		def = *structural.ToKubeOpenAPI()
+	} else if v.Schema != nil && v.Schema.OpenAPIV3Schema != nil {
+		data, err := json.Marshal(v.Schema.OpenAPIV3Schema)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &def); err != nil {
+			return nil, err
+		}
+	} else {
+		def.Type = spec.StringOrArray{"object"}
+		def.AddExtension("x-kubernetes-preserve-unknown-fields", true)
+	}
+
+	apiVersion := spec.Schema{SchemaProps: spec.SchemaProps{
+		Type:        spec.StringOrArray{"string"},
+		Description: "APIVersion defines the versioned schema of this representation of an object. Servers should convert recognized schemas to the latest internal value, and may reject unrecognized values. More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#resources",
+	}}
+	kind := spec.Schema{SchemaProps: spec.SchemaProps{
+		Type:        spec.StringOrArray{"string"},
+		Description: "Kind is a string value representing the REST resource this object represents. Servers may infer this from the endpoint the client submits requests to. Cannot be updated. In CamelCase. More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#types-kinds",
+	}}
+
+	def.SetProperty("apiVersion", apiVersion)
+	def.SetProperty("kind", kind)
+	def.SetProperty("metadata", spec.Schema{SchemaProps: spec.SchemaProps{
+		Description: "Standard object's metadata. More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#metadata",
+		Ref:         spec.MustCreateRef(definitionsPrefix + objectMetaDefinition),
+	}})
+	def.AddExtension(gvkExtension, []any{map[string]any{
+		"group":   crd.Spec.Group,
+		"version": v.Name,
+		"kind":    crd.Spec.Names.Kind,
+	}})
+
+	name := crdDefinitionName(crd.Spec.Group, v.Name, crd.Spec.Names.Kind)
+	listKind := crd.Spec.Names.ListKind
+	if listKind == "" {
+		listKind = crd.Spec.Names.Kind + "List"
+	}
+	list := spec.Schema{SchemaProps: spec.SchemaProps{
+		Description: fmt.Sprintf("%s is a list of %s", listKind, crd.Spec.Names.Kind),
+		Type:        spec.StringOrArray{"object"},
+		Required:    []string{"items"},
+		Properties: map[string]spec.Schema{
+			"apiVersion": apiVersion,
+			"kind":       kind,
+			"items": {SchemaProps: spec.SchemaProps{
+				Description: fmt.Sprintf("List of %s. More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md", crd.Spec.Names.Plural),
+				Type:        spec.StringOrArray{"array"},
+				Items: &spec.SchemaOrArray{Schema: &spec.Schema{SchemaProps: spec.SchemaProps{
+					Ref: spec.MustCreateRef(definitionsPrefix + name),
+				}}},
+			}},
+			"metadata": {SchemaProps: spec.SchemaProps{
+				Description: "Standard list metadata. More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#types-kinds",
+				Ref:         spec.MustCreateRef(definitionsPrefix + listMetaDefinition),
+			}},
+		},
+	}}
+	list.AddExtension(gvkExtension, []any{map[string]any{
+		"group":   crd.Spec.Group,
+		"version": v.Name,
+		"kind":    listKind,
+	}})
+
+	return map[string]spec.Schema{
+		name: def,
+		crdDefinitionName(crd.Spec.Group, v.Name, listKind): list,
+	}, nil
+}
+
+// crdDefinitionName returns the definition name of a custom kind, e.g. dev.x-helm.drivers.v1alpha1.AppRelease.
+func crdDefinitionName(group, version, kind string) string {
+	parts := strings.Split(group, ".")
+	for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+		parts[i], parts[j] = parts[j], parts[i]
+	}
+	return strings.Join(parts, ".") + "." + version + "." + kind
+}
+
+// definitionGVKs returns the kinds listed in the x-kubernetes-group-version-kind extension of def.
+func definitionGVKs(def *spec.Schema) []schema.GroupVersionKind {
+	ext, found := def.Extensions[gvkExtension]
+	if !found {
+		return nil
+	}
+	data, err := json.Marshal(ext)
+	if err != nil {
+		return nil
+	}
+	var gvks []schema.GroupVersionKind
+	if err := json.Unmarshal(data, &gvks); err != nil {
+		return nil
+	}
+	return gvks
+}
+
+// referencedDefinitions returns the definitions named by roots and every definition they refer to.
+func referencedDefinitions(defs map[string]*spec.Schema, roots []string) map[string]*spec.Schema {
+	result := map[string]*spec.Schema{}
+	queue := append([]string(nil), roots...)
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		if _, found := result[name]; found {
+			continue
+		}
+		def, found := defs[name]
+		if !found {
+			continue
+		}
+		result[name] = def
+		walkSchema(def, func(s *spec.Schema) {
+			if ref := s.Ref.String(); strings.HasPrefix(ref, componentsPrefix) {
+				queue = append(queue, strings.TrimPrefix(ref, componentsPrefix))
+			}
+		})
+	}
+	return result
+}
+
+// toOpenAPIV3Schema returns a copy of the OpenAPI v2 schema def that refers to other schemas as components.
+func toOpenAPIV3Schema(def spec.Schema) (*spec.Schema, error) {
+	data, err := json.Marshal(def)
+	if err != nil {
+		return nil, err
+	}
+	var out spec.Schema
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, err
+	}
+	walkSchema(&out, func(s *spec.Schema) {
+		if ref := s.Ref.String(); strings.HasPrefix(ref, definitionsPrefix) {
+			s.Ref = spec.MustCreateRef(componentsPrefix + strings.TrimPrefix(ref, definitionsPrefix))
+		}
+	})
+	return &out, nil
+}
+
+// toOpenAPIV2Schema drops the parts of a structural schema that can not be expressed in OpenAPI v2.
+func toOpenAPIV2Schema(def spec.Schema) spec.Schema {
+	walkSchema(&def, func(s *spec.Schema) {
+		s.Nullable = false
+		s.OneOf = nil
+		s.AnyOf = nil
+		s.Not = nil
+	})
+	return def
+}
+
+// walkSchema calls fn for s and every schema nested in it.
+func walkSchema(s *spec.Schema, fn func(*spec.Schema)) {
+	fn(s)
+
+	walkSlice := func(schemas []spec.Schema) {
+		for i := range schemas {
+			walkSchema(&schemas[i], fn)
+		}
+	}
+	walkMap := func(schemas map[string]spec.Schema) {
+		for k, v := range schemas {
+			walkSchema(&v, fn)
+			schemas[k] = v
+		}
+	}
+
+	if s.Items != nil {
+		if s.Items.Schema != nil {
+			walkSchema(s.Items.Schema, fn)
+		}
+		walkSlice(s.Items.Schemas)
+	}
+	walkSlice(s.AllOf)
+	walkSlice(s.OneOf)
+	walkSlice(s.AnyOf)
+	if s.Not != nil {
+		walkSchema(s.Not, fn)
+	}
+	walkMap(s.Properties)
+	walkMap(s.PatternProperties)
+	walkMap(s.Definitions)
+	if s.AdditionalProperties != nil && s.AdditionalProperties.Schema != nil {
+		walkSchema(s.AdditionalProperties.Schema, fn)
+	}
+	if s.AdditionalItems != nil && s.AdditionalItems.Schema != nil {
+		walkSchema(s.AdditionalItems.Schema, fn)
+	}
+	for k, v := range s.Dependencies {
+		if v.Schema != nil {
+			walkSchema(v.Schema, fn)
+			s.Dependencies[k] = v
+		}
+	}
+}
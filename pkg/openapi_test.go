@@ -0,0 +1,81 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/managedfields"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/openapi3"
+	"k8s.io/kube-openapi/pkg/util/proto"
+)
+
+func TestOpenAPIV2(t *testing.T) {
+	_, cfg := newTestServer(t, nil)
+	dc := discovery.NewDiscoveryClientForConfigOrDie(cfg)
+
+	doc, err := dc.OpenAPISchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	models, err := proto.NewOpenAPIData(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := managedfields.NewGVKParser(models, false); err != nil {
+		t.Fatalf("failed to build a type parser from the served document: %v", err)
+	}
+
+	for name, fields := range map[string][]string{
+		"io.k8s.api.autoscaling.v2.HorizontalPodAutoscaler": {"spec", "status"},
+		"io.k8s.api.core.v1.PodSpec":                        {"hostUsers", "schedulingGates"},
+		"io.k8s.api.core.v1.Container":                      {"resizePolicy"},
+	} {
+		kind, ok := models.LookupModel(name).(*proto.Kind)
+		if !ok {
+			t.Errorf("missing definition %s", name)
+			continue
+		}
+		for _, f := range fields {
+			if _, found := kind.Fields[f]; !found {
+				t.Errorf("missing field %s of %s", f, name)
+			}
+		}
+	}
+
+	gates := models.LookupModel("io.k8s.api.core.v1.PodSpec").(*proto.Kind).Fields["schedulingGates"]
+	ext := gates.GetExtensions()
+	if ext["x-kubernetes-list-type"] != "map" {
+		t.Errorf("expected schedulingGates to be a map list, got extensions %v", ext)
+	}
+}
+
+func TestOpenAPIV3(t *testing.T) {
+	_, cfg := newTestServer(t, nil)
+	dc := discovery.NewDiscoveryClientForConfigOrDie(cfg)
+
+	root := openapi3.NewRoot(dc.OpenAPIV3())
+	doc, err := root.GVSpec(schema.GroupVersion{Group: "autoscaling", Version: "v2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found := doc.Components.Schemas["io.k8s.api.autoscaling.v2.HorizontalPodAutoscaler"]; !found {
+		t.Error("missing io.k8s.api.autoscaling.v2.HorizontalPodAutoscaler in /openapi/v3/apis/autoscaling/v2")
+	}
+}
@@ -17,15 +17,16 @@ limitations under the License.
 package pkg
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 
 	"kmodules.xyz/fake-apiserver/pkg/resources"
 
 	jsonpatch "github.com/evanphx/json-patch"
 	"github.com/go-chi/chi/v5"
-	httpw "go.wandrs.dev/http"
 	core "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -39,13 +40,27 @@ import (
 	kjson "sigs.k8s.io/json"
 )
 
+// maxRetryWhenPatchConflicts is the maximum number of conflicts retry during a patch operation before returning failure
+const maxRetryWhenPatchConflicts = 5
+
 func (s *Server) Patch(w http.ResponseWriter, r *http.Request) {
-	store := s.Store(r)
-	codec := s.codec(w, r)
+	store, err := s.Store(r)
+	if err != nil {
+		writeStatus(w, s.encoder(w, r), err)
+		return
+	}
+	codec, err := s.codec(w, r)
+	if err != nil {
+		writeStatus(w, s.encoder(w, r), err)
+		return
+	}
 
 	obj, err := s.PatchImpl(store, codec, r)
+	if err == nil {
+		obj, err = s.transformObject(store, r, obj)
+	}
 	if err != nil {
-		_ = codec.Encode(httpw.ErrorToAPIStatus(err), w)
+		writeStatus(w, codec, err)
 		return
 	}
 	_ = codec.Encode(obj, w)
@@ -53,6 +68,10 @@ func (s *Server) Patch(w http.ResponseWriter, r *http.Request) {
 
 // https://github.com/kubernetes/kubernetes/blob/21f7bf66fa949dda2b3bec6e3581e248e270e001/staging/src/k8s.io/apiserver/pkg/endpoints/handlers/patch.go#L369
 func (s *Server) PatchImpl(store *APIStorage, codec runtime.Codec, r *http.Request) (runtime.Object, error) {
+	return s.patchImpl(store, codec, r, "")
+}
+
+func (s *Server) patchImpl(store *APIStorage, codec runtime.Codec, r *http.Request, subresource string) (runtime.Object, error) {
 	var opts metav1.PatchOptions
 	err := s.opts.ParameterCodec.DecodeParameters(r.URL.Query(), metav1.SchemeGroupVersion, &opts)
 	if err != nil {
@@ -69,41 +88,116 @@ func (s *Server) PatchImpl(store *APIStorage, codec runtime.Codec, r *http.Reque
 		Namespace: chi.URLParam(r, "namespace"),
 		Name:      chi.URLParam(r, "name"),
 	}
-	currentObject, exists := store.Get(key)
-	if !exists {
-		return nil, apierrors.NewNotFound(store.GVR.GroupResource(), key.String())
-	}
-	// Encode will convert & return a versioned object in JSON.
-	currentObjJS, err := runtime.Encode(codec, currentObject)
-	if err != nil {
-		return nil, err
-	}
-
-	var objToUpdate unstructured.Unstructured
-
 	patchType := types.PatchType(r.Header.Get("Content-Type"))
-	err = s.applyJSPatch(codec, store.GVK, patchType, currentObject, &objToUpdate, currentObjJS, patchBytes, opts.FieldValidation)
-	if err != nil {
-		return nil, err
-	}
 
-	if store.Namespaced {
-		ns := chi.URLParam(r, "namespace")
-		objToUpdate.SetNamespace(ns)
-	} else {
-		objToUpdate.SetNamespace("")
+	if patchType != types.ApplyPatchType && opts.Force != nil {
+		return nil, apierrors.NewBadRequest("force is only valid for apply patches")
 	}
+	manager := fieldManagerName(r, opts.FieldManager)
+	hasStatus := s.hasStatusSubresource(store)
 
-	if store.GVK == core.SchemeGroupVersion.WithKind("Secret") {
-		err = resources.ProcessSecret(&objToUpdate)
+	for i := 0; ; i++ {
+		currentObject, exists, err := store.Get(key)
 		if err != nil {
 			return nil, err
 		}
-	}
+		if !exists {
+			if patchType != types.ApplyPatchType || subresource != "" {
+				return nil, apierrors.NewNotFound(store.GVR.GroupResource(), key.String())
+			}
+			// server-side apply creates missing objects
+			if err := s.admitCreate(store, key.Namespace); err != nil {
+				return nil, err
+			}
+			currentObject = &unstructured.Unstructured{}
+			currentObject.SetGroupVersionKind(store.GVK)
+			currentObject.SetNamespace(key.Namespace)
+			currentObject.SetName(key.Name)
+		}
 
-	store.Insert(&objToUpdate)
+		var objToUpdate *unstructured.Unstructured
+		if patchType == types.ApplyPatchType {
+			objToUpdate, err = s.applyPatch(store, currentObject, patchBytes, opts, subresource)
+			if err != nil {
+				return nil, err
+			}
+			objToUpdate = applyStatusStrategy(currentObject, objToUpdate, hasStatus, subresource)
+		} else {
+			// the patches are applied to the JSON of the current object, whatever the media types of the request
+			currentObjJS, err := runtime.Encode(unstructured.UnstructuredJSONScheme, currentObject)
+			if err != nil {
+				return nil, err
+			}
 
-	return &objToUpdate, nil
+			var patchedObj unstructured.Unstructured
+			err = s.applyJSPatch(codec, store.GVK, patchType, currentObject, &patchedObj, currentObjJS, patchBytes, opts.FieldValidation)
+			if err != nil {
+				if isResourceVersionTestFailure(err) {
+					return nil, apierrors.NewConflict(store.GVR.GroupResource(), key.Name, errors.New(OptimisticLockErrorMsg))
+				}
+				return nil, err
+			}
+			objToUpdate = applyStatusStrategy(currentObject, &patchedObj, hasStatus, subresource)
+			objToUpdate, err = s.updateManagedFields(store, currentObject, objToUpdate, manager, subresource)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if store.Namespaced {
+			ns := chi.URLParam(r, "namespace")
+			objToUpdate.SetNamespace(ns)
+		} else {
+			objToUpdate.SetNamespace("")
+		}
+
+		if store.GVK == core.SchemeGroupVersion.WithKind("Secret") {
+			err = resources.ProcessSecret(objToUpdate)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		var old *unstructured.Unstructured
+		if exists {
+			old = currentObject
+		}
+		if store.GVK.GroupKind() == crdGVK.GroupKind() && subresource == "" {
+			if err := s.prepareCRD(objToUpdate, old); err != nil {
+				return nil, err
+			}
+		}
+
+		if exists && store.GVK == nsGVK {
+			// spec.finalizers can only be changed through the finalize subresource
+			setSpecFinalizers(objToUpdate, specFinalizers(currentObject))
+		}
+		if err := s.applySchema(store, objToUpdate, old); err != nil {
+			return nil, err
+		}
+		if exists {
+			if err := prepareForUpdate(store.GVR.GroupResource(), objToUpdate, currentObject, hasStatus); err != nil {
+				return nil, err
+			}
+			err = store.Update(objToUpdate, false)
+		} else {
+			prepareForCreate(objToUpdate)
+			err = store.Create(objToUpdate)
+		}
+		// retry if the object was modified concurrently, unless the patch itself set a stale resourceVersion
+		if (apierrors.IsConflict(err) || apierrors.IsAlreadyExists(err) || apierrors.IsNotFound(err)) &&
+			objToUpdate.GetResourceVersion() == currentObject.GetResourceVersion() && i < maxRetryWhenPatchConflicts {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if objToUpdate.GetDeletionTimestamp() != nil {
+			// the object is removed if the patch cleared its finalizers
+			s.CollectGarbage()
+		}
+		return objToUpdate, nil
+	}
 }
 
 func (s *Server) applyJSPatch(codec runtime.Codec, gvk schema.GroupVersionKind, patchType types.PatchType, currentObject, objToUpdate *unstructured.Unstructured, currentObjJS, patchBytes []byte, validationDirective string) error {
@@ -114,7 +208,9 @@ func (s *Server) applyJSPatch(codec runtime.Codec, gvk schema.GroupVersionKind,
 			return apierrors.NewBadRequest(err.Error())
 		}
 		patchedJS, err := patchObj.Apply(currentObjJS)
-		if err != nil {
+		if isResourceVersionTestFailure(err) {
+			return err
+		} else if err != nil {
 			return apierrors.NewGenericServerResponse(http.StatusUnprocessableEntity, "", schema.GroupResource{}, "", err.Error(), 0, false)
 		}
 		_, _, err = codec.Decode(patchedJS, &gvk, objToUpdate)
@@ -134,7 +230,8 @@ func (s *Server) applyJSPatch(codec runtime.Codec, gvk schema.GroupVersionKind,
 			return err
 		}
 
-		originalObjMap := currentObject.UnstructuredContent()
+		// StrategicMergeMapPatch changes the original map, which belongs to the stored object
+		originalObjMap := currentObject.DeepCopy().UnstructuredContent()
 		patchMap := make(map[string]any)
 		var strictErrs []error
 		if validationDirective == metav1.FieldValidationWarn || validationDirective == metav1.FieldValidationStrict {
@@ -183,11 +280,29 @@ func (s *Server) applyJSPatch(codec runtime.Codec, gvk schema.GroupVersionKind,
 				})
 			}
 		}
-	case types.ApplyPatchType:
+	default:
+		return apierrors.NewGenericServerResponse(http.StatusUnsupportedMediaType, "", schema.GroupResource{}, "", fmt.Sprintf("unsupported patch type %q", patchType), 0, false)
 	}
 	return nil
 }
 
+// applyStatusStrategy keeps only the status changes of a status subresource write and drops the status
+// changes of a main resource write, if the resource has a status subresource.
+func applyStatusStrategy(currentObject, objToUpdate *unstructured.Unstructured, hasStatus bool, subresource string) *unstructured.Unstructured {
+	switch {
+	case subresource == "status":
+		return statusUpdate(currentObject, objToUpdate)
+	case hasStatus:
+		copyStatus(objToUpdate, currentObject)
+	}
+	return objToUpdate
+}
+
+// isResourceVersionTestFailure returns true if a JSON patch failed because its test operation on metadata.resourceVersion did not match.
+func isResourceVersionTestFailure(err error) bool {
+	return errors.Is(err, jsonpatch.ErrTestFailed) && strings.Contains(err.Error(), "/metadata/resourceVersion")
+}
+
 // interpretStrategicMergePatchError interprets the error type and returns an error with appropriate HTTP code.
 func interpretStrategicMergePatchError(err error) error {
 	switch err {
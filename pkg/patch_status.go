@@ -16,7 +16,40 @@ limitations under the License.
 
 package pkg
 
-import "net/http"
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+)
 
 func (s *Server) PatchStatus(w http.ResponseWriter, r *http.Request) {
+	store, err := s.Store(r)
+	if err != nil {
+		writeStatus(w, s.encoder(w, r), err)
+		return
+	}
+	codec, err := s.codec(w, r)
+	if err != nil {
+		writeStatus(w, s.encoder(w, r), err)
+		return
+	}
+
+	obj, err := s.PatchStatusImpl(store, codec, r)
+	if err == nil {
+		obj, err = s.transformObject(store, r, obj)
+	}
+	if err != nil {
+		writeStatus(w, codec, err)
+		return
+	}
+	_ = codec.Encode(obj, w)
+}
+
+func (s *Server) PatchStatusImpl(store *APIStorage, codec runtime.Codec, r *http.Request) (runtime.Object, error) {
+	if !s.hasStatusSubresource(store) {
+		return nil, apierrors.NewNotFound(store.GVR.GroupResource(), chi.URLParam(r, "name")+"/status")
+	}
+	return s.patchImpl(store, codec, r, "status")
 }
@@ -0,0 +1,63 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"context"
+	"testing"
+
+	"kmodules.xyz/fake-apiserver/pkg/resources"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+func TestPatchWithProtobuf(t *testing.T) {
+	_, cfg := newTestServer(t, nil)
+	if err := resources.InitCluster(cfg); err != nil {
+		t.Fatal(err)
+	}
+	cfg = rest.CopyConfig(cfg)
+	cfg.ContentType = runtime.ContentTypeProtobuf
+	cfg.AcceptContentTypes = runtime.ContentTypeProtobuf
+	kc := kubernetes.NewForConfigOrDie(cfg)
+	ctx := context.TODO()
+	cms := kc.CoreV1().ConfigMaps(metav1.NamespaceDefault)
+
+	createConfigMap(t, kc, "cm")
+	for _, tc := range []struct {
+		patchType types.PatchType
+		patch     string
+		key       string
+	}{
+		{types.JSONPatchType, `[{"op": "add", "path": "/data", "value": {"json": "1"}}]`, "json"},
+		{types.MergePatchType, `{"data": {"merge": "1"}}`, "merge"},
+		{types.StrategicMergePatchType, `{"data": {"strategic": "1"}}`, "strategic"},
+	} {
+		cm, err := cms.Patch(ctx, "cm", tc.patchType, []byte(tc.patch), metav1.PatchOptions{})
+		if err != nil {
+			t.Errorf("%s: %v", tc.patchType, err)
+			continue
+		}
+		if cm.Data[tc.key] != "1" {
+			t.Errorf("%s: expected data.%s to be set, got %v", tc.patchType, tc.key, cm.Data)
+		}
+	}
+}
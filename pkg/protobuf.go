@@ -0,0 +1,73 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// protobufPrefix is the magic number every Kubernetes protobuf message starts with.
+var protobufPrefix = []byte{0x6b, 0x38, 0x73, 0x00}
+
+func isProtobuf(data []byte) bool {
+	return bytes.HasPrefix(data, protobufPrefix)
+}
+
+// protobufSerializer converts unstructured objects to their typed form before they are encoded,
+// since only the generated types know how to marshal themselves as protobuf.
+type protobufSerializer struct {
+	runtime.Serializer
+	scheme *runtime.Scheme
+}
+
+var _ runtime.Serializer = protobufSerializer{}
+
+func (p protobufSerializer) Encode(obj runtime.Object, w io.Writer) error {
+	typed, err := p.toTyped(obj)
+	if err != nil {
+		return err
+	}
+	return p.Serializer.Encode(typed, w)
+}
+
+func (p protobufSerializer) toTyped(obj runtime.Object) (runtime.Object, error) {
+	var content map[string]any
+	switch o := obj.(type) {
+	case *unstructured.Unstructured:
+		content = o.UnstructuredContent()
+	case *unstructured.UnstructuredList:
+		content = o.UnstructuredContent()
+	default:
+		return obj, nil
+	}
+
+	gvk := obj.GetObjectKind().GroupVersionKind()
+	typed, err := p.scheme.New(gvk)
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode %v as protobuf: %w", gvk, err)
+	}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(content, typed); err != nil {
+		return nil, err
+	}
+	typed.GetObjectKind().SetGroupVersionKind(gvk)
+	return typed, nil
+}
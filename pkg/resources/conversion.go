@@ -0,0 +1,403 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resources
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/conversion"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// The annotations that keep the fields of autoscaling/v2 HorizontalPodAutoscalers that have no
+// equivalent in autoscaling/v1, like the kube-apiserver does.
+const (
+	hpaMetricsAnnotation    = "autoscaling.alpha.kubernetes.io/metrics"
+	hpaBehaviorAnnotation   = "autoscaling.alpha.kubernetes.io/behavior"
+	hpaConditionsAnnotation = "autoscaling.alpha.kubernetes.io/conditions"
+)
+
+// AddConversionFuncs registers the conversions between versions of built-in kinds that have
+// different fields and no generated conversions in the scheme.
+func AddConversionFuncs(scheme *runtime.Scheme) error {
+	if err := scheme.AddConversionFunc((*autoscalingv1.HorizontalPodAutoscaler)(nil), (*autoscalingv2.HorizontalPodAutoscaler)(nil), func(a, b any, _ conversion.Scope) error {
+		return convertHPAv1ToV2(a.(*autoscalingv1.HorizontalPodAutoscaler), b.(*autoscalingv2.HorizontalPodAutoscaler))
+	}); err != nil {
+		return err
+	}
+	return scheme.AddConversionFunc((*autoscalingv2.HorizontalPodAutoscaler)(nil), (*autoscalingv1.HorizontalPodAutoscaler)(nil), func(a, b any, _ conversion.Scope) error {
+		return convertHPAv2ToV1(a.(*autoscalingv2.HorizontalPodAutoscaler), b.(*autoscalingv1.HorizontalPodAutoscaler))
+	})
+}
+
+// Convertible returns true if objects of the kind from can be converted to the version of the kind to
+// and back. This is the case if the scheme has conversions between the two versions, either through
+// the internal version of their group or registered by AddConversionFuncs, or if both versions have
+// the same fields, so that only their apiVersion has to be changed.
+func Convertible(scheme *runtime.Scheme, from, to schema.GroupVersionKind) bool {
+	in, err := scheme.New(from)
+	if err != nil {
+		return false
+	}
+	out, err := scheme.New(to)
+	if err != nil {
+		return false
+	}
+	if sameShape(reflect.TypeOf(in).Elem(), reflect.TypeOf(out).Elem(), map[[2]reflect.Type]bool{}) {
+		return true
+	}
+	if internal := from.GroupKind().WithVersion(runtime.APIVersionInternal); scheme.Recognizes(internal) {
+		// the conversions to and from the internal version are generated for every version of the group
+		return true
+	}
+	return scheme.Convert(in, out, nil) == nil && scheme.Convert(out, in, nil) == nil
+}
+
+// sameShape returns true if values of the types a and b have the same JSON representation.
+func sameShape(a, b reflect.Type, seen map[[2]reflect.Type]bool) bool {
+	if a == b {
+		return true
+	}
+	if a.Kind() != b.Kind() {
+		return false
+	}
+	key := [2]reflect.Type{a, b}
+	if same, found := seen[key]; found {
+		return same
+	}
+	// recursive types are assumed to have the same shape until they differ
+	seen[key] = true
+
+	var same bool
+	switch a.Kind() {
+	case reflect.Pointer, reflect.Slice, reflect.Array:
+		same = (a.Kind() != reflect.Array || a.Len() == b.Len()) && sameShape(a.Elem(), b.Elem(), seen)
+	case reflect.Map:
+		same = sameShape(a.Key(), b.Key(), seen) && sameShape(a.Elem(), b.Elem(), seen)
+	case reflect.Struct:
+		same = sameFields(a, b, seen)
+	default:
+		same = true
+	}
+	seen[key] = same
+	return same
+}
+
+// sameFields returns true if the structs a and b have the same JSON fields with the same shapes.
+func sameFields(a, b reflect.Type, seen map[[2]reflect.Type]bool) bool {
+	fa, fb := jsonFields(a), jsonFields(b)
+	if len(fa) != len(fb) {
+		return false
+	}
+	for name, ta := range fa {
+		tb, found := fb[name]
+		if !found || !sameShape(ta, tb, seen) {
+			return false
+		}
+	}
+	return true
+}
+
+// jsonFields returns the types of the JSON fields of the struct t by their name, including the
+// fields of inlined structs.
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if f.Anonymous && name == "" || strings.Contains(opts, "inline") {
+			if f.Type.Kind() == reflect.Struct {
+				for n, ft := range jsonFields(f.Type) {
+					fields[n] = ft
+				}
+				continue
+			}
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = f.Type
+	}
+	return fields
+}
+
+func convertHPAv1ToV2(in *autoscalingv1.HorizontalPodAutoscaler, out *autoscalingv2.HorizontalPodAutoscaler) error {
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Spec = autoscalingv2.HorizontalPodAutoscalerSpec{
+		ScaleTargetRef: autoscalingv2.CrossVersionObjectReference(in.Spec.ScaleTargetRef),
+		MinReplicas:    in.Spec.MinReplicas,
+		MaxReplicas:    in.Spec.MaxReplicas,
+	}
+	out.Status = autoscalingv2.HorizontalPodAutoscalerStatus{
+		ObservedGeneration: in.Status.ObservedGeneration,
+		LastScaleTime:      in.Status.LastScaleTime,
+		CurrentReplicas:    in.Status.CurrentReplicas,
+		DesiredReplicas:    in.Status.DesiredReplicas,
+	}
+
+	if in.Spec.TargetCPUUtilizationPercentage != nil {
+		out.Spec.Metrics = append(out.Spec.Metrics, autoscalingv2.MetricSpec{
+			Type: autoscalingv2.ResourceMetricSourceType,
+			Resource: &autoscalingv2.ResourceMetricSource{
+				Name: core.ResourceCPU,
+				Target: autoscalingv2.MetricTarget{
+					Type:               autoscalingv2.UtilizationMetricType,
+					AverageUtilization: in.Spec.TargetCPUUtilizationPercentage,
+				},
+			},
+		})
+	}
+	if in.Status.CurrentCPUUtilizationPercentage != nil {
+		out.Status.CurrentMetrics = append(out.Status.CurrentMetrics, autoscalingv2.MetricStatus{
+			Type: autoscalingv2.ResourceMetricSourceType,
+			Resource: &autoscalingv2.ResourceMetricStatus{
+				Name: core.ResourceCPU,
+				Current: autoscalingv2.MetricValueStatus{
+					AverageUtilization: in.Status.CurrentCPUUtilizationPercentage,
+				},
+			},
+		})
+	}
+
+	annotations := out.GetAnnotations()
+	if v, found := annotations[hpaMetricsAnnotation]; found {
+		var metrics []autoscalingv1.MetricSpec
+		if err := json.Unmarshal([]byte(v), &metrics); err != nil {
+			return err
+		}
+		for _, m := range metrics {
+			out.Spec.Metrics = append(out.Spec.Metrics, metricSpecToV2(m))
+		}
+		delete(annotations, hpaMetricsAnnotation)
+	}
+	if v, found := annotations[hpaBehaviorAnnotation]; found {
+		var behavior autoscalingv2.HorizontalPodAutoscalerBehavior
+		if err := json.Unmarshal([]byte(v), &behavior); err != nil {
+			return err
+		}
+		out.Spec.Behavior = &behavior
+		delete(annotations, hpaBehaviorAnnotation)
+	}
+	if v, found := annotations[hpaConditionsAnnotation]; found {
+		var conditions []autoscalingv1.HorizontalPodAutoscalerCondition
+		if err := json.Unmarshal([]byte(v), &conditions); err != nil {
+			return err
+		}
+		for _, c := range conditions {
+			out.Status.Conditions = append(out.Status.Conditions, autoscalingv2.HorizontalPodAutoscalerCondition{
+				Type:               autoscalingv2.HorizontalPodAutoscalerConditionType(c.Type),
+				Status:             c.Status,
+				LastTransitionTime: c.LastTransitionTime,
+				Reason:             c.Reason,
+				Message:            c.Message,
+			})
+		}
+		delete(annotations, hpaConditionsAnnotation)
+	}
+	if len(annotations) == 0 {
+		annotations = nil
+	}
+	out.SetAnnotations(annotations)
+	return nil
+}
+
+func convertHPAv2ToV1(in *autoscalingv2.HorizontalPodAutoscaler, out *autoscalingv1.HorizontalPodAutoscaler) error {
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Spec = autoscalingv1.HorizontalPodAutoscalerSpec{
+		ScaleTargetRef: autoscalingv1.CrossVersionObjectReference(in.Spec.ScaleTargetRef),
+		MinReplicas:    in.Spec.MinReplicas,
+		MaxReplicas:    in.Spec.MaxReplicas,
+	}
+	out.Status = autoscalingv1.HorizontalPodAutoscalerStatus{
+		ObservedGeneration: in.Status.ObservedGeneration,
+		LastScaleTime:      in.Status.LastScaleTime,
+		CurrentReplicas:    in.Status.CurrentReplicas,
+		DesiredReplicas:    in.Status.DesiredReplicas,
+	}
+
+	// the target cpu utilization is a field of v1, the other metrics are kept in an annotation
+	var metrics []autoscalingv1.MetricSpec
+	for _, m := range in.Spec.Metrics {
+		if out.Spec.TargetCPUUtilizationPercentage == nil &&
+			m.Type == autoscalingv2.ResourceMetricSourceType && m.Resource != nil && m.Resource.Name == core.ResourceCPU &&
+			m.Resource.Target.Type == autoscalingv2.UtilizationMetricType && m.Resource.Target.AverageUtilization != nil {
+			out.Spec.TargetCPUUtilizationPercentage = m.Resource.Target.AverageUtilization
+			continue
+		}
+		metrics = append(metrics, metricSpecToV1(m))
+	}
+	for _, m := range in.Status.CurrentMetrics {
+		if m.Type == autoscalingv2.ResourceMetricSourceType && m.Resource != nil && m.Resource.Name == core.ResourceCPU &&
+			m.Resource.Current.AverageUtilization != nil {
+			out.Status.CurrentCPUUtilizationPercentage = m.Resource.Current.AverageUtilization
+			break
+		}
+	}
+
+	annotations := map[string]string{}
+	for k, v := range in.GetAnnotations() {
+		annotations[k] = v
+	}
+	if len(metrics) > 0 {
+		data, err := json.Marshal(metrics)
+		if err != nil {
+			return err
+		}
+		annotations[hpaMetricsAnnotation] = string(data)
+	}
+	if in.Spec.Behavior != nil {
+		data, err := json.Marshal(in.Spec.Behavior)
+		if err != nil {
+			return err
+		}
+		annotations[hpaBehaviorAnnotation] = string(data)
+	}
+	if len(in.Status.Conditions) > 0 {
+		conditions := make([]autoscalingv1.HorizontalPodAutoscalerCondition, 0, len(in.Status.Conditions))
+		for _, c := range in.Status.Conditions {
+			conditions = append(conditions, autoscalingv1.HorizontalPodAutoscalerCondition{
+				Type:               autoscalingv1.HorizontalPodAutoscalerConditionType(c.Type),
+				Status:             c.Status,
+				LastTransitionTime: c.LastTransitionTime,
+				Reason:             c.Reason,
+				Message:            c.Message,
+			})
+		}
+		data, err := json.Marshal(conditions)
+		if err != nil {
+			return err
+		}
+		annotations[hpaConditionsAnnotation] = string(data)
+	}
+	if len(annotations) == 0 {
+		annotations = nil
+	}
+	out.SetAnnotations(annotations)
+	return nil
+}
+
+// metricSpecToV1 converts an autoscaling/v2 metric to the form kept in the metrics annotation of autoscaling/v1.
+func metricSpecToV1(in autoscalingv2.MetricSpec) autoscalingv1.MetricSpec {
+	out := autoscalingv1.MetricSpec{Type: autoscalingv1.MetricSourceType(in.Type)}
+	switch {
+	case in.Object != nil:
+		out.Object = &autoscalingv1.ObjectMetricSource{
+			Target:       autoscalingv1.CrossVersionObjectReference(in.Object.DescribedObject),
+			MetricName:   in.Object.Metric.Name,
+			TargetValue:  quantity(in.Object.Target.Value),
+			Selector:     in.Object.Metric.Selector,
+			AverageValue: in.Object.Target.AverageValue,
+		}
+	case in.Pods != nil:
+		out.Pods = &autoscalingv1.PodsMetricSource{
+			MetricName:         in.Pods.Metric.Name,
+			TargetAverageValue: quantity(in.Pods.Target.AverageValue),
+			Selector:           in.Pods.Metric.Selector,
+		}
+	case in.Resource != nil:
+		out.Resource = &autoscalingv1.ResourceMetricSource{
+			Name:                     in.Resource.Name,
+			TargetAverageUtilization: in.Resource.Target.AverageUtilization,
+			TargetAverageValue:       in.Resource.Target.AverageValue,
+		}
+	case in.ContainerResource != nil:
+		out.ContainerResource = &autoscalingv1.ContainerResourceMetricSource{
+			Name:                     in.ContainerResource.Name,
+			TargetAverageUtilization: in.ContainerResource.Target.AverageUtilization,
+			TargetAverageValue:       in.ContainerResource.Target.AverageValue,
+			Container:                in.ContainerResource.Container,
+		}
+	case in.External != nil:
+		out.External = &autoscalingv1.ExternalMetricSource{
+			MetricName:         in.External.Metric.Name,
+			MetricSelector:     in.External.Metric.Selector,
+			TargetValue:        in.External.Target.Value,
+			TargetAverageValue: in.External.Target.AverageValue,
+		}
+	}
+	return out
+}
+
+// metricSpecToV2 converts a metric of the metrics annotation of autoscaling/v1 to autoscaling/v2.
+func metricSpecToV2(in autoscalingv1.MetricSpec) autoscalingv2.MetricSpec {
+	out := autoscalingv2.MetricSpec{Type: autoscalingv2.MetricSourceType(in.Type)}
+	switch {
+	case in.Object != nil:
+		target := autoscalingv2.MetricTarget{Type: autoscalingv2.ValueMetricType, Value: &in.Object.TargetValue}
+		if in.Object.AverageValue != nil {
+			target = autoscalingv2.MetricTarget{Type: autoscalingv2.AverageValueMetricType, AverageValue: in.Object.AverageValue}
+		}
+		out.Object = &autoscalingv2.ObjectMetricSource{
+			DescribedObject: autoscalingv2.CrossVersionObjectReference(in.Object.Target),
+			Target:          target,
+			Metric:          autoscalingv2.MetricIdentifier{Name: in.Object.MetricName, Selector: in.Object.Selector},
+		}
+	case in.Pods != nil:
+		out.Pods = &autoscalingv2.PodsMetricSource{
+			Metric: autoscalingv2.MetricIdentifier{Name: in.Pods.MetricName, Selector: in.Pods.Selector},
+			Target: autoscalingv2.MetricTarget{Type: autoscalingv2.AverageValueMetricType, AverageValue: &in.Pods.TargetAverageValue},
+		}
+	case in.Resource != nil:
+		out.Resource = &autoscalingv2.ResourceMetricSource{
+			Name:   in.Resource.Name,
+			Target: resourceTarget(in.Resource.TargetAverageUtilization, in.Resource.TargetAverageValue),
+		}
+	case in.ContainerResource != nil:
+		out.ContainerResource = &autoscalingv2.ContainerResourceMetricSource{
+			Name:      in.ContainerResource.Name,
+			Target:    resourceTarget(in.ContainerResource.TargetAverageUtilization, in.ContainerResource.TargetAverageValue),
+			Container: in.ContainerResource.Container,
+		}
+	case in.External != nil:
+		target := autoscalingv2.MetricTarget{Type: autoscalingv2.AverageValueMetricType, AverageValue: in.External.TargetAverageValue}
+		if in.External.TargetValue != nil {
+			target = autoscalingv2.MetricTarget{Type: autoscalingv2.ValueMetricType, Value: in.External.TargetValue}
+		}
+		out.External = &autoscalingv2.ExternalMetricSource{
+			Metric: autoscalingv2.MetricIdentifier{Name: in.External.MetricName, Selector: in.External.MetricSelector},
+			Target: target,
+		}
+	}
+	return out
+}
+
+func resourceTarget(utilization *int32, averageValue *resource.Quantity) autoscalingv2.MetricTarget {
+	if utilization != nil {
+		return autoscalingv2.MetricTarget{Type: autoscalingv2.UtilizationMetricType, AverageUtilization: utilization}
+	}
+	return autoscalingv2.MetricTarget{Type: autoscalingv2.AverageValueMetricType, AverageValue: averageValue}
+}
+
+func quantity(q *resource.Quantity) resource.Quantity {
+	if q == nil {
+		return resource.Quantity{}
+	}
+	return *q
+}
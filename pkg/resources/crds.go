@@ -17,6 +17,8 @@ limitations under the License.
 package resources
 
 import (
+	"slices"
+
 	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
@@ -52,33 +54,31 @@ status:
   - v1alpha2
 */
 
+// ProcessCRD sets the status of a CustomResourceDefinition like the controllers of the apiextensions-apiserver.
+// The names are accepted and the CRD is established right away. The storage version is added to the
+// stored versions, so that versions that were stored by earlier revisions of the CRD are kept.
 func ProcessCRD(u *unstructured.Unstructured) error {
 	var obj apiextensionsv1.CustomResourceDefinition
 	err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.UnstructuredContent(), &obj)
 	if err != nil {
 		return err
 	}
-	obj.Status = apiextensionsv1.CustomResourceDefinitionStatus{
-		Conditions: []apiextensionsv1.CustomResourceDefinitionCondition{
-			{
-				Type:               apiextensionsv1.Established,
-				Status:             apiextensionsv1.ConditionTrue,
-				LastTransitionTime: metav1.Now(),
-				Reason:             "InitialNamesAccepted",
-				Message:            "the initial names have been accepted",
-			},
-			{
-				Type:               apiextensionsv1.NamesAccepted,
-				Status:             apiextensionsv1.ConditionTrue,
-				LastTransitionTime: metav1.Now(),
-				Reason:             "NoConflicts",
-				Message:            "no conflicts found",
-			},
-		},
-		AcceptedNames: obj.Spec.Names,
-		StoredVersions: []string{
-			obj.Spec.Versions[0].Name,
-		},
+
+	setCRDCondition(&obj, apiextensionsv1.CustomResourceDefinitionCondition{
+		Type:    apiextensionsv1.NamesAccepted,
+		Status:  apiextensionsv1.ConditionTrue,
+		Reason:  "NoConflicts",
+		Message: "no conflicts found",
+	})
+	setCRDCondition(&obj, apiextensionsv1.CustomResourceDefinitionCondition{
+		Type:    apiextensionsv1.Established,
+		Status:  apiextensionsv1.ConditionTrue,
+		Reason:  "InitialNamesAccepted",
+		Message: "the initial names have been accepted",
+	})
+	obj.Status.AcceptedNames = obj.Spec.Names
+	if storage, found := StorageVersion(&obj); found && !slices.Contains(obj.Status.StoredVersions, storage) {
+		obj.Status.StoredVersions = append(obj.Status.StoredVersions, storage)
 	}
 
 	result, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&obj)
@@ -88,3 +88,31 @@ func ProcessCRD(u *unstructured.Unstructured) error {
 	u.SetUnstructuredContent(result)
 	return nil
 }
+
+// StorageVersion returns the name of the version of crd that is used to store its objects.
+func StorageVersion(crd *apiextensionsv1.CustomResourceDefinition) (string, bool) {
+	for _, v := range crd.Spec.Versions {
+		if v.Storage {
+			return v.Name, true
+		}
+	}
+	return "", false
+}
+
+// setCRDCondition adds or updates the condition of crd. The last transition time
+// is only changed if the status of the condition changes.
+func setCRDCondition(crd *apiextensionsv1.CustomResourceDefinition, cond apiextensionsv1.CustomResourceDefinitionCondition) {
+	for i, existing := range crd.Status.Conditions {
+		if existing.Type != cond.Type {
+			continue
+		}
+		cond.LastTransitionTime = existing.LastTransitionTime
+		if existing.Status != cond.Status {
+			cond.LastTransitionTime = metav1.Now()
+		}
+		crd.Status.Conditions[i] = cond
+		return
+	}
+	cond.LastTransitionTime = metav1.Now()
+	crd.Status.Conditions = append(crd.Status.Conditions, cond)
+}
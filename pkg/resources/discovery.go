@@ -0,0 +1,110 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resources
+
+import (
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// defaultVerbs are the verbs served for every resource that is not listed in builtinVerbs.
+var defaultVerbs = []string{"create", "delete", "deletecollection", "get", "list", "patch", "update", "watch"}
+
+// builtinVerbs are the built-in resources that do not support all the defaultVerbs.
+var builtinVerbs = map[schema.GroupResource][]string{
+	{Group: "", Resource: "bindings"}:                                                {"create"},
+	{Group: "", Resource: "componentstatuses"}:                                       {"get", "list"},
+	{Group: "", Resource: "namespaces"}:                                              {"create", "delete", "get", "list", "patch", "update", "watch"},
+	{Group: "authentication.k8s.io", Resource: "selfsubjectreviews"}:                 {"create"},
+	{Group: "authentication.k8s.io", Resource: "tokenreviews"}:                       {"create"},
+	{Group: "authorization.k8s.io", Resource: "localsubjectaccessreviews"}:           {"create"},
+	{Group: "authorization.k8s.io", Resource: "selfsubjectaccessreviews"}:            {"create"},
+	{Group: "authorization.k8s.io", Resource: "selfsubjectrulesreviews"}:             {"create"},
+	{Group: "authorization.k8s.io", Resource: "subjectaccessreviews"}:                {"create"},
+	{Group: "certificates.k8s.io", Resource: "certificatesigningrequests"}:           defaultVerbs,
+	{Group: "flowcontrol.apiserver.k8s.io", Resource: "flowschemas"}:                 defaultVerbs,
+	{Group: "flowcontrol.apiserver.k8s.io", Resource: "prioritylevelconfigurations"}: defaultVerbs,
+}
+
+// builtinShortNames are the short names of the built-in resources.
+var builtinShortNames = map[schema.GroupResource][]string{
+	{Group: "", Resource: "componentstatuses"}:                             {"cs"},
+	{Group: "", Resource: "configmaps"}:                                    {"cm"},
+	{Group: "", Resource: "endpoints"}:                                     {"ep"},
+	{Group: "", Resource: "events"}:                                        {"ev"},
+	{Group: "", Resource: "limitranges"}:                                   {"limits"},
+	{Group: "", Resource: "namespaces"}:                                    {"ns"},
+	{Group: "", Resource: "nodes"}:                                         {"no"},
+	{Group: "", Resource: "persistentvolumeclaims"}:                        {"pvc"},
+	{Group: "", Resource: "persistentvolumes"}:                             {"pv"},
+	{Group: "", Resource: "pods"}:                                          {"po"},
+	{Group: "", Resource: "replicationcontrollers"}:                        {"rc"},
+	{Group: "", Resource: "resourcequotas"}:                                {"quota"},
+	{Group: "", Resource: "serviceaccounts"}:                               {"sa"},
+	{Group: "", Resource: "services"}:                                      {"svc"},
+	{Group: "apiextensions.k8s.io", Resource: "customresourcedefinitions"}: {"crd", "crds"},
+	{Group: "apps", Resource: "daemonsets"}:                                {"ds"},
+	{Group: "apps", Resource: "deployments"}:                               {"deploy"},
+	{Group: "apps", Resource: "replicasets"}:                               {"rs"},
+	{Group: "apps", Resource: "statefulsets"}:                              {"sts"},
+	{Group: "autoscaling", Resource: "horizontalpodautoscalers"}:           {"hpa"},
+	{Group: "batch", Resource: "cronjobs"}:                                 {"cj"},
+	{Group: "certificates.k8s.io", Resource: "certificatesigningrequests"}: {"csr"},
+	{Group: "events.k8s.io", Resource: "events"}:                           {"ev"},
+	{Group: "networking.k8s.io", Resource: "ingresses"}:                    {"ing"},
+	{Group: "networking.k8s.io", Resource: "networkpolicies"}:              {"netpol"},
+	{Group: "policy", Resource: "poddisruptionbudgets"}:                    {"pdb"},
+	{Group: "scheduling.k8s.io", Resource: "priorityclasses"}:              {"pc"},
+	{Group: "storage.k8s.io", Resource: "storageclasses"}:                  {"sc"},
+}
+
+// builtinCategories are the categories of the built-in resources, e.g. the resources shown by "kubectl get all".
+var builtinCategories = map[schema.GroupResource][]string{
+	{Group: "", Resource: "pods"}:                                                          {"all"},
+	{Group: "", Resource: "replicationcontrollers"}:                                        {"all"},
+	{Group: "", Resource: "services"}:                                                      {"all"},
+	{Group: "admissionregistration.k8s.io", Resource: "mutatingwebhookconfigurations"}:     {"api-extensions"},
+	{Group: "admissionregistration.k8s.io", Resource: "validatingadmissionpolicies"}:       {"api-extensions"},
+	{Group: "admissionregistration.k8s.io", Resource: "validatingadmissionpolicybindings"}: {"api-extensions"},
+	{Group: "admissionregistration.k8s.io", Resource: "validatingwebhookconfigurations"}:   {"api-extensions"},
+	{Group: "apiextensions.k8s.io", Resource: "customresourcedefinitions"}:                 {"api-extensions"},
+	{Group: "apiregistration.k8s.io", Resource: "apiservices"}:                             {"api-extensions"},
+	{Group: "apps", Resource: "daemonsets"}:                                                {"all"},
+	{Group: "apps", Resource: "deployments"}:                                               {"all"},
+	{Group: "apps", Resource: "replicasets"}:                                               {"all"},
+	{Group: "apps", Resource: "statefulsets"}:                                              {"all"},
+	{Group: "autoscaling", Resource: "horizontalpodautoscalers"}:                           {"all"},
+	{Group: "batch", Resource: "cronjobs"}:                                                 {"all"},
+	{Group: "batch", Resource: "jobs"}:                                                     {"all"},
+}
+
+// Verbs returns the verbs supported by the built-in resource gr.
+func Verbs(gr schema.GroupResource) []string {
+	if verbs, found := builtinVerbs[gr]; found {
+		return verbs
+	}
+	return defaultVerbs
+}
+
+// ShortNames returns the short names of the built-in resource gr.
+func ShortNames(gr schema.GroupResource) []string {
+	return builtinShortNames[gr]
+}
+
+// Categories returns the categories of the built-in resource gr.
+func Categories(gr schema.GroupResource) []string {
+	return builtinCategories[gr]
+}
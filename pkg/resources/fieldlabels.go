@@ -0,0 +1,139 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resources
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// fieldLabel returns the value of a field label of an object.
+type fieldLabel func(obj *unstructured.Unstructured) string
+
+// builtinFieldLabels are the field labels the kube-apiserver supports for built-in kinds in
+// addition to metadata.name and metadata.namespace, as set by their registry strategies.
+// They are the same for all versions of a kind.
+var builtinFieldLabels = map[schema.GroupKind]map[string]fieldLabel{
+	{Group: "", Kind: "Event"}: {
+		"involvedObject.kind":            field("", "involvedObject", "kind"),
+		"involvedObject.namespace":       field("", "involvedObject", "namespace"),
+		"involvedObject.name":            field("", "involvedObject", "name"),
+		"involvedObject.uid":             field("", "involvedObject", "uid"),
+		"involvedObject.apiVersion":      field("", "involvedObject", "apiVersion"),
+		"involvedObject.resourceVersion": field("", "involvedObject", "resourceVersion"),
+		"involvedObject.fieldPath":       field("", "involvedObject", "fieldPath"),
+		"reason":                         field("", "reason"),
+		"reportingComponent":             field("", "reportingComponent"),
+		"source":                         field("", "source", "component"),
+		"type":                           field("", "type"),
+	},
+	{Group: "", Kind: "Namespace"}: {
+		"status.phase": field("", "status", "phase"),
+	},
+	{Group: "", Kind: "Node"}: {
+		"spec.unschedulable": field("false", "spec", "unschedulable"),
+	},
+	{Group: "", Kind: "Pod"}: {
+		"spec.nodeName":            field("", "spec", "nodeName"),
+		"spec.restartPolicy":       field("", "spec", "restartPolicy"),
+		"spec.schedulerName":       field("", "spec", "schedulerName"),
+		"spec.serviceAccountName":  field("", "spec", "serviceAccountName"),
+		"spec.hostNetwork":         field("false", "spec", "hostNetwork"),
+		"status.phase":             field("", "status", "phase"),
+		"status.podIP":             field("", "status", "podIP"),
+		"status.nominatedNodeName": field("", "status", "nominatedNodeName"),
+	},
+	{Group: "", Kind: "ReplicationController"}: {
+		"status.replicas": field("0", "status", "replicas"),
+	},
+	{Group: "", Kind: "Secret"}: {
+		"type": field("", "type"),
+	},
+	{Group: "", Kind: "Service"}: {
+		"spec.clusterIP": field("", "spec", "clusterIP"),
+		"spec.type":      field("", "spec", "type"),
+	},
+	{Group: "apps", Kind: "ReplicaSet"}: {
+		"status.replicas": field("0", "status", "replicas"),
+	},
+	{Group: "batch", Kind: "Job"}: {
+		"status.successful": field("0", "status", "succeeded"),
+	},
+	{Group: "certificates.k8s.io", Kind: "CertificateSigningRequest"}: {
+		"spec.signerName": field("", "spec", "signerName"),
+	},
+	{Group: "events.k8s.io", Kind: "Event"}: {
+		"regarding.kind":            field("", "regarding", "kind"),
+		"regarding.namespace":       field("", "regarding", "namespace"),
+		"regarding.name":            field("", "regarding", "name"),
+		"regarding.uid":             field("", "regarding", "uid"),
+		"regarding.apiVersion":      field("", "regarding", "apiVersion"),
+		"regarding.resourceVersion": field("", "regarding", "resourceVersion"),
+		"regarding.fieldPath":       field("", "regarding", "fieldPath"),
+		"reason":                    field("", "reason"),
+		"reportingController":       field("", "reportingController"),
+		"type":                      field("", "type"),
+	},
+}
+
+// FieldLabels returns the field labels of obj of kind gvk for field selectors. The labels
+// supported for every kind are metadata.name and metadata.namespace.
+func FieldLabels(gvk schema.GroupVersionKind, obj *unstructured.Unstructured) fields.Set {
+	set := fields.Set{
+		"metadata.name":      obj.GetName(),
+		"metadata.namespace": obj.GetNamespace(),
+	}
+	for label, fn := range builtinFieldLabels[gvk.GroupKind()] {
+		set[label] = fn(obj)
+	}
+	return set
+}
+
+// HasFieldLabel returns true if label can be used in the field selectors of kind gvk.
+func HasFieldLabel(gvk schema.GroupVersionKind, label string) bool {
+	if label == "metadata.name" || label == "metadata.namespace" {
+		return true
+	}
+	_, found := builtinFieldLabels[gvk.GroupKind()][label]
+	return found
+}
+
+// field returns the value of the field at path as a field label, or def if it is not set.
+func field(def string, path ...string) fieldLabel {
+	return func(obj *unstructured.Unstructured) string {
+		return FieldValue(obj, def, path...)
+	}
+}
+
+// FieldValue returns the string, boolean or integer field of obj at path as a field label value,
+// or def if it is not set.
+func FieldValue(obj *unstructured.Unstructured, def string, path ...string) string {
+	v, found, err := unstructured.NestedFieldNoCopy(obj.Object, path...)
+	if err != nil || !found || v == nil {
+		return def
+	}
+	switch v := v.(type) {
+	case string:
+		return v
+	case bool, int64, float64:
+		return fmt.Sprint(v)
+	}
+	return def
+}
@@ -0,0 +1,287 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resources
+
+import (
+	"reflect"
+	"runtime/debug"
+	"sort"
+	"strings"
+
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/applyconfigurations"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+	smdschema "sigs.k8s.io/structured-merge-diff/v6/schema"
+)
+
+const (
+	definitionsPrefix = "#/definitions/"
+
+	listTypeExtension         = "x-kubernetes-list-type"
+	listMapKeysExtension      = "x-kubernetes-list-map-keys"
+	mapTypeExtension          = "x-kubernetes-map-type"
+	patchStrategyExtension    = "x-kubernetes-patch-strategy"
+	patchMergeKeyExtension    = "x-kubernetes-patch-merge-key"
+	groupVersionKindExtension = "x-kubernetes-group-version-kind"
+)
+
+type openAPISchemaType interface {
+	OpenAPISchemaType() []string
+	OpenAPISchemaFormat() string
+}
+
+type swaggerDoc interface {
+	SwaggerDoc() map[string]string
+}
+
+// BuiltinOpenAPISwagger returns the OpenAPI v2 document of the built-in Kubernetes types registered in scheme.
+// The definitions are generated from the vendored Go types, so they always match the served API version.
+// Descriptions come from the generated SwaggerDoc of the types and the list and map semantics from the
+// structured merge schema that client-go ships for server-side apply.
+func BuiltinOpenAPISwagger(scheme *runtime.Scheme) (*spec.Swagger, error) {
+	g := &openAPIGenerator{
+		defs: spec.Definitions{},
+		gvks: map[reflect.Type][]schema.GroupVersionKind{},
+	}
+	// every typed value carries the full schema it was parsed with
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(core.SchemeGroupVersion.WithKind("ConfigMap"))
+	tv, err := applyconfigurations.NewTypeConverter(scheme).ObjectToTyped(obj)
+	if err != nil {
+		return nil, err
+	}
+	g.structured = tv.Schema()
+
+	for gvk, t := range scheme.AllKnownTypes() {
+		if gvk.Version == runtime.APIVersionInternal {
+			continue
+		}
+		g.gvks[t] = append(g.gvks[t], gvk)
+	}
+	for t := range g.gvks {
+		g.definition(t)
+	}
+
+	return &spec.Swagger{
+		SwaggerProps: spec.SwaggerProps{
+			Swagger: "2.0",
+			Info: &spec.Info{
+				InfoProps: spec.InfoProps{
+					Title:   "Kubernetes",
+					Version: kubernetesVersion(),
+				},
+			},
+			Paths:       &spec.Paths{Paths: map[string]spec.PathItem{}},
+			Definitions: g.defs,
+		},
+	}, nil
+}
+
+// kubernetesVersion returns the Kubernetes version of the vendored k8s.io/api module, e.g. v1.34.3 for v0.34.3.
+func kubernetesVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, dep := range info.Deps {
+			if dep.Path == "k8s.io/api" && strings.HasPrefix(dep.Version, "v0.") {
+				return "v1." + strings.TrimPrefix(dep.Version, "v0.")
+			}
+		}
+	}
+	return "unversioned"
+}
+
+type openAPIGenerator struct {
+	defs       spec.Definitions
+	gvks       map[reflect.Type][]schema.GroupVersionKind
+	structured *smdschema.Schema
+}
+
+// definitionName returns the OpenAPI definition name of a Go type, e.g. io.k8s.api.core.v1.Pod.
+func definitionName(t reflect.Type) string {
+	parts := strings.Split(t.PkgPath()+"."+t.Name(), "/")
+	if strings.Contains(parts[0], ".") {
+		domain := strings.Split(parts[0], ".")
+		for i, j := 0, len(domain)-1; i < j; i, j = i+1, j-1 {
+			domain[i], domain[j] = domain[j], domain[i]
+		}
+		parts[0] = strings.Join(domain, ".")
+	}
+	return strings.Join(parts, ".")
+}
+
+// definition adds the definition of the named type t and of every type it refers to, and returns its name.
+func (g *openAPIGenerator) definition(t reflect.Type) string {
+	name := definitionName(t)
+	if _, found := g.defs[name]; found {
+		return name
+	}
+	// reserve the name first, definitions can be recursive
+	g.defs[name] = spec.Schema{}
+
+	var def spec.Schema
+	if st, ok := reflect.Zero(t).Interface().(openAPISchemaType); ok {
+		def.Type = st.OpenAPISchemaType()
+		def.Format = st.OpenAPISchemaFormat()
+	} else {
+		def.Type = spec.StringOrArray{"object"}
+		g.addProperties(&def, t, g.structuredType(name))
+	}
+	if doc, ok := reflect.Zero(t).Interface().(swaggerDoc); ok {
+		def.Description = doc.SwaggerDoc()[""]
+	}
+	if gvks := g.gvks[t]; len(gvks) > 0 {
+		sort.Slice(gvks, func(i, j int) bool {
+			return gvks[i].String() < gvks[j].String()
+		})
+		ext := make([]any, 0, len(gvks))
+		for _, gvk := range gvks {
+			ext = append(ext, map[string]any{
+				"group":   gvk.Group,
+				"version": gvk.Version,
+				"kind":    gvk.Kind,
+			})
+		}
+		def.AddExtension(groupVersionKindExtension, ext)
+	}
+	g.defs[name] = def
+	return name
+}
+
+// addProperties adds the json fields of the struct type t to def, including the fields of inlined structs.
+func (g *openAPIGenerator) addProperties(def *spec.Schema, t reflect.Type, structured *smdschema.Map) {
+	if structured != nil && structured.ElementRelationship == smdschema.Atomic {
+		def.AddExtension(mapTypeExtension, "atomic")
+	}
+
+	var docs map[string]string
+	if doc, ok := reflect.Zero(t).Interface().(swaggerDoc); ok {
+		docs = doc.SwaggerDoc()
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag, hasTag := f.Tag.Lookup("json")
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "-" || (!f.IsExported() && !f.Anonymous) {
+			continue
+		}
+		if f.Anonymous && name == "" && (!hasTag || strings.Contains(opts, "inline")) {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				g.addProperties(def, ft, structured)
+				continue
+			}
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		prop := g.schemaOf(f.Type)
+		prop.Description = docs[name]
+		if structured != nil {
+			if sf, found := structured.FindField(name); found {
+				g.addStructuredExtensions(&prop, sf.Type)
+			}
+		}
+		if strategy := f.Tag.Get("patchStrategy"); strategy != "" {
+			prop.AddExtension(patchStrategyExtension, strategy)
+		}
+		if key := f.Tag.Get("patchMergeKey"); key != "" {
+			prop.AddExtension(patchMergeKeyExtension, key)
+		}
+		def.SetProperty(name, prop)
+		if !strings.Contains(opts, "omitempty") && !strings.Contains(opts, "omitzero") && f.Type.Kind() != reflect.Pointer {
+			def.Required = append(def.Required, name)
+		}
+	}
+}
+
+// schemaOf returns the schema of a field of type t. Named struct types are referred to by their definition.
+func (g *openAPIGenerator) schemaOf(t reflect.Type) spec.Schema {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if _, ok := reflect.Zero(t).Interface().(openAPISchemaType); ok || (t.Kind() == reflect.Struct && t.Name() != "") {
+		return spec.Schema{SchemaProps: spec.SchemaProps{
+			Ref: spec.MustCreateRef(definitionsPrefix + g.definition(t)),
+		}}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return *spec.StringProperty()
+	case reflect.Bool:
+		return *spec.BoolProperty()
+	case reflect.Int32, reflect.Int16, reflect.Int8, reflect.Uint16, reflect.Uint8:
+		return *spec.Int32Property()
+	case reflect.Int64, reflect.Int, reflect.Uint64, reflect.Uint32, reflect.Uint:
+		return *spec.Int64Property()
+	case reflect.Float64:
+		return *spec.Float64Property()
+	case reflect.Float32:
+		return *spec.Float32Property()
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return *spec.StrFmtProperty("byte")
+		}
+		return *spec.ArrayProperty(ptr(g.schemaOf(t.Elem())))
+	case reflect.Map:
+		return *spec.MapProperty(ptr(g.schemaOf(t.Elem())))
+	case reflect.Struct:
+		def := spec.Schema{SchemaProps: spec.SchemaProps{Type: spec.StringOrArray{"object"}}}
+		g.addProperties(&def, t, nil)
+		return def
+	}
+	// interface{} accepts any value
+	return spec.Schema{}
+}
+
+// structuredType returns the structured merge schema of the named definition.
+func (g *openAPIGenerator) structuredType(name string) *smdschema.Map {
+	if td, found := g.structured.FindNamedType(name); found && td.Map != nil {
+		return td.Map
+	}
+	return nil
+}
+
+// addStructuredExtensions adds the list and map extensions of the structured merge type tr to prop.
+func (g *openAPIGenerator) addStructuredExtensions(prop *spec.Schema, tr smdschema.TypeRef) {
+	atom, found := g.structured.Resolve(tr)
+	switch {
+	case !found:
+	case atom.List != nil:
+		switch {
+		case atom.List.ElementRelationship == smdschema.Associative && len(atom.List.Keys) > 0:
+			prop.AddExtension(listTypeExtension, "map")
+			prop.AddExtension(listMapKeysExtension, atom.List.Keys)
+		case atom.List.ElementRelationship == smdschema.Associative:
+			prop.AddExtension(listTypeExtension, "set")
+		default:
+			prop.AddExtension(listTypeExtension, "atomic")
+		}
+	case atom.Map != nil && (tr.NamedType == nil || tr.ElementRelationship != nil) && atom.Map.ElementRelationship == smdschema.Atomic:
+		prop.AddExtension(mapTypeExtension, "atomic")
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,47 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resources
+
+import (
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// createOnUpdateResources are the built-in resources whose update strategy creates missing objects.
+var createOnUpdateResources = sets.New[schema.GroupResource](
+	schema.GroupResource{Group: "", Resource: "endpoints"},
+	schema.GroupResource{Group: "", Resource: "events"},
+	schema.GroupResource{Group: "", Resource: "limitranges"},
+	schema.GroupResource{Group: "", Resource: "replicationcontrollers"},
+	schema.GroupResource{Group: "", Resource: "serviceaccounts"},
+	schema.GroupResource{Group: "", Resource: "services"},
+	schema.GroupResource{Group: "coordination.k8s.io", Resource: "leases"},
+	schema.GroupResource{Group: "discovery.k8s.io", Resource: "endpointslices"},
+	schema.GroupResource{Group: "events.k8s.io", Resource: "events"},
+	schema.GroupResource{Group: "flowcontrol.apiserver.k8s.io", Resource: "flowschemas"},
+	schema.GroupResource{Group: "flowcontrol.apiserver.k8s.io", Resource: "prioritylevelconfigurations"},
+	schema.GroupResource{Group: "rbac.authorization.k8s.io", Resource: "clusterrolebindings"},
+	schema.GroupResource{Group: "rbac.authorization.k8s.io", Resource: "clusterroles"},
+	schema.GroupResource{Group: "rbac.authorization.k8s.io", Resource: "rolebindings"},
+	schema.GroupResource{Group: "rbac.authorization.k8s.io", Resource: "roles"},
+)
+
+// AllowCreateOnUpdate returns true if an update of a missing object of the built-in resource gr creates it.
+// Custom resources never allow create on update.
+func AllowCreateOnUpdate(gr schema.GroupResource) bool {
+	return createOnUpdateResources.Has(gr)
+}
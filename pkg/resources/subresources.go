@@ -0,0 +1,70 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resources
+
+import (
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// statusSubresources are the built-in resources with a status subresource.
+var statusSubresources = sets.New[schema.GroupResource](
+	schema.GroupResource{Group: "", Resource: "namespaces"},
+	schema.GroupResource{Group: "", Resource: "nodes"},
+	schema.GroupResource{Group: "", Resource: "persistentvolumeclaims"},
+	schema.GroupResource{Group: "", Resource: "persistentvolumes"},
+	schema.GroupResource{Group: "", Resource: "pods"},
+	schema.GroupResource{Group: "", Resource: "replicationcontrollers"},
+	schema.GroupResource{Group: "", Resource: "resourcequotas"},
+	schema.GroupResource{Group: "", Resource: "services"},
+	schema.GroupResource{Group: "admissionregistration.k8s.io", Resource: "validatingadmissionpolicies"},
+	schema.GroupResource{Group: "apiextensions.k8s.io", Resource: "customresourcedefinitions"},
+	schema.GroupResource{Group: "apiregistration.k8s.io", Resource: "apiservices"},
+	schema.GroupResource{Group: "apps", Resource: "daemonsets"},
+	schema.GroupResource{Group: "apps", Resource: "deployments"},
+	schema.GroupResource{Group: "apps", Resource: "replicasets"},
+	schema.GroupResource{Group: "apps", Resource: "statefulsets"},
+	schema.GroupResource{Group: "autoscaling", Resource: "horizontalpodautoscalers"},
+	schema.GroupResource{Group: "batch", Resource: "cronjobs"},
+	schema.GroupResource{Group: "batch", Resource: "jobs"},
+	schema.GroupResource{Group: "certificates.k8s.io", Resource: "certificatesigningrequests"},
+	schema.GroupResource{Group: "flowcontrol.apiserver.k8s.io", Resource: "flowschemas"},
+	schema.GroupResource{Group: "flowcontrol.apiserver.k8s.io", Resource: "prioritylevelconfigurations"},
+	schema.GroupResource{Group: "networking.k8s.io", Resource: "ingresses"},
+	schema.GroupResource{Group: "networking.k8s.io", Resource: "servicecidrs"},
+	schema.GroupResource{Group: "policy", Resource: "poddisruptionbudgets"},
+	schema.GroupResource{Group: "resource.k8s.io", Resource: "resourceclaims"},
+	schema.GroupResource{Group: "storage.k8s.io", Resource: "volumeattachments"},
+)
+
+// HasStatusSubresource returns true if the built-in resource gr has a status subresource.
+func HasStatusSubresource(gr schema.GroupResource) bool {
+	return statusSubresources.Has(gr)
+}
+
+// scaleSubresources are the built-in resources with a scale subresource.
+var scaleSubresources = sets.New[schema.GroupResource](
+	schema.GroupResource{Group: "", Resource: "replicationcontrollers"},
+	schema.GroupResource{Group: "apps", Resource: "deployments"},
+	schema.GroupResource{Group: "apps", Resource: "replicasets"},
+	schema.GroupResource{Group: "apps", Resource: "statefulsets"},
+)
+
+// HasScaleSubresource returns true if the built-in resource gr has a scale subresource.
+func HasScaleSubresource(gr schema.GroupResource) bool {
+	return scaleSubresources.Has(gr)
+}
@@ -0,0 +1,174 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resources
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	iofs "io/fs"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	rsapi "kmodules.xyz/resource-metadata/apis/meta/v1alpha1"
+
+	"github.com/Masterminds/sprig/v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"sigs.k8s.io/yaml"
+)
+
+// TablePrinter renders objects of a resource as the rows of a Table, like the printers of kubectl.
+type TablePrinter struct {
+	Columns []metav1.TableColumnDefinition
+	Cells   func(obj *unstructured.Unstructured) ([]any, error)
+}
+
+// resourcetabledefinitions holds the ResourceTableDefinitions of the built-in resources, in the layout of
+// the hub of kmodules.xyz/resource-metadata: <group>/<version>/<resource>.yaml.
+//
+//go:embed resourcetabledefinitions
+var resourcetabledefinitions embed.FS
+
+var tablePrinters = loadTablePrinters()
+
+func loadTablePrinters() map[schema.GroupVersionResource]TablePrinter {
+	printers := map[schema.GroupVersionResource]TablePrinter{}
+	err := iofs.WalkDir(resourcetabledefinitions, ".", func(path string, d iofs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || filepath.Ext(path) != ".yaml" {
+			return err
+		}
+		data, err := resourcetabledefinitions.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var def rsapi.ResourceTableDefinition
+		if err := yaml.Unmarshal(data, &def); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if def.Spec.Resource == nil {
+			return fmt.Errorf("%s: missing resource", path)
+		}
+		p, err := definitionTablePrinter(def.Spec.Columns)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		printers[def.Spec.Resource.GroupVersionResource()] = p
+		return nil
+	})
+	if err != nil {
+		panic(fmt.Errorf("failed to load %s: %w", rsapi.ResourceKindResourceTableDefinition, err))
+	}
+	return printers
+}
+
+// definitionTablePrinter renders the columns of a ResourceTableDefinition. The path template of a column is
+// executed against the object, with the functions of sprig and the JSONPath function jp, and its output is
+// converted to the type of the column.
+func definitionTablePrinter(columns []rsapi.ResourceColumnDefinition) (TablePrinter, error) {
+	defs := make([]metav1.TableColumnDefinition, 0, len(columns))
+	templates := make([]*template.Template, 0, len(columns))
+	for _, col := range columns {
+		tpl, err := template.New(col.Name).Funcs(sprig.TxtFuncMap()).Parse(col.PathTemplate)
+		if err != nil {
+			return TablePrinter{}, fmt.Errorf("column %q: %w", col.Name, err)
+		}
+		templates = append(templates, tpl)
+		defs = append(defs, metav1.TableColumnDefinition{
+			Name:        col.Name,
+			Type:        col.Type,
+			Format:      col.Format,
+			Description: col.Description,
+			Priority:    col.Priority,
+		})
+	}
+
+	return TablePrinter{
+		Columns: defs,
+		Cells: func(obj *unstructured.Unstructured) ([]any, error) {
+			cells := make([]any, 0, len(defs))
+			var buf bytes.Buffer
+			for i, tpl := range templates {
+				buf.Reset()
+				if err := tpl.Execute(&buf, obj.UnstructuredContent()); err != nil {
+					return nil, fmt.Errorf("column %q: %w", defs[i].Name, err)
+				}
+				cells = append(cells, cellForTemplateValue(defs[i].Type, strings.TrimSpace(buf.String())))
+			}
+			return cells, nil
+		},
+	}, nil
+}
+
+func cellForTemplateValue(headerType string, value string) any {
+	if value == "" {
+		return nil
+	}
+
+	switch headerType {
+	case "integer":
+		if i64, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return i64
+		}
+	case "number":
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+	case "boolean":
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	case "date":
+		var timestamp metav1.Time
+		if err := timestamp.UnmarshalQueryParameter(value); err != nil {
+			return "<invalid>"
+		}
+		return TranslateTimestampSince(timestamp)
+	default:
+		return value
+	}
+
+	return nil
+}
+
+// TablePrinterFor returns the TablePrinter of the built-in resource gvr. The ResourceTableDefinition of another
+// version of the resource is used if there is none for gvr, since the versions of a built-in resource served by
+// the fake-apiserver have the same fields.
+func TablePrinterFor(gvr schema.GroupVersionResource) (TablePrinter, bool) {
+	if p, found := tablePrinters[gvr]; found {
+		return p, true
+	}
+	for other, p := range tablePrinters {
+		if other.GroupResource() == gvr.GroupResource() {
+			return p, true
+		}
+	}
+	return TablePrinter{}, false
+}
+
+// TranslateTimestampSince returns the elapsed time since timestamp in human-readable approximation.
+func TranslateTimestampSince(timestamp metav1.Time) string {
+	if timestamp.IsZero() {
+		return "<unknown>"
+	}
+	return duration.HumanDuration(time.Since(timestamp.Time))
+}
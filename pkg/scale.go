@@ -0,0 +1,371 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"kmodules.xyz/fake-apiserver/pkg/resources"
+
+	"github.com/go-chi/chi/v5"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+var scaleGVK = autoscalingv1.SchemeGroupVersion.WithKind("Scale")
+
+// builtinScale is the scale subresource of the built-in resources.
+// Their selector is read from spec.selector instead of a label selector path.
+var builtinScale = apiextensionsv1.CustomResourceSubresourceScale{
+	SpecReplicasPath:   ".spec.replicas",
+	StatusReplicasPath: ".status.replicas",
+}
+
+func (s *Server) GetScale(w http.ResponseWriter, r *http.Request) {
+	store, err := s.Store(r)
+	if err != nil {
+		writeStatus(w, s.encoder(w, r), err)
+		return
+	}
+	codec, err := s.codec(w, r)
+	if err != nil {
+		writeStatus(w, s.encoder(w, r), err)
+		return
+	}
+
+	obj, err := s.GetScaleImpl(store, r)
+	if err != nil {
+		writeStatus(w, codec, err)
+		return
+	}
+	_ = codec.Encode(obj, w)
+}
+
+func (s *Server) GetScaleImpl(store *APIStorage, r *http.Request) (runtime.Object, error) {
+	key := types.NamespacedName{
+		Namespace: chi.URLParam(r, "namespace"),
+		Name:      chi.URLParam(r, "name"),
+	}
+	paths, found := s.scaleSubresource(store)
+	if !found {
+		return nil, apierrors.NewNotFound(store.GVR.GroupResource(), key.Name+"/scale")
+	}
+
+	obj, exists, err := store.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, apierrors.NewNotFound(store.GVR.GroupResource(), key.Name)
+	}
+	return scaleFromObject(obj, paths)
+}
+
+func (s *Server) UpdateScale(w http.ResponseWriter, r *http.Request) {
+	store, err := s.Store(r)
+	if err != nil {
+		writeStatus(w, s.encoder(w, r), err)
+		return
+	}
+	codec, err := s.codec(w, r)
+	if err != nil {
+		writeStatus(w, s.encoder(w, r), err)
+		return
+	}
+
+	obj, err := s.UpdateScaleImpl(store, codec, r)
+	if err != nil {
+		writeStatus(w, codec, err)
+		return
+	}
+	_ = codec.Encode(obj, w)
+}
+
+func (s *Server) UpdateScaleImpl(store *APIStorage, codec runtime.Codec, r *http.Request) (runtime.Object, error) {
+	var opts metav1.UpdateOptions
+	err := s.opts.ParameterCodec.DecodeParameters(r.URL.Query(), metav1.SchemeGroupVersion, &opts)
+	if err != nil {
+		return nil, err
+	}
+
+	key := types.NamespacedName{
+		Namespace: chi.URLParam(r, "namespace"),
+		Name:      chi.URLParam(r, "name"),
+	}
+	paths, found := s.scaleSubresource(store)
+	if !found {
+		return nil, apierrors.NewNotFound(store.GVR.GroupResource(), key.Name+"/scale")
+	}
+
+	defer r.Body.Close() // nolint:errcheck
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	var scale autoscalingv1.Scale
+	if _, _, err := codec.Decode(data, &scaleGVK, &scale); err != nil {
+		return nil, err
+	}
+
+	return s.updateScale(store, key, paths, fieldManagerName(r, opts.FieldManager), func(*autoscalingv1.Scale) (*autoscalingv1.Scale, error) {
+		return &scale, nil
+	})
+}
+
+func (s *Server) PatchScale(w http.ResponseWriter, r *http.Request) {
+	store, err := s.Store(r)
+	if err != nil {
+		writeStatus(w, s.encoder(w, r), err)
+		return
+	}
+	codec, err := s.codec(w, r)
+	if err != nil {
+		writeStatus(w, s.encoder(w, r), err)
+		return
+	}
+
+	obj, err := s.PatchScaleImpl(store, codec, r)
+	if err != nil {
+		writeStatus(w, codec, err)
+		return
+	}
+	_ = codec.Encode(obj, w)
+}
+
+func (s *Server) PatchScaleImpl(store *APIStorage, codec runtime.Codec, r *http.Request) (runtime.Object, error) {
+	var opts metav1.PatchOptions
+	err := s.opts.ParameterCodec.DecodeParameters(r.URL.Query(), metav1.SchemeGroupVersion, &opts)
+	if err != nil {
+		return nil, err
+	}
+
+	key := types.NamespacedName{
+		Namespace: chi.URLParam(r, "namespace"),
+		Name:      chi.URLParam(r, "name"),
+	}
+	paths, found := s.scaleSubresource(store)
+	if !found {
+		return nil, apierrors.NewNotFound(store.GVR.GroupResource(), key.Name+"/scale")
+	}
+
+	defer r.Body.Close() // nolint:errcheck
+	patchBytes, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	patchType := types.PatchType(r.Header.Get("Content-Type"))
+
+	return s.updateScale(store, key, paths, fieldManagerName(r, opts.FieldManager), func(current *autoscalingv1.Scale) (*autoscalingv1.Scale, error) {
+		content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(current)
+		if err != nil {
+			return nil, err
+		}
+		currentObject := &unstructured.Unstructured{Object: content}
+		currentObjJS, err := runtime.Encode(unstructured.UnstructuredJSONScheme, currentObject)
+		if err != nil {
+			return nil, err
+		}
+
+		var patchedObj unstructured.Unstructured
+		err = s.applyJSPatch(codec, scaleGVK, patchType, currentObject, &patchedObj, currentObjJS, patchBytes, opts.FieldValidation)
+		if err != nil {
+			if isResourceVersionTestFailure(err) {
+				return nil, apierrors.NewConflict(store.GVR.GroupResource(), key.Name, errors.New(OptimisticLockErrorMsg))
+			}
+			return nil, err
+		}
+
+		var scale autoscalingv1.Scale
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(patchedObj.UnstructuredContent(), &scale); err != nil {
+			return nil, err
+		}
+		return &scale, nil
+	})
+}
+
+// updateScale sets the replicas of the object named key to the replicas of the scale returned by update
+// for the current scale of the object. The update is retried if the object was modified concurrently,
+// unless the returned scale has a stale resourceVersion.
+func (s *Server) updateScale(store *APIStorage, key types.NamespacedName, paths *apiextensionsv1.CustomResourceSubresourceScale, manager string, update func(current *autoscalingv1.Scale) (*autoscalingv1.Scale, error)) (runtime.Object, error) {
+	hasStatus := s.hasStatusSubresource(store)
+	for i := 0; ; i++ {
+		liveObj, exists, err := store.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, apierrors.NewNotFound(store.GVR.GroupResource(), key.Name)
+		}
+		current, err := scaleFromObject(liveObj, paths)
+		if err != nil {
+			return nil, err
+		}
+		scale, err := update(current)
+		if err != nil {
+			return nil, err
+		}
+		if err := validateScale(scale, key.Name); err != nil {
+			return nil, err
+		}
+
+		obj := liveObj.DeepCopy()
+		if err := unstructured.SetNestedField(obj.Object, int64(scale.Spec.Replicas), jsonPath(paths.SpecReplicasPath)...); err != nil {
+			return nil, err
+		}
+		rv := scale.ResourceVersion
+		if rv == "" {
+			rv = liveObj.GetResourceVersion()
+		}
+		obj.SetResourceVersion(rv)
+		if err := prepareForUpdate(store.GVR.GroupResource(), obj, liveObj, hasStatus); err != nil {
+			return nil, err
+		}
+		obj, err = s.updateManagedFields(store, liveObj, obj, manager, "scale")
+		if err != nil {
+			return nil, err
+		}
+
+		err = store.Update(obj, false)
+		if apierrors.IsConflict(err) && (scale.ResourceVersion == "" || scale.ResourceVersion == current.ResourceVersion) && i < maxRetryWhenPatchConflicts {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return scaleFromObject(obj, paths)
+	}
+}
+
+// validateScale validates the scale written for the object named name.
+func validateScale(scale *autoscalingv1.Scale, name string) error {
+	if scale.Name != "" && scale.Name != name {
+		return apierrors.NewBadRequest(fmt.Sprintf("the name of the object (%s) does not match the name on the URL (%s)", scale.Name, name))
+	}
+	if scale.Spec.Replicas < 0 {
+		return apierrors.NewInvalid(scaleGVK.GroupKind(), name, field.ErrorList{
+			field.Invalid(field.NewPath("spec", "replicas"), scale.Spec.Replicas, "must be greater than or equal to 0"),
+		})
+	}
+	return nil
+}
+
+// scaleSubresource returns the replicas and selector paths of the resource served by store,
+// if it has a scale subresource either as a built-in type or as a CRD version with subresources.scale.
+func (s *Server) scaleSubresource(store *APIStorage) (*apiextensionsv1.CustomResourceSubresourceScale, bool) {
+	if resources.HasScaleSubresource(store.GVR.GroupResource()) {
+		return &builtinScale, true
+	}
+	crd, found := s.crdFor(store.GVR.GroupResource())
+	if !found {
+		return nil, false
+	}
+	return crdScale(crd, store.GVK.Version)
+}
+
+// crdScale returns the scale subresource of version of crd.
+func crdScale(crd *apiextensionsv1.CustomResourceDefinition, version string) (*apiextensionsv1.CustomResourceSubresourceScale, bool) {
+	v, found := crdVersion(crd, version)
+	if !found || v.Subresources == nil || v.Subresources.Scale == nil {
+		return nil, false
+	}
+	return v.Subresources.Scale, true
+}
+
+// scaleFromObject returns the scale of obj using the replicas and selector paths of its resource.
+func scaleFromObject(obj *unstructured.Unstructured, paths *apiextensionsv1.CustomResourceSubresourceScale) (*autoscalingv1.Scale, error) {
+	specReplicas, _, err := unstructured.NestedInt64(obj.Object, jsonPath(paths.SpecReplicasPath)...)
+	if err != nil {
+		return nil, err
+	}
+	statusReplicas, _, err := unstructured.NestedInt64(obj.Object, jsonPath(paths.StatusReplicasPath)...)
+	if err != nil {
+		return nil, err
+	}
+
+	var selector string
+	if paths.LabelSelectorPath != nil {
+		selector, _, err = unstructured.NestedString(obj.Object, jsonPath(*paths.LabelSelectorPath)...)
+	} else {
+		selector, err = specSelector(obj)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &autoscalingv1.Scale{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: scaleGVK.GroupVersion().String(),
+			Kind:       scaleGVK.Kind,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              obj.GetName(),
+			Namespace:         obj.GetNamespace(),
+			UID:               obj.GetUID(),
+			ResourceVersion:   obj.GetResourceVersion(),
+			CreationTimestamp: obj.GetCreationTimestamp(),
+		},
+		Spec: autoscalingv1.ScaleSpec{
+			Replicas: int32(specReplicas),
+		},
+		Status: autoscalingv1.ScaleStatus{
+			Replicas: int32(statusReplicas),
+			Selector: selector,
+		},
+	}, nil
+}
+
+// specSelector returns spec.selector of a built-in workload in its string form.
+// ReplicationControllers select pods by a map of labels, the apps workloads by a label selector.
+func specSelector(obj *unstructured.Unstructured) (string, error) {
+	if obj.GetKind() == "ReplicationController" {
+		set, _, err := unstructured.NestedStringMap(obj.Object, "spec", "selector")
+		if err != nil {
+			return "", err
+		}
+		return labels.SelectorFromSet(set).String(), nil
+	}
+
+	content, found, err := unstructured.NestedMap(obj.Object, "spec", "selector")
+	if err != nil || !found {
+		return "", err
+	}
+	var ls metav1.LabelSelector
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(content, &ls); err != nil {
+		return "", err
+	}
+	sel, err := metav1.LabelSelectorAsSelector(&ls)
+	if err != nil {
+		return "", err
+	}
+	return sel.String(), nil
+}
+
+// jsonPath splits a simple JSON path like .spec.replicas into its fields.
+func jsonPath(path string) []string {
+	return strings.Split(strings.TrimPrefix(path, "."), ".")
+}
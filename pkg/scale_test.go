@@ -0,0 +1,166 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"context"
+	"testing"
+
+	apps "k8s.io/api/apps/v1"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	core "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+)
+
+const scalableWidgetCRD = `{
+	"apiVersion": "apiextensions.k8s.io/v1",
+	"kind": "CustomResourceDefinition",
+	"metadata": {"name": "widgets.example.com"},
+	"spec": {
+		"group": "example.com",
+		"scope": "Namespaced",
+		"names": {"plural": "widgets", "singular": "widget", "kind": "Widget", "listKind": "WidgetList"},
+		"versions": [{
+			"name": "v1", "served": true, "storage": true,
+			"schema": {"openAPIV3Schema": {"type": "object", "x-kubernetes-preserve-unknown-fields": true}},
+			"subresources": {"scale": {
+				"specReplicasPath": ".spec.size",
+				"statusReplicasPath": ".status.size",
+				"labelSelectorPath": ".status.selector"
+			}}
+		}]
+	}
+}`
+
+// testDeployment returns a Deployment of nginx pods in the default namespace.
+func testDeployment(name string, replicas int32) *apps.Deployment {
+	return &apps.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: metav1.NamespaceDefault},
+		Spec: apps.DeploymentSpec{
+			Replicas: ptr.To(replicas),
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": name}},
+			Template: core.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": name}},
+				Spec:       core.PodSpec{Containers: []core.Container{{Name: name, Image: "nginx"}}},
+			},
+		},
+	}
+}
+
+func TestDeploymentScale(t *testing.T) {
+	_, _, kc, _ := newTestCluster(t)
+	ctx := context.TODO()
+	deployments := kc.AppsV1().Deployments(metav1.NamespaceDefault)
+
+	deploy := testDeployment("web", 1)
+	if _, err := deployments.Create(ctx, deploy, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	scale, err := deployments.GetScale(ctx, "web", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scale.Spec.Replicas != 1 || scale.Status.Selector != "app=web" {
+		t.Errorf("expected 1 replica with selector app=web, got %d with %q", scale.Spec.Replicas, scale.Status.Selector)
+	}
+
+	stale := scale.DeepCopy()
+	scale.Spec.Replicas = 3
+	if _, err := deployments.UpdateScale(ctx, "web", scale, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := deployments.Get(ctx, "web", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got.Spec.Replicas != 3 {
+		t.Errorf("expected the deployment to be scaled to 3 replicas, got %d", *got.Spec.Replicas)
+	}
+
+	stale.Spec.Replicas = 5
+	if _, err := deployments.UpdateScale(ctx, "web", stale, metav1.UpdateOptions{}); !apierrors.IsConflict(err) {
+		t.Errorf("expected Conflict for a stale scale, got %v", err)
+	}
+	scale, err = deployments.GetScale(ctx, "web", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	scale.Spec.Replicas = -1
+	if _, err := deployments.UpdateScale(ctx, "web", scale, metav1.UpdateOptions{}); !apierrors.IsInvalid(err) {
+		t.Errorf("expected Invalid for negative replicas, got %v", err)
+	}
+}
+
+func TestCustomResourceScale(t *testing.T) {
+	_, _, _, dc := newTestCluster(t)
+	ctx := context.TODO()
+	createCRD(t, dc, scalableWidgetCRD)
+	widgets := dc.Resource(schema.GroupVersionResource{Group: "example.com", Version: "v1", Resource: "widgets"}).Namespace(metav1.NamespaceDefault)
+
+	obj := &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "example.com/v1",
+		"kind":       "Widget",
+		"metadata":   map[string]any{"name": "w1"},
+		"spec":       map[string]any{"size": int64(2)},
+		"status":     map[string]any{"size": int64(1), "selector": "app=w1"},
+	}}
+	if _, err := widgets.Create(ctx, obj, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	patch := []byte(`{"spec":{"replicas":4}}`)
+	if _, err := widgets.Patch(ctx, "w1", types.MergePatchType, patch, metav1.PatchOptions{}, "scale"); err != nil {
+		t.Fatal(err)
+	}
+	u, err := widgets.Get(ctx, "w1", metav1.GetOptions{}, "scale")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var scale autoscalingv1.Scale
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, &scale); err != nil {
+		t.Fatal(err)
+	}
+	if scale.Spec.Replicas != 4 || scale.Status.Replicas != 1 || scale.Status.Selector != "app=w1" {
+		t.Errorf("expected 4 replicas, 1 ready and selector app=w1, got %+v", scale)
+	}
+	got, err := widgets.Get(ctx, "w1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size, _, _ := unstructured.NestedInt64(got.Object, "spec", "size"); size != 4 {
+		t.Errorf("expected spec.size to be 4, got %d", size)
+	}
+}
+
+func TestScaleNotServed(t *testing.T) {
+	_, _, kc, _ := newTestCluster(t)
+	createConfigMap(t, kc, "cm")
+
+	err := kc.CoreV1().RESTClient().Get().
+		Namespace(metav1.NamespaceDefault).Resource("configmaps").Name("cm").SubResource("scale").
+		Do(context.TODO()).Error()
+	if !apierrors.IsNotFound(err) {
+		t.Errorf("expected NotFound for the scale of a configmap, got %v", err)
+	}
+}
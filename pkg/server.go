@@ -20,25 +20,34 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
 
+	"kmodules.xyz/fake-apiserver/pkg/resources"
+
+	"kmodules.xyz/apiversion"
+	kmapi "kmodules.xyz/client-go/api/v1"
 	meta_util "kmodules.xyz/client-go/meta"
 	rsapi "kmodules.xyz/resource-metadata/apis/meta/v1alpha1"
-	"kmodules.xyz/resource-metadata/hub"
 	"kmodules.xyz/resource-metadata/hub/resourcedescriptors"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	httpw "go.wandrs.dev/http"
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions"
 	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apiextensionsv1beta1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/managedfields"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/apiserver/pkg/endpoints/handlers/negotiation"
@@ -56,16 +65,38 @@ type Options struct {
 	// ParameterCodec performs conversions for query parameters passed to API calls
 	ParameterCodec   runtime.ParameterCodec
 	IncludeAPIGroups sets.Set[string]
+	// NewStorage creates the storage of a resource. The objects are kept in memory by default.
+	NewStorage func(config StorageConfig) Storage
+	// DataDir is the directory where the objects are kept across restarts. The objects
+	// are only kept in memory if it is empty.
+	DataDir string
 }
 
 type Server struct {
 	opts *Options
-	reg  *hub.Registry
+	// builtinResources are the served versions of the known resources, starting with their storage version
+	builtinResources map[schema.GroupResource][]kmapi.ResourceID
 
 	m               sync.Mutex
-	stores          map[schema.GroupResource]*APIStorage
+	stores          map[schema.GroupResource]*resourceStore
+	crdResources    map[schema.GroupResource][]kmapi.ResourceID
+	fieldManagers   map[fieldManagerKey]fieldManagerEntry
+	typeConverter   managedfields.TypeConverter
 	resourceVersion int64
 	checkedVersion  int64
+	// disk is set once the server is started with a data dir
+	disk        *dataDir
+	checkpoints map[string]*checkpoint
+	// restoring is held by Restore and shared by write requests, so that no write
+	// is lost while the objects of the server are replaced
+	restoring sync.RWMutex
+	// removedUIDs are the uids of removed objects that other objects still refer to, so that the garbage
+	// collector finds the dependents of an owner that was removed and then created again with the same name
+	removedUIDs sets.Set[types.UID]
+
+	openapi        openAPICache
+	webhookClients webhookClientCache
+	crdSchemas     crdSchemaCache
 }
 
 func NewOptions(fakeOpenShift bool, apigroups ...string) *Options {
@@ -79,8 +110,12 @@ func NewOptions(fakeOpenShift bool, apigroups ...string) *Options {
 	)
 
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(apiextensions.AddToScheme(scheme))
 	utilruntime.Must(apiextensionsv1.AddToScheme(scheme))
+	utilruntime.Must(apiextensionsv1beta1.AddToScheme(scheme))
+	utilruntime.Must(resources.AddConversionFuncs(scheme))
 	metav1.AddToGroupVersion(scheme, metav1.SchemeGroupVersion)
+	utilruntime.Must(metav1.AddMetaToScheme(scheme))
 
 	// TODO: keep the generic API server from wanting this
 	unversioned := schema.GroupVersion{Group: "", Version: "v1"}
@@ -101,6 +136,7 @@ func NewOptions(fakeOpenShift bool, apigroups ...string) *Options {
 		NegotiatedSerializer: codecs,
 		ParameterCodec:       parameterCodec,
 		IncludeAPIGroups:     includeAPIGroups,
+		NewStorage:           NewMemoryStorage,
 	}
 }
 
@@ -114,39 +150,122 @@ func NewServer(opts *Options) *Server {
 	}
 
 	return &Server{
-		opts:   opts,
-		reg:    hub.NewRegistry(hub.KnownUID, hub.NewKVMap(cache)),
-		stores: make(map[schema.GroupResource]*APIStorage),
+		opts:             opts,
+		builtinResources: builtinResources(cache, opts.Scheme),
+		stores:           make(map[schema.GroupResource]*resourceStore),
+		crdResources:     make(map[schema.GroupResource][]kmapi.ResourceID),
+		fieldManagers:    make(map[fieldManagerKey]fieldManagerEntry),
+		removedUIDs:      sets.New[types.UID](),
+		checkpoints:      make(map[string]*checkpoint),
 	}
 }
 
+// builtinResources returns the served versions of the resources of the given descriptors, starting with
+// the storage version, which is the latest version. A resource is also served at the versions of its kind
+// that are known to the scheme but have no descriptor, e.g. autoscaling/v2 HorizontalPodAutoscalers.
+// The older versions are only served if their objects can be converted from and to the storage version,
+// e.g. networking.k8s.io/v1beta1 Ingresses are not served, as they have other fields than v1 Ingresses.
+func builtinResources(descriptors map[string]*rsapi.ResourceDescriptor, scheme *runtime.Scheme) map[schema.GroupResource][]kmapi.ResourceID {
+	result := map[schema.GroupResource][]kmapi.ResourceID{}
+	byKind := map[schema.GroupKind][]schema.GroupResource{}
+	for _, rd := range descriptors {
+		rid := rd.Spec.Resource
+		if rid.Name == "" {
+			continue
+		}
+		gr := rid.GroupResource()
+		if len(result[gr]) == 0 {
+			byKind[rid.GroupVersionKind().GroupKind()] = append(byKind[rid.GroupVersionKind().GroupKind()], gr)
+		}
+		result[gr] = append(result[gr], rid)
+	}
+
+	for gvk := range scheme.AllKnownTypes() {
+		if gvk.Version == runtime.APIVersionInternal {
+			continue
+		}
+		for _, gr := range byKind[gvk.GroupKind()] {
+			rids := result[gr]
+			if slices.ContainsFunc(rids, func(rid kmapi.ResourceID) bool { return rid.Version == gvk.Version }) {
+				continue
+			}
+			rid := rids[0]
+			rid.Version = gvk.Version
+			result[gr] = append(rids, rid)
+		}
+	}
+
+	for gr, rids := range result {
+		sort.Slice(rids, func(i, j int) bool {
+			return apiversion.MustCompare(rids[i].Version, rids[j].Version) > 0
+		})
+		storage := rids[0].GroupVersionKind()
+		served := slices.DeleteFunc(rids[1:], func(rid kmapi.ResourceID) bool {
+			return !resources.Convertible(scheme, storage, rid.GroupVersionKind())
+		})
+		result[gr] = rids[:1+len(served)]
+	}
+	return result
+}
+
 func (s *Server) Register(m chi.Router) {
 	m.Get("/", s.APIRoot)
 	m.Get("/healthz", s.Healthz)
 	m.Get("/version", s.Version)
+	m.Get("/openapi/v2", s.OpenAPIV2)
+	m.Get("/openapi/v3", s.OpenAPIV3Discovery)
+	m.Get("/openapi/v3/*", s.OpenAPIV3GroupVersion)
+	m.Get("/snapshot", s.GetSnapshot)
+	m.Post("/restore", s.RestoreSnapshot)
+	m.Get("/checkpoints", s.ListCheckpoints)
+	m.Post("/checkpoints/{name}", s.CreateCheckpoint)
+	m.Delete("/checkpoints/{name}", s.DeleteCheckpoint)
+	m.Get("/checkpoints/{name}/diff", s.DiffCheckpoint)
+	m.Post("/checkpoints/{name}/rollback", s.RollbackCheckpoint)
 	m.Route("/api", func(m chi.Router) {
 		m.Get("/", s.APIVersions)
 		m.Get("/v1", s.APIResourceList)
 	})
+	m.Route("/api/v1/watch", func(m chi.Router) {
+		m.Get("/{resource}", s.Watch)
+		m.Get("/{resource}/{name}", s.Watch)
+		m.Get("/namespaces/{namespace}/{resource}", s.Watch)
+		m.Get("/namespaces/{namespace}/{resource}/{name}", s.Watch)
+	})
 	m.Route("/api/v1/{resource}", func(m chi.Router) {
+		m.Use(s.excludeRestore)
 		m.Post("/", s.Create)
 		m.Get("/", s.List)
 		m.Delete("/", s.DeleteCollection)
 		m.Get("/{name}", s.Get)
 		m.Put("/{name}", s.Update)
+		m.Get("/{name}/status", s.Get)
 		m.Put("/{name}/status", s.UpdateStatus)
 		m.Patch("/{name}/status", s.PatchStatus)
+		m.Get("/{name}/scale", s.GetScale)
+		m.Put("/{name}/scale", s.UpdateScale)
+		m.Patch("/{name}/scale", s.PatchScale)
 		m.Patch("/{name}", s.Patch)
 		m.Delete("/{name}", s.Delete)
 	})
+	// namespaces/{name}/status is shadowed by the routes of the namespaced resources
+	m.Get("/api/v1/namespaces/{namespace}/status", s.namespaceSubresource(s.Get))
+	m.With(s.excludeRestore).Put("/api/v1/namespaces/{namespace}/status", s.namespaceSubresource(s.UpdateStatus))
+	m.With(s.excludeRestore).Patch("/api/v1/namespaces/{namespace}/status", s.namespaceSubresource(s.PatchStatus))
+	m.With(s.excludeRestore).Put("/api/v1/namespaces/{namespace}/finalize", s.FinalizeNamespace)
 	m.Route("/api/v1/namespaces/{namespace}/{resource}", func(m chi.Router) {
+		m.Use(s.excludeRestore)
 		m.Post("/", s.Create)
 		m.Get("/", s.List)
 		m.Delete("/", s.DeleteCollection)
 		m.Get("/{name}", s.Get)
 		m.Put("/{name}", s.Update)
+		m.Get("/{name}/status", s.Get)
 		m.Put("/{name}/status", s.UpdateStatus)
 		m.Patch("/{name}/status", s.PatchStatus)
+		m.Get("/{name}/scale", s.GetScale)
+		m.Put("/{name}/scale", s.UpdateScale)
+		m.Patch("/{name}/scale", s.PatchScale)
 		m.Patch("/{name}", s.Patch)
 		m.Delete("/{name}", s.Delete)
 	})
@@ -156,52 +275,179 @@ func (s *Server) Register(m chi.Router) {
 		m.Get("/{group}", s.APIGroup)
 		m.Get("/{group}/{version}", s.APIResourceList)
 	})
+	m.Route("/apis/{group}/{version}/watch", func(m chi.Router) {
+		m.Get("/{resource}", s.Watch)
+		m.Get("/{resource}/{name}", s.Watch)
+		m.Get("/namespaces/{namespace}/{resource}", s.Watch)
+		m.Get("/namespaces/{namespace}/{resource}/{name}", s.Watch)
+	})
 	m.Route("/apis/{group}/{version}/{resource}", func(m chi.Router) {
+		m.Use(s.excludeRestore)
 		m.Post("/", s.Create)
 		m.Get("/", s.List)
 		m.Delete("/", s.DeleteCollection)
 		m.Get("/{name}", s.Get)
 		m.Put("/{name}", s.Update)
+		m.Get("/{name}/status", s.Get)
 		m.Put("/{name}/status", s.UpdateStatus)
 		m.Patch("/{name}/status", s.PatchStatus)
+		m.Get("/{name}/scale", s.GetScale)
+		m.Put("/{name}/scale", s.UpdateScale)
+		m.Patch("/{name}/scale", s.PatchScale)
 		m.Patch("/{name}", s.Patch)
 		m.Delete("/{name}", s.Delete)
 	})
 	m.Route("/apis/{group}/{version}/namespaces/{namespace}/{resource}", func(m chi.Router) {
+		m.Use(s.excludeRestore)
 		m.Post("/", s.Create)
 		m.Get("/", s.List)
 		m.Delete("/", s.DeleteCollection)
 		m.Get("/{name}", s.Get)
 		m.Put("/{name}", s.Update)
+		m.Get("/{name}/status", s.Get)
 		m.Put("/{name}/status", s.UpdateStatus)
 		m.Patch("/{name}/status", s.PatchStatus)
+		m.Get("/{name}/scale", s.GetScale)
+		m.Put("/{name}/scale", s.UpdateScale)
+		m.Patch("/{name}/scale", s.PatchScale)
 		m.Patch("/{name}", s.Patch)
 		m.Delete("/{name}", s.Delete)
 	})
 }
 
-func (s *Server) encoder(w http.ResponseWriter, r *http.Request) runtime.Encoder {
-	outputMediaType, _, err := negotiation.NegotiateOutputMediaType(r, OutputSerializer{delegate: s.opts.NegotiatedSerializer}, negotiation.DefaultEndpointRestrictions)
+// excludeRestore keeps write requests from running while the objects of the server are restored.
+// Reads and watches are not blocked, they see the objects before or after the restore.
+func (s *Server) excludeRestore(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			s.restoring.RLock()
+			defer s.restoring.RUnlock()
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// namespaceSubresource serves a subresource of the namespace named by the namespace URL parameter.
+func (s *Server) namespaceSubresource(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rctx := chi.RouteContext(r.Context())
+		name := chi.URLParam(r, "namespace")
+		// later params take precedence
+		rctx.URLParams.Add("namespace", "")
+		rctx.URLParams.Add("resource", "namespaces")
+		rctx.URLParams.Add("name", name)
+		h(w, r)
+	}
+}
+
+func (s *Server) outputSerializer() OutputSerializer {
+	return OutputSerializer{delegate: s.opts.NegotiatedSerializer, scheme: s.opts.Scheme}
+}
+
+// restrictions returns the media types and transformations allowed for r.
+// Like the apiextensions-apiserver, custom resources are not served as protobuf.
+func (s *Server) restrictions(r *http.Request) transformRestrictions {
+	if chi.URLParam(r, "resource") == "" {
+		return transformRestrictions{protobuf: true}
+	}
+	store, err := s.Store(r)
 	if err != nil {
-		panic(err)
+		// the request fails before anything is encoded but the status
+		return transformRestrictions{protobuf: true}
+	}
+	return transformRestrictions{protobuf: s.isBuiltinType(store.GVK)}
+}
+
+// isBuiltinType returns true if gvk is a Kubernetes type that can be converted to its typed form.
+func (s *Server) isBuiltinType(gvk schema.GroupVersionKind) bool {
+	return clientgoscheme.Scheme.Recognizes(gvk) || gvk.GroupVersion() == apiextensionsv1.SchemeGroupVersion
+}
+
+// negotiateEncoder returns the encoder for the media type accepted by r.
+// It returns a NotAcceptable error if none of the accepted media types can be served.
+func (s *Server) negotiateEncoder(w http.ResponseWriter, r *http.Request) (runtime.Encoder, error) {
+	outputMediaType, _, err := negotiation.NegotiateOutputMediaType(r, s.outputSerializer(), s.restrictions(r))
+	if err != nil {
+		return nil, err
 	}
 	w.Header().Set("Content-Type", outputMediaType.Accepted.MediaType)
-	return outputMediaType.Accepted.Serializer
+	return outputMediaType.Accepted.Serializer, nil
+}
+
+// encoder returns the encoder for the media type accepted by r. Like the apiserver, it falls back to
+// JSON if none of the accepted media types can be served, so it is safe to use for writing errors.
+func (s *Server) encoder(w http.ResponseWriter, r *http.Request) runtime.Encoder {
+	encoder, err := s.negotiateEncoder(w, r)
+	if err != nil {
+		info, _ := runtime.SerializerInfoForMediaType(s.opts.NegotiatedSerializer.SupportedMediaTypes(), runtime.ContentTypeJSON)
+		w.Header().Set("Content-Type", runtime.ContentTypeJSON)
+		return info.Serializer
+	}
+	return encoder
 }
 
-func (s *Server) decoder(r *http.Request) runtime.Decoder {
+func writeStatus(w http.ResponseWriter, encoder runtime.Encoder, err error) {
+	status := httpw.ErrorToAPIStatus(err)
+	w.WriteHeader(int(status.Code))
+	_ = encoder.Encode(status, w)
+}
+
+// decoder returns the decoder for the content type of r.
+// It returns an UnsupportedMediaType error if the content type can not be decoded.
+func (s *Server) decoder(r *http.Request) (runtime.Decoder, error) {
 	info, err := NegotiateInputSerializer(r, false, s.opts.NegotiatedSerializer)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	return info.Serializer
+	return info.Serializer, nil
 }
 
-func (s *Server) codec(w http.ResponseWriter, r *http.Request) runtime.Codec {
-	return runtime.NewCodec(
-		s.encoder(w, r),
-		s.decoder(r),
-	)
+// codec returns the codec for r. If the media types of r can not be served, the error should be written
+// with writeStatus and the fallback encoder of the server.
+func (s *Server) codec(w http.ResponseWriter, r *http.Request) (runtime.Codec, error) {
+	encoder, err := s.negotiateEncoder(w, r)
+	if err != nil {
+		return nil, err
+	}
+	decoder, err := s.decoder(r)
+	if err != nil {
+		return nil, err
+	}
+	return runtime.NewCodec(encoder, decoder), nil
+}
+
+// decodeObject decodes the request body into an unstructured object of the kind served by store.
+func (s *Server) decodeObject(store *APIStorage, codec runtime.Codec, data []byte) (*unstructured.Unstructured, error) {
+	isOfficialType := s.isBuiltinType(store.GVK)
+
+	var into runtime.Object
+	if !isOfficialType {
+		if isProtobuf(data) {
+			return nil, negotiation.NewUnsupportedMediaTypeError([]string{runtime.ContentTypeJSON, runtime.ContentTypeYAML})
+		}
+		var u unstructured.Unstructured
+		u.SetGroupVersionKind(store.GVK)
+		into = &u
+	}
+	o2, _, err := codec.Decode(data, &store.GVK, into)
+	if err != nil {
+		return nil, err
+	}
+
+	var obj unstructured.Unstructured
+	if isOfficialType {
+		content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(o2)
+		if err != nil {
+			return nil, err
+		}
+
+		// protobuf messages do not carry the TypeMeta of the object
+		obj.SetUnstructuredContent(content)
+		obj.SetGroupVersionKind(store.GVK)
+	} else {
+		obj = *into.(*unstructured.Unstructured)
+	}
+	return &obj, nil
 }
 
 // NegotiateInputSerializer returns the input serializer for the provided request.
@@ -225,7 +471,7 @@ func NegotiateInputSerializer(req *http.Request, streaming bool, ns runtime.Nego
 	return negotiation.NegotiateInputSerializerForMediaType(mediaType, streaming, ns)
 }
 
-func (s *Server) Store(r *http.Request) *APIStorage {
+func (s *Server) Store(r *http.Request) (*APIStorage, error) {
 	gvr := schema.GroupVersionResource{
 		Group:    chi.URLParam(r, "group"),
 		Version:  chi.URLParam(r, "version"),
@@ -244,31 +490,95 @@ func (s *Server) Store(r *http.Request) *APIStorage {
 	return s.StoreForGVR(gvr)
 }
 
-func (s *Server) StoreForGVR(gvr schema.GroupVersionResource) *APIStorage {
+// StoreForGVR returns the storage of version gvr. All versions of a resource share the objects of one store.
+// It returns a NotFound error if gvr is not served, e.g. the resource of a CRD that does not exist yet.
+func (s *Server) StoreForGVR(gvr schema.GroupVersionResource) (*APIStorage, error) {
 	s.m.Lock()
 	defer s.m.Unlock()
 
-	gvk, _ := s.reg.GVK(gvr)
-	namespaced, _ := s.reg.IsGVRNamespaced(gvr)
-
+	rid, known := s.resourceFor(gvr)
+	if !known {
+		return nil, apierrors.NewGenericServerResponse(http.StatusNotFound, "", gvr.GroupResource(), "", "", 0, false)
+	}
 	store, found := s.stores[gvr.GroupResource()]
 	if !found {
-		store = &APIStorage{
-			s:          s,
-			GVR:        gvr,
-			GVK:        gvk,
-			Namespaced: namespaced,
-			Current:    make(map[types.NamespacedName]*unstructured.Unstructured),
-			Deleted:    make(map[types.NamespacedName]*unstructured.Unstructured),
+		// the store is created at the storage version of the resource
+		storage := s.servedResources(gvr.GroupResource())[0]
+		config := StorageConfig{
+			GVR:        storage.GroupVersionResource(),
+			GVK:        storage.GroupVersionKind(),
+			Namespaced: rid.Scope == kmapi.NamespaceScoped,
+			Versioner:  s,
+			OnChange:   s.onStorageChange(storage.GroupVersionResource().GroupResource(), storage.GroupVersionKind()),
+		}
+		newStorage := s.opts.NewStorage
+		if newStorage == nil {
+			newStorage = NewMemoryStorage
+		}
+		store = &resourceStore{
+			Storage:    newStorage(config),
+			GVR:        config.GVR,
+			GVK:        config.GVK,
+			Namespaced: config.Namespaced,
 		}
 		s.stores[gvr.GroupResource()] = store
 	}
+	return &APIStorage{
+		resourceStore: store,
+		s:             s,
+		GVR:           gvr,
+		GVK:           rid.GroupVersionKind(),
+	}, nil
+}
+
+// builtinStore returns the storage of version gvr of a built-in resource, which is always served.
+func (s *Server) builtinStore(gvr schema.GroupVersionResource) *APIStorage {
+	store, err := s.StoreForGVR(gvr)
+	if err != nil {
+		panic(err)
+	}
 	return store
 }
 
+// allStores returns the stores of all resources.
+func (s *Server) allStores() []*resourceStore {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	stores := make([]*resourceStore, 0, len(s.stores))
+	for _, store := range s.stores {
+		stores = append(stores, store)
+	}
+	return stores
+}
+
+// onStorageChange returns the function called by the storage of resource gr on every change.
+// The uids of removed objects are kept for the garbage collector, the served versions of the
+// custom resources are updated with their CRDs, and all changes are written to the data dir.
+func (s *Server) onStorageChange(gr schema.GroupResource, gvk schema.GroupVersionKind) func(obj *unstructured.Unstructured, removed bool) {
+	return func(obj *unstructured.Unstructured, removed bool) {
+		if removed {
+			s.m.Lock()
+			s.removedUIDs.Insert(obj.GetUID())
+			s.m.Unlock()
+		}
+		if gvk == crdGVK {
+			s.updateCRDResources(obj, removed)
+		}
+		s.persist(gr, obj, removed)
+	}
+}
+
 // https://levelup.gitconnected.com/listening-to-random-available-port-in-go-3541dddbb0c5
 // https://medium.com/honestbee-tw-engineer/gracefully-shutdown-in-go-http-server-5f5e6b83da5a
+// The objects kept in the data dir, if any, are loaded before the server starts listening.
 func (s *Server) Run() (*http.Server, *rest.Config, error) {
+	if s.opts.DataDir != "" {
+		if err := s.openDataDir(s.opts.DataDir); err != nil {
+			return nil, nil, err
+		}
+	}
+
 	m := chi.NewRouter()
 	m.Use(middleware.RequestID)
 	m.Use(middleware.RealIP)
@@ -302,27 +612,44 @@ func (s *Server) Checkpoint() {
 	defer s.m.Unlock()
 
 	s.checkedVersion = s.resourceVersion
+	if s.disk != nil {
+		if err := s.disk.SaveCheckpointVersion(s.checkedVersion); err != nil {
+			klog.Errorln("failed to save checkpoint", err)
+		}
+	}
 }
 
 func (s *Server) NextResourceVersion() int64 {
 	s.m.Lock()
 	defer s.m.Unlock()
 
-	result := s.resourceVersion
 	s.resourceVersion++
-	return result
+	return s.resourceVersion
 }
 
-func (s *Server) Export() ([]unstructured.Unstructured, []unstructured.Unstructured) {
+func (s *Server) CurrentResourceVersion() int64 {
 	s.m.Lock()
 	defer s.m.Unlock()
 
-	current := make([]unstructured.Unstructured, 0, len(s.stores))
-	deleted := make([]unstructured.Unstructured, 0, len(s.stores))
+	return s.resourceVersion
+}
 
-	for _, store := range s.stores {
-		current = append(current, getDirtyObjects(store.Current, s.checkedVersion)...)
-		deleted = append(deleted, getDirtyObjects(store.Deleted, s.checkedVersion)...)
+func (s *Server) Export() ([]unstructured.Unstructured, []unstructured.Unstructured) {
+	stores := s.allStores()
+	s.m.Lock()
+	checkedVersion := s.checkedVersion
+	s.m.Unlock()
+
+	current := make([]unstructured.Unstructured, 0, len(stores))
+	deleted := make([]unstructured.Unstructured, 0, len(stores))
+
+	for _, store := range stores {
+		objs, _, err := store.List(0)
+		if err != nil {
+			klog.Errorln("failed to list", store.GVR.GroupResource(), err)
+		}
+		current = append(current, getDirtyObjects(objs, checkedVersion)...)
+		deleted = append(deleted, getDirtyObjects(store.Deleted(), checkedVersion)...)
 	}
 
 	sort.Slice(current, func(i, j int) bool {
@@ -332,46 +659,39 @@ func (s *Server) Export() ([]unstructured.Unstructured, []unstructured.Unstructu
 	return current, deleted
 }
 
-func getDirtyObjects(in map[types.NamespacedName]*unstructured.Unstructured, checkedVersion int64) []unstructured.Unstructured {
+func getDirtyObjects(in []*unstructured.Unstructured, checkedVersion int64) []unstructured.Unstructured {
 	out := make([]unstructured.Unstructured, 0, len(in))
 	for _, obj := range in {
 		rv, _ := strconv.ParseInt(obj.GetResourceVersion(), 10, 64)
-		if rv >= checkedVersion {
+		if rv > checkedVersion {
 			out = append(out, *obj)
 		}
 	}
 	return out
 }
 
-func (s *Server) RemoveNamespace(ns string) {
-	s.m.Lock()
-	defer s.m.Unlock()
-
-	for _, store := range s.stores {
-		if store.Namespaced {
-			store.RemoveForNamespace(ns)
-		}
-	}
-}
-
 func atoi(s string) int {
 	i, _ := strconv.Atoi(s)
 	return i
 }
 
+// OutputSerializer encodes the unstructured objects kept by the server. Objects are
+// converted to their typed form before they are written as protobuf.
 type OutputSerializer struct {
 	delegate runtime.NegotiatedSerializer
+	scheme   *runtime.Scheme
 }
 
 var _ runtime.NegotiatedSerializer = &OutputSerializer{}
 
 func (o OutputSerializer) SupportedMediaTypes() []runtime.SerializerInfo {
 	a := o.delegate.SupportedMediaTypes()
-	b := a[:0]
+	b := make([]runtime.SerializerInfo, 0, len(a))
 	for _, x := range a {
-		if x.MediaType != runtime.ContentTypeProtobuf {
-			b = append(b, x)
+		if x.MediaType == runtime.ContentTypeProtobuf {
+			x.Serializer = protobufSerializer{Serializer: x.Serializer, scheme: o.scheme}
 		}
+		b = append(b, x)
 	}
 	return b
 }
@@ -0,0 +1,139 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"kmodules.xyz/fake-apiserver/pkg/resources"
+
+	"github.com/go-chi/chi/v5"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	driversapi "x-helm.dev/apimachinery/apis/drivers/v1alpha1"
+)
+
+// newTestServer starts a fake apiserver for the duration of the test and
+// returns it with a config for talking to it.
+func newTestServer(t *testing.T, opts *Options) (*Server, *rest.Config) {
+	t.Helper()
+
+	if opts == nil {
+		opts = NewOptions(false)
+	}
+	s := NewServer(opts)
+	if opts.DataDir != "" {
+		if err := s.openDataDir(opts.DataDir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m := chi.NewRouter()
+	s.Register(m)
+	ts := httptest.NewServer(m)
+	t.Cleanup(ts.Close)
+
+	cfg := &rest.Config{
+		Host: ts.URL,
+		ContentConfig: rest.ContentConfig{
+			AcceptContentTypes: runtime.ContentTypeJSON,
+		},
+		// the fake apiserver is local, do not throttle the clients
+		QPS:   -1,
+		Burst: -1,
+	}
+	return s, cfg
+}
+
+// newTestCluster starts a fake apiserver with the default namespaces.
+func newTestCluster(t *testing.T) (*Server, *rest.Config, kubernetes.Interface, dynamic.Interface) {
+	t.Helper()
+
+	s, cfg := newTestServer(t, nil)
+	if err := resources.InitCluster(cfg); err != nil {
+		t.Fatal(err)
+	}
+	return s, cfg, kubernetes.NewForConfigOrDie(cfg), dynamic.NewForConfigOrDie(cfg)
+}
+
+func TestNotAcceptable(t *testing.T) {
+	_, cfg := newTestServer(t, NewOptions(false, driversapi.GroupVersion.Group))
+
+	// like the apiextensions-apiserver, custom resources are not served as protobuf
+	req, err := http.NewRequest(http.MethodGet, cfg.Host+"/apis/drivers.x-helm.dev/v1alpha1/namespaces/default/appreleases", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", runtime.ContentTypeProtobuf)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	if resp.StatusCode != http.StatusNotAcceptable {
+		t.Errorf("expected status %d, got %d", http.StatusNotAcceptable, resp.StatusCode)
+	}
+	var status metav1.Status
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		t.Fatalf("expected a JSON status: %v", err)
+	}
+	if status.Reason != metav1.StatusReasonNotAcceptable {
+		t.Errorf("expected reason %s, got %s", metav1.StatusReasonNotAcceptable, status.Reason)
+	}
+}
+
+// createCRD creates a CustomResourceDefinition from its JSON manifest.
+func createCRD(t *testing.T, dc dynamic.Interface, manifest string) {
+	t.Helper()
+
+	var obj unstructured.Unstructured
+	if err := json.Unmarshal([]byte(manifest), &obj.Object); err != nil {
+		t.Fatal(err)
+	}
+	crds := dc.Resource(apiextensionsv1.SchemeGroupVersion.WithResource("customresourcedefinitions"))
+	if _, err := crds.Create(context.TODO(), &obj, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUnknownResource(t *testing.T) {
+	_, _, _, dc := newTestCluster(t)
+	ctx := context.TODO()
+	widgets := dc.Resource(schema.GroupVersionResource{Group: "example.com", Version: "v1", Resource: "widgets"}).Namespace(metav1.NamespaceDefault)
+
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion("example.com/v1")
+	obj.SetKind("Widget")
+	obj.SetName("w1")
+	if _, err := widgets.Create(ctx, obj, metav1.CreateOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("expected NotFound when creating an undefined resource, got %v", err)
+	}
+	if _, err := widgets.List(ctx, metav1.ListOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("expected NotFound when listing an undefined resource, got %v", err)
+	}
+}
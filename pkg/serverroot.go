@@ -21,7 +21,7 @@ import (
 	"fmt"
 	"net/http"
 
-	"kmodules.xyz/resource-metadata/apis/meta/v1alpha1"
+	kmapi "kmodules.xyz/client-go/api/v1"
 
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/sets"
@@ -37,14 +37,10 @@ func (s *Server) APIRoot(w http.ResponseWriter, r *http.Request) {
 		"/healthz",
 		"/version",
 	)
-	s.reg.Visit(func(_ string, rd *v1alpha1.ResourceDescriptor) {
-		if rd.Spec.Resource.Name == "" {
-			return
-		}
-
-		if rd.Spec.Resource.Group != "" {
-			paths.Insert(fmt.Sprintf("/apis/%s", rd.Spec.Resource.Group))
-			paths.Insert(fmt.Sprintf("/apis/%s/%s", rd.Spec.Resource.Group, rd.Spec.Resource.Version))
+	s.visitResources(func(rid kmapi.ResourceID) {
+		if rid.Group != "" {
+			paths.Insert(fmt.Sprintf("/apis/%s", rid.Group))
+			paths.Insert(fmt.Sprintf("/apis/%s/%s", rid.Group, rid.Version))
 		}
 	})
 
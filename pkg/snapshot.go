@@ -0,0 +1,227 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	kmapi "kmodules.xyz/client-go/api/v1"
+
+	"github.com/munnerz/goautoneg"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/yaml"
+)
+
+// Snapshot is the state of the server: the current objects of every resource, kept at their
+// storage version with their uids and resourceVersions, and the resourceVersion of the server.
+type Snapshot struct {
+	ResourceVersion int64 `json:"resourceVersion"`
+	// CheckpointVersion is the resourceVersion of the last checkpoint, see Server.Export.
+	CheckpointVersion int64              `json:"checkpointVersion,omitempty"`
+	Resources         []ResourceSnapshot `json:"resources,omitempty"`
+}
+
+// ResourceSnapshot is the list of objects of a resource, sorted by namespace and name.
+type ResourceSnapshot struct {
+	Group    string                       `json:"group,omitempty"`
+	Resource string                       `json:"resource"`
+	Items    []*unstructured.Unstructured `json:"items"`
+}
+
+func (r ResourceSnapshot) GroupResource() schema.GroupResource {
+	return schema.GroupResource{Group: r.Group, Resource: r.Resource}
+}
+
+// Snapshot returns the current objects of the server. Every resource is read at once,
+// but writes to other resources may happen while the snapshot is taken.
+func (s *Server) Snapshot() *Snapshot {
+	stores := s.allStores()
+	s.m.Lock()
+	checkpoint := s.checkedVersion
+	s.m.Unlock()
+
+	var snap Snapshot
+	for _, store := range stores {
+		objs, _, err := store.List(0)
+		if err != nil || len(objs) == 0 {
+			continue
+		}
+		snap.Resources = append(snap.Resources, ResourceSnapshot{
+			Group:    store.GVR.Group,
+			Resource: store.GVR.Resource,
+			Items:    objs,
+		})
+	}
+	sort.Slice(snap.Resources, func(i, j int) bool {
+		return snap.Resources[i].GroupResource().String() < snap.Resources[j].GroupResource().String()
+	})
+	// read last, so that it is not older than any of the objects
+	snap.ResourceVersion = s.CurrentResourceVersion()
+	snap.CheckpointVersion = checkpoint
+	return &snap
+}
+
+// Restore replaces all objects of the server with the objects of snap. Running watches are
+// terminated and the earlier resourceVersions expire, so that clients relist.
+func (s *Server) Restore(snap *Snapshot) error {
+	crdGR := schema.GroupResource{Group: crdGVK.Group, Resource: "customresourcedefinitions"}
+
+	// custom resources are only known once their CRD is restored
+	defined := sets.New[schema.GroupResource]()
+	rv := snap.ResourceVersion
+	for _, rs := range snap.Resources {
+		for _, obj := range rs.Items {
+			if obj.GetName() == "" {
+				return apierrors.NewBadRequest(fmt.Sprintf("object of %s without name", rs.GroupResource()))
+			}
+			if objRV := int64(atoi(obj.GetResourceVersion())); objRV > rv {
+				rv = objRV
+			}
+			if rs.GroupResource() != crdGR {
+				continue
+			}
+			var crd apiextensionsv1.CustomResourceDefinition
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.UnstructuredContent(), &crd); err != nil {
+				return apierrors.NewBadRequest(fmt.Sprintf("invalid CustomResourceDefinition %s: %v", obj.GetName(), err))
+			}
+			defined.Insert(schema.GroupResource{Group: crd.Spec.Group, Resource: crd.Spec.Names.Plural})
+		}
+	}
+	for _, rs := range snap.Resources {
+		if _, found := s.builtinResources[rs.GroupResource()]; !found && !defined.Has(rs.GroupResource()) {
+			return apierrors.NewBadRequest(fmt.Sprintf("unknown resource %s", rs.GroupResource()))
+		}
+	}
+
+	s.restoring.Lock()
+	defer s.restoring.Unlock()
+
+	s.m.Lock()
+	stores := s.stores
+	s.stores = make(map[schema.GroupResource]*resourceStore)
+	s.crdResources = make(map[schema.GroupResource][]kmapi.ResourceID)
+	// the resourceVersion never goes back, so that clients do not take new changes for ones they have seen
+	rv = max(rv, s.resourceVersion)
+	s.resourceVersion = rv
+	s.checkedVersion = snap.CheckpointVersion
+	s.m.Unlock()
+
+	for _, store := range stores {
+		store.Close()
+	}
+
+	ordered := append([]ResourceSnapshot(nil), snap.Resources...)
+	// CRDs are restored first, so that the stores of custom resources are created with their kind and scope
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].GroupResource() == crdGR && ordered[j].GroupResource() != crdGR
+	})
+	for _, rs := range ordered {
+		s.m.Lock()
+		rids := s.servedResources(rs.GroupResource())
+		s.m.Unlock()
+		if len(rids) == 0 {
+			// the CRD of the resource is not served
+			continue
+		}
+		objs := make([]*unstructured.Unstructured, 0, len(rs.Items))
+		for _, obj := range rs.Items {
+			objs = append(objs, obj.DeepCopy())
+		}
+		store, err := s.StoreForGVR(rids[0].GroupVersionResource())
+		if err != nil {
+			return err
+		}
+		store.Load(objs, rv)
+		if rs.GroupResource() == crdGR {
+			for _, obj := range objs {
+				s.updateCRDResources(obj, false)
+			}
+		}
+	}
+
+	if s.disk != nil {
+		return s.disk.Reset(s.Snapshot())
+	}
+	return nil
+}
+
+// GetSnapshot writes the snapshot of the server as JSON, or as YAML if it is preferred by the client.
+func (s *Server) GetSnapshot(w http.ResponseWriter, r *http.Request) {
+	data, err := yaml.Marshal(s.Snapshot())
+	if err != nil {
+		writeStatus(w, s.encoder(w, r), err)
+		return
+	}
+	contentType := runtime.ContentTypeYAML
+	if !acceptsYAML(r) {
+		contentType = runtime.ContentTypeJSON
+		if data, err = yaml.YAMLToJSON(data); err != nil {
+			writeStatus(w, s.encoder(w, r), err)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", contentType)
+	_, _ = w.Write(data)
+}
+
+func acceptsYAML(r *http.Request) bool {
+	for _, accept := range goautoneg.ParseAccept(r.Header.Get("Accept")) {
+		switch accept.Type + "/" + accept.SubType {
+		case runtime.ContentTypeYAML:
+			return true
+		case runtime.ContentTypeJSON, "*/*":
+			return false
+		}
+	}
+	return false
+}
+
+// RestoreSnapshot replaces the objects of the server with the snapshot in the request body, in JSON or YAML.
+func (s *Server) RestoreSnapshot(w http.ResponseWriter, r *http.Request) {
+	encoder := s.encoder(w, r)
+
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeStatus(w, encoder, err)
+		return
+	}
+	var snap Snapshot
+	if err := yaml.UnmarshalStrict(data, &snap); err != nil {
+		writeStatus(w, encoder, apierrors.NewBadRequest(strings.TrimPrefix(err.Error(), "error unmarshaling JSON: ")))
+		return
+	}
+	if err := s.Restore(&snap); err != nil {
+		writeStatus(w, encoder, err)
+		return
+	}
+	_ = encoder.Encode(&metav1.Status{
+		TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"},
+		Status:   metav1.StatusSuccess,
+		Code:     http.StatusOK,
+		Message:  fmt.Sprintf("restored resourceVersion %d", s.CurrentResourceVersion()),
+	}, w)
+}
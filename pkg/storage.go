@@ -18,103 +18,240 @@ package pkg
 
 import (
 	"fmt"
-	"sync"
 
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
 )
 
-type APIStorage struct {
-	m sync.RWMutex
+// OptimisticLockErrorMsg is the message returned by the apiserver when an update uses a stale resourceVersion.
+const OptimisticLockErrorMsg = "the object has been modified; please apply your changes to the latest version and try again"
+
+// Storage keeps the objects of a resource at its storage version, with the history needed to serve
+// resourceVersions. Every change gets the next resourceVersion from StorageConfig.Versioner, and is
+// reported to StorageConfig.OnChange before it is sent to the watchers.
+type Storage interface {
+	// Get returns the current object with key, or false if it does not exist.
+	Get(key types.NamespacedName) (*unstructured.Unstructured, bool)
+	// List returns the objects as of resourceVersion rv sorted by namespace and name, and the resourceVersion
+	// of the returned snapshot. The current objects are returned if rv is 0. A Timeout error is returned if
+	// rv is newer than the current resourceVersion, and a ResourceExpired error if rv is no longer available.
+	List(rv int64) ([]*unstructured.Unstructured, int64, error)
+	// Deleted returns the final state of the removed objects.
+	Deleted() []*unstructured.Unstructured
+
+	// Create stores obj if no object with the same namespace and name exists.
+	Create(obj *unstructured.Unstructured) error
+	// Update stores obj if its resourceVersion is empty or matches the resourceVersion of the stored object.
+	// A missing object is only created if allowCreate is true and obj has no resourceVersion.
+	// An object marked for deletion is removed once its finalizers are cleared.
+	Update(obj *unstructured.Unstructured, allowCreate bool) error
+	// UpdateFunc stores the result of fn applied to a copy of the object with the given key and uid.
+	UpdateFunc(key types.NamespacedName, uid types.UID, fn func(obj *unstructured.Unstructured)) error
+	// Delete removes the object only if its uid and resourceVersion match the preconditions.
+	// The given finalizers are added to the object and an object with finalizers is only marked
+	// for deletion with a deletionTimestamp. It is removed once all of its finalizers are gone.
+	// Delete returns true if the object was removed.
+	Delete(key types.NamespacedName, preconditions *metav1.Preconditions, finalizers []string) (*unstructured.Unstructured, bool, error)
+
+	// Watch registers a new watcher. It returns the events to be sent before any new event,
+	// either the current objects as synthetic ADDED events or the events after rv, and the
+	// resourceVersion these events are current up to.
+	Watch(rv int64, sendInitialEvents bool) (Watcher, []StoreEvent, int64, error)
+
+	// Load adds the objects of a snapshot, keeping their resourceVersions. The history
+	// before the snapshot is not known, so older resourceVersions can not be served.
+	Load(objs []*unstructured.Unstructured, rv int64)
+	// Close terminates all watchers. The storage is not used once it is closed.
+	Close()
+}
 
-	s *Server
+// Watcher receives the changes of a Storage.
+type Watcher interface {
+	// ResultChan returns the events of the watcher. It is closed once the watcher is stopped,
+	// or if the watcher falls behind, so that the client relists.
+	ResultChan() <-chan StoreEvent
+	// Bookmark queues a BOOKMARK event for the current resourceVersion behind any pending event.
+	Bookmark()
+	Stop()
+}
+
+// StoreEvent is a change of an object. Prev is the previous state of the object, if any.
+type StoreEvent struct {
+	Type            watch.EventType
+	Object          *unstructured.Unstructured
+	Prev            *unstructured.Unstructured
+	ResourceVersion int64
+}
 
+// StorageConfig describes the resource kept by a Storage.
+type StorageConfig struct {
+	// GVR and GVK are the storage version of the resource.
 	GVR        schema.GroupVersionResource
 	GVK        schema.GroupVersionKind
 	Namespaced bool
-	Current    map[types.NamespacedName]*unstructured.Unstructured
-	Deleted    map[types.NamespacedName]*unstructured.Unstructured
+	// Versioner hands out the resourceVersions, which are shared by all resources of the server.
+	Versioner ResourceVersioner
+	// OnChange is called with every stored or removed object, with the changes of an object in order.
+	OnChange func(obj *unstructured.Unstructured, removed bool)
 }
 
-func (s *APIStorage) Items() []unstructured.Unstructured {
-	s.m.RLock()
-	defer s.m.RUnlock()
-
-	result := make([]unstructured.Unstructured, 0, len(s.Current))
-	for _, obj := range s.Current {
-		result = append(result, *obj)
-	}
-	return result
+type ResourceVersioner interface {
+	NextResourceVersion() int64
+	CurrentResourceVersion() int64
 }
 
-func (s *APIStorage) Get(key types.NamespacedName) (*unstructured.Unstructured, bool) {
-	s.m.RLock()
-	defer s.m.RUnlock()
+// resourceStore is the Storage of a resource, created at the storage version of the resource.
+type resourceStore struct {
+	Storage
 
-	obj, found := s.Current[key]
-	return obj, found
+	GVR        schema.GroupVersionResource
+	GVK        schema.GroupVersionKind
+	Namespaced bool
 }
 
-func (s *APIStorage) Insert(obj *unstructured.Unstructured) {
-	s.m.Lock()
-	defer s.m.Unlock()
+// APIStorage serves one version of a resource. The objects of all versions are kept by
+// a shared resourceStore at the storage version, and converted to and from the version
+// served by the APIStorage on every read and write.
+type APIStorage struct {
+	*resourceStore
 
-	rv := s.s.NextResourceVersion()
-	obj.SetResourceVersion(fmt.Sprintf("%d", rv))
+	s   *Server
+	GVR schema.GroupVersionResource
+	GVK schema.GroupVersionKind
+}
 
-	key := types.NamespacedName{
-		Namespace: obj.GetNamespace(),
-		Name:      obj.GetName(),
+func (s *APIStorage) Items() ([]unstructured.Unstructured, error) {
+	objs, _, err := s.List(0)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]unstructured.Unstructured, len(objs))
+	for i, obj := range objs {
+		items[i] = *obj
 	}
-	s.Current[key] = obj
-	delete(s.Deleted, key)
+	return items, nil
 }
 
-var nsGVK = schema.GroupVersionKind{
-	Group:   "",
-	Version: "v1",
-	Kind:    "Namespace",
+func (s *APIStorage) List(rv int64) ([]*unstructured.Unstructured, int64, error) {
+	objs, rv, err := s.resourceStore.List(rv)
+	if err != nil {
+		return nil, 0, err
+	}
+	objs, err = s.fromStorage(objs...)
+	if err != nil {
+		return nil, 0, err
+	}
+	return objs, rv, nil
+}
+
+// Get returns the object with key, or false if it does not exist. An error is
+// only returned if the object could not be converted to the served version.
+func (s *APIStorage) Get(key types.NamespacedName) (*unstructured.Unstructured, bool, error) {
+	obj, found := s.resourceStore.Get(key)
+	if !found {
+		return nil, false, nil
+	}
+	objs, err := s.fromStorage(obj)
+	if err != nil {
+		return nil, false, err
+	}
+	return objs[0], true, nil
 }
 
-func (s *APIStorage) Remove(key types.NamespacedName) (*unstructured.Unstructured, bool) {
-	s.m.Lock()
-	defer s.m.Unlock()
+// Create stores obj at the storage version and updates obj to the stored object.
+func (s *APIStorage) Create(obj *unstructured.Unstructured) error {
+	return s.write(obj, s.resourceStore.Create)
+}
 
-	obj, exists := s.Current[key]
-	delete(s.Current, key)
-	if exists {
-		rv := s.s.NextResourceVersion()
-		obj.SetResourceVersion(fmt.Sprintf("%d", rv))
+// Update stores obj at the storage version and updates obj to the stored object.
+func (s *APIStorage) Update(obj *unstructured.Unstructured, allowCreate bool) error {
+	return s.write(obj, func(stored *unstructured.Unstructured) error {
+		return s.resourceStore.Update(stored, allowCreate)
+	})
+}
 
-		s.Deleted[key] = obj
+func (s *APIStorage) write(obj *unstructured.Unstructured, fn func(stored *unstructured.Unstructured) error) error {
+	stored, err := s.toStorage(obj)
+	if err != nil {
+		return err
+	}
+	if err := fn(stored); err != nil {
+		return err
+	}
+	if stored == obj {
+		return nil
+	}
+	objs, err := s.fromStorage(stored)
+	if err != nil {
+		return err
+	}
+	obj.Object = objs[0].Object
+	return nil
+}
 
-		if s.GVK == nsGVK {
-			s.s.RemoveNamespace(key.Name)
-		}
+func (s *APIStorage) Delete(key types.NamespacedName, preconditions *metav1.Preconditions, finalizers []string) (*unstructured.Unstructured, bool, error) {
+	obj, removed, err := s.resourceStore.Delete(key, preconditions, finalizers)
+	if err != nil {
+		return nil, false, err
+	}
+	objs, err := s.fromStorage(obj)
+	if err != nil {
+		return nil, false, err
 	}
-	return obj, exists
+	return objs[0], removed, nil
 }
 
-func (s *APIStorage) RemoveObj(obj *unstructured.Unstructured) (*unstructured.Unstructured, bool) {
-	key := types.NamespacedName{
-		Namespace: obj.GetNamespace(),
-		Name:      obj.GetName(),
+// toStorage converts obj to the storage version of the resource.
+func (s *APIStorage) toStorage(obj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	gv, found := s.s.storageVersion(s.GVR.GroupResource())
+	if !found {
+		return obj, nil
+	}
+	objs, err := s.s.convert(s.GVR.GroupResource(), []*unstructured.Unstructured{obj}, gv)
+	if err != nil {
+		return nil, err
 	}
-	return s.Remove(key)
+	return objs[0], nil
 }
 
-func (s *APIStorage) RemoveForNamespace(ns string) {
-	s.m.Lock()
-	defer s.m.Unlock()
+// fromStorage converts stored objects to the served version.
+func (s *APIStorage) fromStorage(objs ...*unstructured.Unstructured) ([]*unstructured.Unstructured, error) {
+	return s.s.convert(s.GVR.GroupResource(), objs, s.GVK.GroupVersion())
+}
 
-	if !s.Namespaced || ns == "" {
-		return
+func keyLess(a, b *unstructured.Unstructured) bool {
+	if a.GetNamespace() != b.GetNamespace() {
+		return a.GetNamespace() < b.GetNamespace()
 	}
+	return a.GetName() < b.GetName()
+}
+
+var nsGVK = schema.GroupVersionKind{
+	Group:   "",
+	Version: "v1",
+	Kind:    "Namespace",
+}
 
-	for key := range s.Current {
-		if key.Namespace == ns {
-			delete(s.Current, key)
-		}
+func checkPreconditions(gr schema.GroupResource, obj *unstructured.Unstructured, preconditions *metav1.Preconditions) error {
+	if preconditions == nil {
+		return nil
+	}
+	if preconditions.UID != nil && *preconditions.UID != obj.GetUID() {
+		err := fmt.Errorf("precondition failed: UID in precondition: %v, UID in object meta: %v", *preconditions.UID, obj.GetUID())
+		return apierrors.NewConflict(gr, obj.GetName(), err)
 	}
+	if preconditions.ResourceVersion != nil && *preconditions.ResourceVersion != obj.GetResourceVersion() {
+		err := fmt.Errorf("precondition failed: ResourceVersion in precondition: %v, ResourceVersion in object meta: %v", *preconditions.ResourceVersion, obj.GetResourceVersion())
+		return apierrors.NewConflict(gr, obj.GetName(), err)
+	}
+	return nil
+}
+
+// hasFinalizers returns true if obj has finalizers, including the spec.finalizers of namespaces.
+func hasFinalizers(gvk schema.GroupVersionKind, obj *unstructured.Unstructured) bool {
+	return len(obj.GetFinalizers()) > 0 || (gvk == nsGVK && len(specFinalizers(obj)) > 0)
 }
@@ -0,0 +1,234 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"time"
+
+	"kmodules.xyz/fake-apiserver/pkg/resources"
+
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/util/jsonpath"
+)
+
+// asTable renders obj, an object or a list, as a Table.
+func (s *Server) asTable(store *APIStorage, r *http.Request, obj runtime.Object, gv schema.GroupVersion) (*metav1.Table, error) {
+	var opts metav1.TableOptions
+	err := s.opts.ParameterCodec.DecodeParameters(r.URL.Query(), metav1.SchemeGroupVersion, &opts)
+	if err != nil {
+		return nil, err
+	}
+	switch opts.IncludeObject {
+	case "":
+		opts.IncludeObject = metav1.IncludeMetadata
+	case metav1.IncludeMetadata, metav1.IncludeObject, metav1.IncludeNone:
+	default:
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("unrecognized includeObject value: %q", opts.IncludeObject))
+	}
+
+	printer, err := s.tablePrinter(store)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []unstructured.Unstructured
+	table := &metav1.Table{
+		ColumnDefinitions: printer.Columns,
+	}
+	table.APIVersion = gv.String()
+	table.Kind = "Table"
+	switch o := obj.(type) {
+	case *unstructured.UnstructuredList:
+		items = o.Items
+		table.ResourceVersion = o.GetResourceVersion()
+		table.Continue = o.GetContinue()
+		table.RemainingItemCount = o.GetRemainingItemCount()
+	case *unstructured.Unstructured:
+		items = []unstructured.Unstructured{*o}
+		table.ResourceVersion = o.GetResourceVersion()
+	default:
+		return nil, apierrors.NewInternalError(fmt.Errorf("unsupported object %T", obj))
+	}
+	if opts.NoHeaders {
+		table.ColumnDefinitions = nil
+	}
+
+	table.Rows = make([]metav1.TableRow, 0, len(items))
+	for i := range items {
+		item := &items[i]
+		cells, err := printer.Cells(item)
+		if err != nil {
+			return nil, err
+		}
+		row := metav1.TableRow{
+			Cells: cells,
+		}
+		switch opts.IncludeObject {
+		case metav1.IncludeObject:
+			row.Object.Raw, err = json.Marshal(item)
+		case metav1.IncludeMetadata:
+			var m *metav1.PartialObjectMetadata
+			m, err = asPartialObjectMetadata(item, metav1.SchemeGroupVersion)
+			if err == nil {
+				row.Object.Raw, err = json.Marshal(m)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		table.Rows = append(table.Rows, row)
+	}
+	return table, nil
+}
+
+// tablePrinter returns the columns of the resource served by store, either the additionalPrinterColumns of
+// a CRD, the columns of the ResourceTableDefinition of a built-in resource or the name and creation timestamp.
+func (s *Server) tablePrinter(store *APIStorage) (resources.TablePrinter, error) {
+	if crd, found := s.crdFor(store.GVR.GroupResource()); found {
+		if v, found := crdVersion(crd, store.GVK.Version); found {
+			return crdTablePrinter(v.AdditionalPrinterColumns)
+		}
+	}
+	if p, found := resources.TablePrinterFor(store.GVR); found {
+		return p, nil
+	}
+	return resources.TablePrinter{
+		Columns: []metav1.TableColumnDefinition{
+			{Name: "Name", Type: "string", Format: "name", Description: "Name must be unique within a namespace."},
+			{Name: "Created At", Type: "date", Description: "CreationTimestamp is a timestamp representing the server time when this object was created."},
+		},
+		Cells: func(obj *unstructured.Unstructured) ([]any, error) {
+			return []any{obj.GetName(), obj.GetCreationTimestamp().UTC().Format(time.RFC3339)}, nil
+		},
+	}, nil
+}
+
+// crdTablePrinter renders the additionalPrinterColumns of a CRD like the apiextensions-apiserver.
+func crdTablePrinter(columns []apiextensionsv1.CustomResourceColumnDefinition) (resources.TablePrinter, error) {
+	if len(columns) == 0 {
+		columns = []apiextensionsv1.CustomResourceColumnDefinition{
+			{Name: "Age", Type: "date", JSONPath: ".metadata.creationTimestamp"},
+		}
+	}
+
+	defs := []metav1.TableColumnDefinition{
+		{Name: "Name", Type: "string", Format: "name", Description: "Name must be unique within a namespace."},
+	}
+	parsers := make([]*jsonpath.JSONPath, 0, len(columns))
+	for _, col := range columns {
+		p := jsonpath.New(col.Name)
+		p.AllowMissingKeys(true)
+		if err := p.Parse(fmt.Sprintf("{%s}", col.JSONPath)); err != nil {
+			return resources.TablePrinter{}, apierrors.NewInternalError(fmt.Errorf("unrecognized column definition %q", col.JSONPath))
+		}
+		parsers = append(parsers, p)
+		defs = append(defs, metav1.TableColumnDefinition{
+			Name:        col.Name,
+			Type:        col.Type,
+			Format:      col.Format,
+			Description: col.Description,
+			Priority:    col.Priority,
+		})
+	}
+
+	return resources.TablePrinter{
+		Columns: defs,
+		Cells: func(obj *unstructured.Unstructured) ([]any, error) {
+			cells := make([]any, 0, len(defs))
+			cells = append(cells, obj.GetName())
+			for i, p := range parsers {
+				results, err := p.FindResults(obj.UnstructuredContent())
+				if err != nil || len(results) == 0 || len(results[0]) == 0 {
+					cells = append(cells, nil)
+					continue
+				}
+				// only simple JSON paths are supported, so there is at most one result
+				value := results[0][0].Interface()
+				if columns[i].Type == "string" {
+					var buf bytes.Buffer
+					if err := p.PrintResults(&buf, []reflect.Value{reflect.ValueOf(value)}); err == nil {
+						cells = append(cells, buf.String())
+					} else {
+						cells = append(cells, nil)
+					}
+				} else {
+					cells = append(cells, cellForJSONValue(columns[i].Type, value))
+				}
+			}
+			return cells, nil
+		},
+	}, nil
+}
+
+func cellForJSONValue(headerType string, value any) any {
+	if value == nil {
+		return nil
+	}
+
+	switch headerType {
+	case "integer":
+		switch typed := value.(type) {
+		case int64:
+			return typed
+		case float64:
+			return int64(typed)
+		case json.Number:
+			if i64, err := typed.Int64(); err == nil {
+				return i64
+			}
+		}
+	case "number":
+		switch typed := value.(type) {
+		case int64:
+			return float64(typed)
+		case float64:
+			return typed
+		case json.Number:
+			if f, err := typed.Float64(); err == nil {
+				return f
+			}
+		}
+	case "boolean":
+		if b, ok := value.(bool); ok {
+			return b
+		}
+	case "string":
+		if s, ok := value.(string); ok {
+			return s
+		}
+	case "date":
+		if typed, ok := value.(string); ok {
+			var timestamp metav1.Time
+			if err := timestamp.UnmarshalQueryParameter(typed); err != nil {
+				return "<invalid>"
+			}
+			return resources.TranslateTimestampSince(timestamp)
+		}
+	}
+
+	return nil
+}
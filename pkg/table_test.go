@@ -0,0 +1,173 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// getTable lists resource in the default namespace as a Table.
+func getTable(t *testing.T, kc kubernetes.Interface, resource string, namespaced bool, params map[string]string) *metav1.Table {
+	t.Helper()
+
+	req := kc.CoreV1().RESTClient().Get().
+		Resource(resource).
+		SetHeader("Accept", "application/json;as=Table;v=v1;g=meta.k8s.io")
+	if namespaced {
+		req = req.Namespace(metav1.NamespaceDefault)
+	}
+	for k, v := range params {
+		req = req.Param(k, v)
+	}
+	data, err := req.DoRaw(context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var table metav1.Table
+	if err := json.Unmarshal(data, &table); err != nil {
+		t.Fatal(err)
+	}
+	return &table
+}
+
+// tableCells returns the cells of the first row of table by column name.
+func tableCells(t *testing.T, table *metav1.Table) map[string]string {
+	t.Helper()
+
+	if len(table.Rows) != 1 {
+		t.Fatalf("expected 1 row, got %d", len(table.Rows))
+	}
+	if len(table.Rows[0].Cells) != len(table.ColumnDefinitions) {
+		t.Fatalf("expected %d cells, got %v", len(table.ColumnDefinitions), table.Rows[0].Cells)
+	}
+	cells := map[string]string{}
+	for i, col := range table.ColumnDefinitions {
+		cells[col.Name] = fmt.Sprint(table.Rows[0].Cells[i])
+	}
+	return cells
+}
+
+func TestBuiltinTable(t *testing.T) {
+	_, _, kc, _ := newTestCluster(t)
+	ctx := context.TODO()
+
+	pod, err := kc.CoreV1().Pods(metav1.NamespaceDefault).Create(ctx, &core.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web"},
+		Spec: core.PodSpec{
+			Containers: []core.Container{
+				{Name: "nginx", Image: "nginx"},
+				{Name: "sidecar", Image: "busybox"},
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pod.Status = core.PodStatus{
+		Phase: core.PodRunning,
+		ContainerStatuses: []core.ContainerStatus{
+			{Name: "nginx", Ready: true, RestartCount: 2},
+			{Name: "sidecar", RestartCount: 1},
+		},
+	}
+	if _, err := kc.CoreV1().Pods(metav1.NamespaceDefault).UpdateStatus(ctx, pod, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = kc.CoreV1().Services(metav1.NamespaceDefault).Create(ctx, &core.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "web"},
+		Spec: core.ServiceSpec{
+			Type:        core.ServiceTypeNodePort,
+			ExternalIPs: []string{"10.0.0.1"},
+			Ports: []core.ServicePort{
+				{Port: 80, NodePort: 30080},
+				{Port: 53, Protocol: core.ProtocolUDP},
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = kc.CoreV1().Nodes().Create(ctx, &core.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "node-1",
+			Labels: map[string]string{
+				"node-role.kubernetes.io/worker":        "",
+				"node-role.kubernetes.io/control-plane": "",
+				"kubernetes.io/hostname":                "node-1",
+			},
+		},
+		Spec: core.NodeSpec{Unschedulable: true},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		resource   string
+		namespaced bool
+		expected   map[string]string
+	}{
+		{"pods", true, map[string]string{"Name": "web", "Ready": "1/2", "Status": "Running", "Restarts": "3"}},
+		{"services", true, map[string]string{"Name": "web", "Type": "NodePort", "External-IP": "10.0.0.1", "Port(s)": "80:30080/TCP,53/UDP"}},
+		{"nodes", false, map[string]string{"Name": "node-1", "Status": "Unknown,SchedulingDisabled", "Roles": "control-plane,worker"}},
+	} {
+		table := getTable(t, kc, tc.resource, tc.namespaced, nil)
+		cells := tableCells(t, table)
+		for name, expected := range tc.expected {
+			if cells[name] != expected {
+				t.Errorf("%s: expected column %s to be %q, got %q", tc.resource, name, expected, cells[name])
+			}
+		}
+		if cells["Age"] == "<nil>" || cells["Age"] == "<invalid>" {
+			t.Errorf("%s: expected an age, got %q", tc.resource, cells["Age"])
+		}
+		if table.ColumnDefinitions[0].Format != "name" {
+			t.Errorf("%s: expected the first column to be the name, got %+v", tc.resource, table.ColumnDefinitions[0])
+		}
+	}
+}
+
+func TestTableOptions(t *testing.T) {
+	_, _, kc, _ := newTestCluster(t)
+	createConfigMap(t, kc, "cm")
+
+	table := getTable(t, kc, "configmaps", true, nil)
+	var m metav1.PartialObjectMetadata
+	if err := json.Unmarshal(table.Rows[0].Object.Raw, &m); err != nil || m.Name != "cm" {
+		t.Errorf("expected the metadata of the object by default, got %s", table.Rows[0].Object.Raw)
+	}
+
+	table = getTable(t, kc, "configmaps", true, map[string]string{"includeObject": string(metav1.IncludeObject)})
+	var cm core.ConfigMap
+	if err := json.Unmarshal(table.Rows[0].Object.Raw, &cm); err != nil || cm.Name != "cm" || cm.Kind != "ConfigMap" {
+		t.Errorf("expected the object, got %s", table.Rows[0].Object.Raw)
+	}
+
+	table = getTable(t, kc, "configmaps", true, map[string]string{"includeObject": string(metav1.IncludeNone)})
+	if len(table.Rows[0].Object.Raw) != 0 {
+		t.Errorf("expected no object, got %s", table.Rows[0].Object.Raw)
+	}
+}
@@ -0,0 +1,137 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"fmt"
+	"net/http"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apiserver/pkg/endpoints/handlers/negotiation"
+)
+
+// transformRestrictions allows clients to ask for objects in a different form,
+// e.g. with "Accept: application/json;as=Table;v=v1;g=meta.k8s.io".
+type transformRestrictions struct {
+	// protobuf is set if the objects themselves can be encoded as protobuf.
+	protobuf bool
+}
+
+var _ negotiation.EndpointRestrictions = transformRestrictions{}
+
+func (t transformRestrictions) AllowsMediaTypeTransform(mimeType, mimeSubType string, target *schema.GroupVersionKind) bool {
+	if target == nil {
+		return t.protobuf || mimeSubType != "vnd.kubernetes.protobuf"
+	}
+	if target.Group != metav1.GroupName || (target.Version != "v1" && target.Version != "v1beta1") {
+		return false
+	}
+	switch target.Kind {
+	case "Table":
+		// cells are arbitrary JSON values, so tables can not be encoded as protobuf
+		return mimeType == "application" && (mimeSubType == "json" || mimeSubType == "yaml")
+	case "PartialObjectMetadata", "PartialObjectMetadataList":
+		return true
+	}
+	return false
+}
+
+func (transformRestrictions) AllowsServerVersion(string) bool { return false }
+
+func (transformRestrictions) AllowsStreamSchema(s string) bool { return s == "watch" }
+
+// transformTarget returns the kind the client asked the response to be converted to, if any.
+func (s *Server) transformTarget(r *http.Request) *schema.GroupVersionKind {
+	mediaType, _, err := negotiation.NegotiateOutputMediaType(r, s.outputSerializer(), s.restrictions(r))
+	if err != nil {
+		return nil
+	}
+	return mediaType.Convert
+}
+
+// transformObject converts the result of a request into the form asked for by the client.
+func (s *Server) transformObject(store *APIStorage, r *http.Request, obj runtime.Object) (runtime.Object, error) {
+	target := s.transformTarget(r)
+	if target == nil {
+		return obj, nil
+	}
+	if _, ok := obj.(*metav1.Status); ok {
+		return obj, nil
+	}
+
+	switch target.Kind {
+	case "Table":
+		return s.asTable(store, r, obj, target.GroupVersion())
+	case "PartialObjectMetadata":
+		o, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			return nil, newNotAcceptableError(fmt.Sprintf("you requested PartialObjectMetadata, but the requested object is a list (%T)", obj))
+		}
+		return asPartialObjectMetadata(o, target.GroupVersion())
+	case "PartialObjectMetadataList":
+		list, ok := obj.(*unstructured.UnstructuredList)
+		if !ok {
+			return nil, newNotAcceptableError(fmt.Sprintf("you requested PartialObjectMetadataList, but the requested object is not a list (%T)", obj))
+		}
+		return asPartialObjectMetadataList(list, target.GroupVersion())
+	}
+	return nil, newNotAcceptableError(fmt.Sprintf("no conversion to %s is supported", target))
+}
+
+// asPartialObjectMetadata strips everything but the TypeMeta and ObjectMeta of obj.
+func asPartialObjectMetadata(obj *unstructured.Unstructured, gv schema.GroupVersion) (*metav1.PartialObjectMetadata, error) {
+	var m metav1.PartialObjectMetadata
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &m); err != nil {
+		return nil, apierrors.NewInternalError(err)
+	}
+	m.APIVersion = gv.String()
+	m.Kind = "PartialObjectMetadata"
+	return &m, nil
+}
+
+// asPartialObjectMetadataList strips everything but the TypeMeta and ObjectMeta of the items of list.
+func asPartialObjectMetadataList(list *unstructured.UnstructuredList, gv schema.GroupVersion) (*metav1.PartialObjectMetadataList, error) {
+	result := &metav1.PartialObjectMetadataList{
+		Items: make([]metav1.PartialObjectMetadata, 0, len(list.Items)),
+	}
+	result.APIVersion = gv.String()
+	result.Kind = "PartialObjectMetadataList"
+	result.ResourceVersion = list.GetResourceVersion()
+	result.Continue = list.GetContinue()
+	result.RemainingItemCount = list.GetRemainingItemCount()
+	for i := range list.Items {
+		m, err := asPartialObjectMetadata(&list.Items[i], gv)
+		if err != nil {
+			return nil, err
+		}
+		result.Items = append(result.Items, *m)
+	}
+	return result, nil
+}
+
+func newNotAcceptableError(msg string) error {
+	return &apierrors.StatusError{ErrStatus: metav1.Status{
+		Status:  metav1.StatusFailure,
+		Code:    http.StatusNotAcceptable,
+		Reason:  metav1.StatusReasonNotAcceptable,
+		Message: msg,
+	}}
+}
@@ -0,0 +1,113 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/metadata"
+)
+
+func TestPartialObjectMetadata(t *testing.T) {
+	_, cfg, kc, dc := newTestCluster(t)
+	ctx := context.TODO()
+	createCRD(t, dc, widgetCRD)
+	mc := metadata.NewForConfigOrDie(cfg)
+
+	cm := createConfigMap(t, kc, "a")
+	widgetGVR := schema.GroupVersionResource{Group: "example.com", Version: "v1", Resource: "widgets"}
+	if _, err := dc.Resource(widgetGVR).Namespace(metav1.NamespaceDefault).Create(ctx, newWidget("w1", map[string]any{"size": int64(1)}), metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the metadata client asks for protobuf, which custom resources fall back from to JSON,
+	// and fails unless it gets a PartialObjectMetadata or PartialObjectMetadataList
+	for gvr, name := range map[schema.GroupVersionResource]string{
+		{Version: "v1", Resource: "configmaps"}: "a",
+		widgetGVR:                               "w1",
+	} {
+		client := mc.Resource(gvr).Namespace(metav1.NamespaceDefault)
+		m, err := client.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("%s: %v", gvr.Resource, err)
+		}
+		if m.Name != name || m.UID == "" {
+			t.Errorf("%s: expected the metadata of %s, got %+v", gvr.Resource, name, m)
+		}
+
+		list, err := client.List(ctx, metav1.ListOptions{Limit: 1})
+		if err != nil {
+			t.Fatalf("%s: %v", gvr.Resource, err)
+		}
+		if list.ResourceVersion == "" || len(list.Items) != 1 {
+			t.Errorf("%s: expected a PartialObjectMetadataList with one item, got %+v", gvr.Resource, list)
+		}
+	}
+
+	w, err := mc.Resource(schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}).Namespace(metav1.NamespaceDefault).
+		Watch(ctx, metav1.ListOptions{ResourceVersion: cm.ResourceVersion})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+	createConfigMap(t, kc, "b")
+	select {
+	case e := <-w.ResultChan():
+		m, ok := e.Object.(*metav1.PartialObjectMetadata)
+		if e.Type != watch.Added || !ok || m.Name != "b" {
+			t.Errorf("expected an ADDED event with the metadata of b, got %s %v", e.Type, e.Object)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected an event, got none")
+	}
+
+	data, err := kc.CoreV1().RESTClient().Get().
+		Namespace(metav1.NamespaceDefault).
+		Resource("configmaps").
+		Name("a").
+		SetHeader("Accept", "application/json;as=PartialObjectMetadata;g=meta.k8s.io;v=v1").
+		DoRaw(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var raw map[string]any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		t.Fatal(err)
+	}
+	if raw["kind"] != "PartialObjectMetadata" || raw["apiVersion"] != "meta.k8s.io/v1" {
+		t.Errorf("expected a meta.k8s.io/v1 PartialObjectMetadata, got %v %v", raw["apiVersion"], raw["kind"])
+	}
+
+	// a single object can not be returned as a list
+	err = kc.CoreV1().RESTClient().Get().
+		Namespace(metav1.NamespaceDefault).
+		Resource("configmaps").
+		Name("a").
+		SetHeader("Accept", "application/json;as=PartialObjectMetadataList;g=meta.k8s.io;v=v1").
+		Do(ctx).Error()
+	if status, ok := err.(apierrors.APIStatus); !ok || status.Status().Code != http.StatusNotAcceptable {
+		t.Errorf("expected NotAcceptable for a single object as PartialObjectMetadataList, got %v", err)
+	}
+}
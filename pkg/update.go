@@ -23,63 +23,62 @@ import (
 	"kmodules.xyz/fake-apiserver/pkg/resources"
 
 	"github.com/go-chi/chi/v5"
-	httpw "go.wandrs.dev/http"
 	core "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
-	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/apimachinery/pkg/types"
 )
 
 func (s *Server) Update(w http.ResponseWriter, r *http.Request) {
-	store := s.Store(r)
-	codec := s.codec(w, r)
+	store, err := s.Store(r)
+	if err != nil {
+		writeStatus(w, s.encoder(w, r), err)
+		return
+	}
+	codec, err := s.codec(w, r)
+	if err != nil {
+		writeStatus(w, s.encoder(w, r), err)
+		return
+	}
 
-	obj, err := s.UpdateImpl(store, codec, r)
+	obj, created, err := s.updateImpl(store, codec, r)
+	if err == nil {
+		obj, err = s.transformObject(store, r, obj)
+	}
 	if err != nil {
-		_ = codec.Encode(httpw.ErrorToAPIStatus(err), w)
+		writeStatus(w, codec, err)
 		return
 	}
+	if created {
+		w.WriteHeader(http.StatusCreated)
+	}
 	_ = codec.Encode(obj, w)
 }
 
 func (s *Server) UpdateImpl(store *APIStorage, codec runtime.Codec, r *http.Request) (runtime.Object, error) {
+	obj, _, err := s.updateImpl(store, codec, r)
+	return obj, err
+}
+
+// updateImpl returns the updated object and whether it was created, which is only allowed
+// for resources that create missing objects on update.
+func (s *Server) updateImpl(store *APIStorage, codec runtime.Codec, r *http.Request) (runtime.Object, bool, error) {
 	var opts metav1.UpdateOptions
 	err := s.opts.ParameterCodec.DecodeParameters(r.URL.Query(), metav1.SchemeGroupVersion, &opts)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 
 	defer r.Body.Close() // nolint:errcheck
 	data, err := io.ReadAll(r.Body)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 
-	isOfficialType := clientgoscheme.Scheme.IsGroupRegistered(store.GVK.Group)
-
-	var into runtime.Object
-	if !isOfficialType {
-		var u unstructured.Unstructured
-		u.SetGroupVersionKind(store.GVK)
-		into = &u
-	}
-	o2, _, err := codec.Decode(data, &store.GVK, into)
+	obj, err := s.decodeObject(store, codec, data)
 	if err != nil {
-		return nil, err
-	}
-
-	var obj unstructured.Unstructured
-	if isOfficialType {
-		content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(o2)
-		if err != nil {
-			return nil, err
-		}
-
-		obj.SetGroupVersionKind(store.GVK)
-		obj.SetUnstructuredContent(content)
-	} else {
-		obj = *into.(*unstructured.Unstructured)
+		return nil, false, err
 	}
 
 	if store.Namespaced {
@@ -90,13 +89,62 @@ func (s *Server) UpdateImpl(store *APIStorage, codec runtime.Codec, r *http.Requ
 	}
 
 	if store.GVK == core.SchemeGroupVersion.WithKind("Secret") {
-		err = resources.ProcessSecret(&obj)
+		err = resources.ProcessSecret(obj)
 		if err != nil {
-			return nil, err
+			return nil, false, err
 		}
 	}
 
-	store.Insert(&obj)
+	key := types.NamespacedName{
+		Namespace: obj.GetNamespace(),
+		Name:      obj.GetName(),
+	}
+	liveObj, exists, err := store.Get(key)
+	if err != nil {
+		return nil, false, err
+	}
+	if !exists {
+		if !resources.AllowCreateOnUpdate(store.GVR.GroupResource()) {
+			return nil, false, apierrors.NewNotFound(store.GVR.GroupResource(), key.Name)
+		}
+		if err := s.admitCreate(store, key.Namespace); err != nil {
+			return nil, false, err
+		}
+	}
+	hasStatus := s.hasStatusSubresource(store)
+	if hasStatus {
+		copyStatus(obj, liveObj)
+	}
+	if store.GVK.GroupKind() == crdGVK.GroupKind() {
+		if err := s.prepareCRD(obj, liveObj); err != nil {
+			return nil, false, err
+		}
+	}
+	if exists && store.GVK == nsGVK {
+		// spec.finalizers can only be changed through the finalize subresource
+		setSpecFinalizers(obj, specFinalizers(liveObj))
+	}
+	if err := s.applySchema(store, obj, liveObj); err != nil {
+		return nil, false, err
+	}
+	if exists {
+		if err := prepareForUpdate(store.GVR.GroupResource(), obj, liveObj, hasStatus); err != nil {
+			return nil, false, err
+		}
+	} else {
+		prepareForCreate(obj)
+	}
+	result, err := s.updateManagedFields(store, liveObj, obj, fieldManagerName(r, opts.FieldManager), "")
+	if err != nil {
+		return nil, false, err
+	}
+	if err := store.Update(result, !exists); err != nil {
+		return nil, false, err
+	}
+	if result.GetDeletionTimestamp() != nil {
+		// the object is removed if the update cleared its finalizers
+		s.CollectGarbage()
+	}
 
-	return &obj, nil
+	return result, !exists, nil
 }
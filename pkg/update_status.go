@@ -16,7 +16,123 @@ limitations under the License.
 
 package pkg
 
-import "net/http"
+import (
+	"io"
+	"net/http"
+
+	"kmodules.xyz/fake-apiserver/pkg/resources"
+
+	"github.com/go-chi/chi/v5"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
 
 func (s *Server) UpdateStatus(w http.ResponseWriter, r *http.Request) {
+	store, err := s.Store(r)
+	if err != nil {
+		writeStatus(w, s.encoder(w, r), err)
+		return
+	}
+	codec, err := s.codec(w, r)
+	if err != nil {
+		writeStatus(w, s.encoder(w, r), err)
+		return
+	}
+
+	obj, err := s.UpdateStatusImpl(store, codec, r)
+	if err == nil {
+		obj, err = s.transformObject(store, r, obj)
+	}
+	if err != nil {
+		writeStatus(w, codec, err)
+		return
+	}
+	_ = codec.Encode(obj, w)
+}
+
+func (s *Server) UpdateStatusImpl(store *APIStorage, codec runtime.Codec, r *http.Request) (runtime.Object, error) {
+	var opts metav1.UpdateOptions
+	err := s.opts.ParameterCodec.DecodeParameters(r.URL.Query(), metav1.SchemeGroupVersion, &opts)
+	if err != nil {
+		return nil, err
+	}
+
+	key := types.NamespacedName{
+		Namespace: chi.URLParam(r, "namespace"),
+		Name:      chi.URLParam(r, "name"),
+	}
+	if !s.hasStatusSubresource(store) {
+		return nil, apierrors.NewNotFound(store.GVR.GroupResource(), key.Name+"/status")
+	}
+
+	defer r.Body.Close() // nolint:errcheck
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	obj, err := s.decodeObject(store, codec, data)
+	if err != nil {
+		return nil, err
+	}
+
+	liveObj, exists, err := store.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, apierrors.NewNotFound(store.GVR.GroupResource(), key.Name)
+	}
+	result := statusUpdate(liveObj, obj)
+	if err := s.applySchema(store, result, liveObj); err != nil {
+		return nil, err
+	}
+
+	result, err = s.updateManagedFields(store, liveObj, result, fieldManagerName(r, opts.FieldManager), "status")
+	if err != nil {
+		return nil, err
+	}
+	if err := store.Update(result, false); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// hasStatusSubresource returns true if the resource served by store has a status subresource,
+// either as a built-in type or as a CRD version with subresources.status.
+func (s *Server) hasStatusSubresource(store *APIStorage) bool {
+	if resources.HasStatusSubresource(store.GVR.GroupResource()) {
+		return true
+	}
+	crd, found := s.crdFor(store.GVR.GroupResource())
+	if !found {
+		return false
+	}
+	return crdHasStatus(crd, store.GVK.Version)
+}
+
+// copyStatus replaces the status of dst with the status of src.
+// The status of dst is removed if src is nil or has no status.
+func copyStatus(dst, src *unstructured.Unstructured) {
+	if src != nil {
+		if status, found := src.Object["status"]; found {
+			dst.Object["status"] = runtime.DeepCopyJSONValue(status)
+			return
+		}
+	}
+	delete(dst.Object, "status")
+}
+
+// statusUpdate returns liveObj with the status, resourceVersion and managedFields of obj,
+// ignoring all other changes like the status strategies of the apiserver.
+func statusUpdate(liveObj, obj *unstructured.Unstructured) *unstructured.Unstructured {
+	result := liveObj.DeepCopy()
+	copyStatus(result, obj)
+	result.SetResourceVersion(obj.GetResourceVersion())
+	result.SetManagedFields(obj.GetManagedFields())
+	return result
 }
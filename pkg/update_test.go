@@ -0,0 +1,142 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	core "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+)
+
+func TestUpdateConflict(t *testing.T) {
+	_, _, kc, _ := newTestCluster(t)
+	ctx := context.TODO()
+	cms := kc.CoreV1().ConfigMaps(metav1.NamespaceDefault)
+
+	stale := createConfigMap(t, kc, "cm")
+	cm := stale.DeepCopy()
+	cm.Data = map[string]string{"a": "1"}
+	cm, err := cms.Update(ctx, cm, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cm.ResourceVersion == stale.ResourceVersion {
+		t.Fatal("expected the update to change the resourceVersion")
+	}
+
+	stale.Data = map[string]string{"b": "1"}
+	_, err = cms.Update(ctx, stale, metav1.UpdateOptions{})
+	if !apierrors.IsConflict(err) {
+		t.Fatalf("expected Conflict for a stale resourceVersion, got %v", err)
+	}
+	if code := err.(apierrors.APIStatus).Status().Code; code != http.StatusConflict {
+		t.Errorf("expected status code %d, got %d", http.StatusConflict, code)
+	}
+	if got := getConfigMap(t, kc, "cm"); got.Data["a"] != "1" || got.Data["b"] != "" {
+		t.Errorf("expected the stale update to be rejected, got %v", got.Data)
+	}
+
+	// an update without resourceVersion is unconditional
+	unconditional := stale.DeepCopy()
+	unconditional.ResourceVersion = ""
+	if _, err := cms.Update(ctx, unconditional, metav1.UpdateOptions{}); err != nil {
+		t.Errorf("expected an update without resourceVersion to succeed, got %v", err)
+	}
+
+	attempts := 0
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		attempts++
+		cm := stale
+		if attempts > 1 {
+			cm = getConfigMap(t, kc, "cm")
+		}
+		cm.Data = map[string]string{"c": "1"}
+		_, err := cms.Update(ctx, cm, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil || attempts != 2 {
+		t.Errorf("expected RetryOnConflict to succeed on the second attempt, got %d attempts and %v", attempts, err)
+	}
+}
+
+func TestPatchResourceVersionPrecondition(t *testing.T) {
+	_, _, kc, _ := newTestCluster(t)
+	ctx := context.TODO()
+	cms := kc.CoreV1().ConfigMaps(metav1.NamespaceDefault)
+
+	stale := createConfigMap(t, kc, "cm")
+	cm, err := cms.Patch(ctx, "cm", types.MergePatchType, []byte(`{"data": {"a": "1"}}`), metav1.PatchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		patchType types.PatchType
+		patch     string
+	}{
+		{types.MergePatchType, fmt.Sprintf(`{"metadata": {"resourceVersion": %q}, "data": {"b": "1"}}`, stale.ResourceVersion)},
+		{types.StrategicMergePatchType, fmt.Sprintf(`{"metadata": {"resourceVersion": %q}, "data": {"b": "1"}}`, stale.ResourceVersion)},
+		{types.JSONPatchType, fmt.Sprintf(`[{"op": "test", "path": "/metadata/resourceVersion", "value": %q}, {"op": "add", "path": "/data/b", "value": "1"}]`, stale.ResourceVersion)},
+	} {
+		if _, err := cms.Patch(ctx, "cm", tc.patchType, []byte(tc.patch), metav1.PatchOptions{}); !apierrors.IsConflict(err) {
+			t.Errorf("%s: expected Conflict for a stale resourceVersion, got %v", tc.patchType, err)
+		}
+	}
+
+	patch := fmt.Sprintf(`[{"op": "test", "path": "/metadata/resourceVersion", "value": %q}, {"op": "add", "path": "/data/b", "value": "1"}]`, cm.ResourceVersion)
+	if _, err := cms.Patch(ctx, "cm", types.JSONPatchType, []byte(patch), metav1.PatchOptions{}); err != nil {
+		t.Errorf("expected a JSON patch testing the current resourceVersion to succeed, got %v", err)
+	}
+}
+
+func TestUpdateMissingObject(t *testing.T) {
+	_, _, kc, dc := newTestCluster(t)
+	ctx := context.TODO()
+
+	_, err := kc.CoreV1().ConfigMaps(metav1.NamespaceDefault).Update(ctx, &core.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "cm"}}, metav1.UpdateOptions{})
+	if !apierrors.IsNotFound(err) {
+		t.Errorf("expected NotFound when updating a missing config map, got %v", err)
+	}
+
+	// services allow create on update, like in the kube-apiserver
+	svc := &core.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "svc"},
+		Spec:       core.ServiceSpec{Ports: []core.ServicePort{{Port: 80}}},
+	}
+	if _, err := kc.CoreV1().Services(metav1.NamespaceDefault).Update(ctx, svc, metav1.UpdateOptions{}); err != nil {
+		t.Errorf("expected an update to create a missing service, got %v", err)
+	}
+	svc.Name = "stale"
+	svc.ResourceVersion = "1"
+	if _, err := kc.CoreV1().Services(metav1.NamespaceDefault).Update(ctx, svc, metav1.UpdateOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("expected NotFound when updating a missing service with a resourceVersion, got %v", err)
+	}
+
+	createCRD(t, dc, widgetCRD)
+	widgets := dc.Resource(schema.GroupVersionResource{Group: "example.com", Version: "v1", Resource: "widgets"}).Namespace(metav1.NamespaceDefault)
+	if _, err := widgets.Update(ctx, newWidget("w1", nil), metav1.UpdateOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("expected NotFound when updating a missing custom resource, got %v", err)
+	}
+}
@@ -0,0 +1,321 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"kmodules.xyz/fake-apiserver/pkg/resources"
+
+	"github.com/go-chi/chi/v5"
+	httpw "go.wandrs.dev/http"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer/streaming"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/apiserver/pkg/endpoints/handlers/negotiation"
+	restclientwatch "k8s.io/client-go/rest/watch"
+)
+
+// defaultWatchTimeout is used when the client does not set timeoutSeconds.
+const defaultWatchTimeout = 30 * time.Minute
+
+// bookmarkFrequency is how often BOOKMARK events are sent to watchers that allow them.
+var bookmarkFrequency = time.Minute
+
+func isWatch(r *http.Request) bool {
+	watch, _ := strconv.ParseBool(r.URL.Query().Get("watch"))
+	return watch
+}
+
+func (s *Server) Watch(w http.ResponseWriter, r *http.Request) {
+	store, err := s.Store(r)
+	if err != nil {
+		writeStatus(w, s.encoder(w, r), err)
+		return
+	}
+
+	if err := s.WatchImpl(store, w, r); err != nil {
+		writeStatus(w, s.encoder(w, r), err)
+	}
+}
+
+// WatchImpl streams watch events until the client disconnects or the timeout expires.
+// An error is only returned if the watch could not be started.
+func (s *Server) WatchImpl(store *APIStorage, w http.ResponseWriter, r *http.Request) error {
+	var opts metav1.ListOptions
+	err := s.opts.ParameterCodec.DecodeParameters(r.URL.Query(), metav1.SchemeGroupVersion, &opts)
+	if err != nil {
+		return err
+	}
+
+	match, err := s.newMatcher(store, chi.URLParam(r, "namespace"), chi.URLParam(r, "name"), opts)
+	if err != nil {
+		return err
+	}
+
+	var rv int64
+	if opts.ResourceVersion != "" {
+		rv, err = strconv.ParseInt(opts.ResourceVersion, 10, 64)
+		if err != nil {
+			return apierrors.NewBadRequest(fmt.Sprintf("invalid resource version %q", opts.ResourceVersion))
+		}
+	}
+	// resourceVersion "" and "0" start with the current state of the store
+	sendInitialEvents := rv == 0
+	if opts.SendInitialEvents != nil {
+		sendInitialEvents = *opts.SendInitialEvents
+	}
+
+	info, err := negotiation.NegotiateOutputMediaTypeStream(r, s.outputSerializer(), s.restrictions(r))
+	if err != nil {
+		return err
+	}
+	transform, err := s.watchTransform(store, r)
+	if err != nil {
+		return err
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return apierrors.NewInternalError(fmt.Errorf("unable to start watch - can't get http.Flusher: %#v", w))
+	}
+
+	wt, events, startRV, err := store.Watch(rv, sendInitialEvents)
+	if err != nil {
+		return err
+	}
+	defer wt.Stop()
+
+	timeout := defaultWatchTimeout
+	if opts.TimeoutSeconds != nil {
+		timeout = time.Duration(*opts.TimeoutSeconds) * time.Second
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	var bookmarks <-chan time.Time
+	if opts.AllowWatchBookmarks {
+		ticker := time.NewTicker(bookmarkFrequency)
+		defer ticker.Stop()
+		bookmarks = ticker.C
+	}
+
+	w.Header().Set("Content-Type", info.MediaType)
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	framer := info.StreamSerializer.Framer.NewFrameWriter(w)
+	enc := restclientwatch.NewEncoder(streaming.NewEncoder(framer, info.StreamSerializer.Serializer), info.Serializer)
+	encode := func(t watch.EventType, obj *unstructured.Unstructured) error {
+		if t != watch.Bookmark {
+			objs, err := store.fromStorage(obj)
+			if err != nil {
+				// the watch is terminated with an ERROR event, so that the client relists
+				_ = enc.Encode(&watch.Event{Type: watch.Error, Object: httpw.ErrorToAPIStatus(err)})
+				flusher.Flush()
+				return err
+			}
+			obj = objs[0]
+		}
+		out, err := transform(t, obj)
+		if err != nil {
+			return err
+		}
+		if err := enc.Encode(&watch.Event{Type: t, Object: out}); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+	send := func(e StoreEvent) error {
+		switch e.Type {
+		case watch.Bookmark:
+			return encode(watch.Bookmark, store.bookmarkObject(e.ResourceVersion, nil))
+		case watch.Modified:
+			cur := match(e.Object)
+			old := e.Prev != nil && match(e.Prev)
+			switch {
+			case cur && old:
+				return encode(watch.Modified, e.Object)
+			case cur:
+				return encode(watch.Added, e.Object)
+			case old:
+				return encode(watch.Deleted, e.Object)
+			}
+			return nil
+		default:
+			if !match(e.Object) {
+				return nil
+			}
+			return encode(e.Type, e.Object)
+		}
+	}
+
+	for _, e := range events {
+		if err := send(e); err != nil {
+			return nil
+		}
+	}
+	if opts.SendInitialEvents != nil && *opts.SendInitialEvents && opts.AllowWatchBookmarks {
+		obj := store.bookmarkObject(startRV, map[string]string{
+			metav1.InitialEventsAnnotationKey: "true",
+		})
+		if err := encode(watch.Bookmark, obj); err != nil {
+			return nil
+		}
+	}
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return nil
+		case <-timer.C:
+			return nil
+		case <-bookmarks:
+			wt.Bookmark()
+		case e, ok := <-wt.ResultChan():
+			if !ok {
+				return nil
+			}
+			if err := send(e); err != nil {
+				return nil
+			}
+		}
+	}
+}
+
+// watchTransform returns a function that converts the objects of watch events into the form asked for by the client.
+func (s *Server) watchTransform(store *APIStorage, r *http.Request) (func(watch.EventType, *unstructured.Unstructured) (runtime.Object, error), error) {
+	target := s.transformTarget(r)
+	if target == nil {
+		return func(_ watch.EventType, obj *unstructured.Unstructured) (runtime.Object, error) {
+			return obj, nil
+		}, nil
+	}
+
+	gv := target.GroupVersion()
+	switch target.Kind {
+	case "PartialObjectMetadata", "PartialObjectMetadataList":
+		return func(_ watch.EventType, obj *unstructured.Unstructured) (runtime.Object, error) {
+			return asPartialObjectMetadata(obj, gv)
+		}, nil
+	case "Table":
+		return func(t watch.EventType, obj *unstructured.Unstructured) (runtime.Object, error) {
+			if t == watch.Bookmark {
+				// bookmarks only carry a resourceVersion, so they are sent as a Table without rows
+				var list unstructured.UnstructuredList
+				list.SetResourceVersion(obj.GetResourceVersion())
+				return s.asTable(store, r, &list, gv)
+			}
+			return s.asTable(store, r, obj, gv)
+		}, nil
+	}
+	return nil, newNotAcceptableError(fmt.Sprintf("no conversion to %s is supported", target))
+}
+
+func (s *APIStorage) bookmarkObject(rv int64, annotations map[string]string) *unstructured.Unstructured {
+	var obj unstructured.Unstructured
+	obj.SetGroupVersionKind(s.GVK)
+	obj.SetResourceVersion(strconv.FormatInt(rv, 10))
+	obj.SetAnnotations(annotations)
+	return &obj
+}
+
+// newMatcher returns a predicate that selects objects of store in namespace ns (if set), with the given name (if set)
+// and matching the label and field selectors of opts. The field selector may only use the field labels of the kind.
+func (s *Server) newMatcher(store *APIStorage, ns, name string, opts metav1.ListOptions) (func(obj *unstructured.Unstructured) bool, error) {
+	labelSel := labels.Everything()
+	if opts.LabelSelector != "" {
+		sel, err := labels.Parse(opts.LabelSelector)
+		if err != nil {
+			return nil, apierrors.NewBadRequest(err.Error())
+		}
+		labelSel = sel
+	}
+
+	fieldLabels, supported := s.fieldLabels(store)
+	fieldSel := fields.Everything()
+	if opts.FieldSelector != "" {
+		sel, err := fields.ParseSelector(opts.FieldSelector)
+		if err != nil {
+			return nil, apierrors.NewBadRequest(err.Error())
+		}
+		for _, req := range sel.Requirements() {
+			if !supported(req.Field) {
+				return nil, apierrors.NewBadRequest(fmt.Sprintf("field label not supported: %s", req.Field))
+			}
+		}
+		fieldSel = sel
+	}
+	if name != "" {
+		fieldSel = fields.AndSelectors(fieldSel, fields.OneTermEqualSelector("metadata.name", name))
+	}
+
+	return func(obj *unstructured.Unstructured) bool {
+		if ns != "" && obj.GetNamespace() != ns {
+			return false
+		}
+		return labelSel.Matches(labels.Set(obj.GetLabels())) &&
+			fieldSel.Matches(fieldLabels(obj))
+	}, nil
+}
+
+// fieldLabels returns a function that returns the field labels of the objects of store, and a function
+// that returns true for the supported labels. These are metadata.name and metadata.namespace, and the
+// field labels of the built-in kind or the selectableFields of the CRD version.
+func (s *Server) fieldLabels(store *APIStorage) (func(obj *unstructured.Unstructured) fields.Set, func(label string) bool) {
+	crd, found := s.crdFor(store.GVR.GroupResource())
+	if !found {
+		fieldSet := func(obj *unstructured.Unstructured) fields.Set {
+			return resources.FieldLabels(store.GVK, obj)
+		}
+		supported := func(label string) bool {
+			return resources.HasFieldLabel(store.GVK, label)
+		}
+		return fieldSet, supported
+	}
+
+	selectable := map[string][]string{}
+	if v, found := crdVersion(crd, store.GVK.Version); found {
+		for _, f := range v.SelectableFields {
+			selectable[strings.TrimPrefix(f.JSONPath, ".")] = jsonPath(f.JSONPath)
+		}
+	}
+	fieldSet := func(obj *unstructured.Unstructured) fields.Set {
+		set := fields.Set{
+			"metadata.name":      obj.GetName(),
+			"metadata.namespace": obj.GetNamespace(),
+		}
+		for label, path := range selectable {
+			set[label] = resources.FieldValue(obj, "", path...)
+		}
+		return set
+	}
+	supported := func(label string) bool {
+		_, found := selectable[label]
+		return found || label == "metadata.name" || label == "metadata.namespace"
+	}
+	return fieldSet, supported
+}
@@ -0,0 +1,79 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	core "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// expectEvent fails t unless the next event of w is of type et for the configmap named name.
+func expectEvent(t *testing.T, w watch.Interface, et watch.EventType, name string) {
+	t.Helper()
+
+	select {
+	case e, ok := <-w.ResultChan():
+		if !ok {
+			t.Fatalf("expected %s event for %s, the watch was closed", et, name)
+		}
+		cm, isConfigMap := e.Object.(*core.ConfigMap)
+		if e.Type != et || !isConfigMap || cm.Name != name {
+			t.Fatalf("expected %s event for %s, got %s event for %v", et, name, e.Type, e.Object)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected %s event for %s, got no event", et, name)
+	}
+}
+
+func TestWatchResume(t *testing.T) {
+	s, _, kc, _ := newTestCluster(t)
+	ctx := context.TODO()
+	cms := kc.CoreV1().ConfigMaps(metav1.NamespaceDefault)
+
+	a := createConfigMap(t, kc, "a")
+	a.Data = map[string]string{"k": "v"}
+	if _, err := cms.Update(ctx, a, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	createConfigMap(t, kc, "b")
+
+	// a watch resumed at a resourceVersion gets the changes after it, and no initial events
+	w, err := cms.Watch(ctx, metav1.ListOptions{ResourceVersion: a.ResourceVersion})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+	expectEvent(t, w, watch.Modified, "a")
+	expectEvent(t, w, watch.Added, "b")
+	deleteConfigMap(t, kc, "a", metav1.DeletePropagationBackground)
+	expectEvent(t, w, watch.Deleted, "a")
+
+	// the changes before a restore are not kept, so a watch cannot be resumed before it
+	s.SaveCheckpoint("base")
+	if err := s.Rollback("base"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cms.Watch(ctx, metav1.ListOptions{ResourceVersion: a.ResourceVersion}); !apierrors.IsResourceExpired(err) {
+		t.Errorf("expected Expired for a resourceVersion before the restore, got %v", err)
+	}
+}
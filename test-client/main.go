@@ -45,9 +45,6 @@ func NewClient() (client.Client, error) {
 	cfg.QPS = 100
 	cfg.Burst = 100
 
-	// WARNING: must be set to application/json to avoid accidentally using protobuf encoding with fake-apiserver
-	cfg.AcceptContentTypes = runtime.ContentTypeJSON
-
 	hc, err := rest.HTTPClientFor(cfg)
 	if err != nil {
 		return nil, err